@@ -560,6 +560,63 @@ func TestB11r(t *testing.T) {
 	}
 }
 
+func TestVandal(t *testing.T) {
+	t.Parallel()
+	tt := new(testT8n)
+	tt.TestCmd = cmdtest.NewTestCmd(t, tt)
+	for i, tc := range []struct {
+		base        string
+		prestate    string
+		tx          string
+		returnData  bool
+		expOut      string
+		expExitCode int
+	}{
+		{ // SSTORE into an empty slot
+			base:       "./testdata/31",
+			prestate:   "prestate.json",
+			tx:         "tx.txt",
+			returnData: true,
+			expOut:     "exp.json",
+		},
+		{ // Same, without return data
+			base:     "./testdata/31",
+			prestate: "prestate.json",
+			tx:       "tx.txt",
+			expOut:   "exp_noreturndata.json",
+		},
+	} {
+		args := []string{"vandal", "--prestate", fmt.Sprintf("%v/%v", tc.base, tc.prestate)}
+		if tc.returnData {
+			args = append(args, "--returndata")
+		}
+		args = append(args, fmt.Sprintf("%v/%v", tc.base, tc.tx))
+
+		tt.Run("evm-test", args...)
+		tt.Logf("args:\n go run . %v\n", strings.Join(args, " "))
+		// Compare the expected output, if provided
+		if tc.expOut != "" {
+			want, err := os.ReadFile(fmt.Sprintf("%v/%v", tc.base, tc.expOut))
+			if err != nil {
+				t.Fatalf("test %d: could not read expected output: %v", i, err)
+			}
+			have := tt.Output()
+			ok, err := cmpJson(have, want)
+			switch {
+			case err != nil:
+				t.Logf(string(have))
+				t.Fatalf("test %d, json parsing failed: %v", i, err)
+			case !ok:
+				t.Fatalf("test %d: output wrong, have \n%v\nwant\n%v\n", i, string(have), string(want))
+			}
+		}
+		tt.WaitExit()
+		if have, want := tt.ExitStatus(), tc.expExitCode; have != want {
+			t.Fatalf("test %d: wrong exit code, have %d, want %d", i, have, want)
+		}
+	}
+}
+
 // cmpJson compares the JSON in two byte slices.
 func cmpJson(a, b []byte) (bool, error) {
 	var j, j2 interface{}
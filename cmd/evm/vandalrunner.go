@@ -0,0 +1,180 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/tests"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	vandalReturnDataFlag = &cli.BoolFlag{
+		Name:  "returndata",
+		Usage: "Capture the output of every step",
+	}
+	vandalOperandsFlag = &cli.BoolFlag{
+		Name:  "operands",
+		Usage: "Capture the stack items consumed by every step",
+	}
+	vandalStorageFlag = &cli.BoolFlag{
+		Name:  "storage",
+		Usage: "Record the storage slots read and written by every basic block",
+	}
+	vandalMemoryFlag = &cli.BoolFlag{
+		Name:  "memory",
+		Usage: "Record the memory range written by every step and its hash",
+	}
+	vandalMemoryDataFlag = &cli.BoolFlag{
+		Name:  "memorydata",
+		Usage: "Include the bytes written in the recorded memory writes",
+	}
+	vandalEdgesFlag = &cli.BoolFlag{
+		Name:  "edges",
+		Usage: "Print the jumps taken along with the basic blocks",
+	}
+	vandalContractsFlag = &cli.BoolFlag{
+		Name:  "contracts",
+		Usage: "Print the code executed at every address along with the basic blocks",
+	}
+	vandalStreamFlag = &cli.BoolFlag{
+		Name:  "stream",
+		Usage: "Print every basic block as soon as it is complete, one per line",
+	}
+	vandalFactsFlag = &cli.StringFlag{
+		Name:  "facts",
+		Usage: "Directory to write the trace into as Souffle facts, instead of printing it",
+	}
+)
+
+var vandalCommand = &cli.Command{
+	Action:    vandalCmd,
+	Name:      "vandal",
+	Usage:     "Replays a transaction on top of a prestate with the Vandal logger",
+	ArgsUsage: "<rawtx>",
+	Flags:     []cli.Flag{GenesisFlag, vandalReturnDataFlag, vandalOperandsFlag, vandalStorageFlag, vandalMemoryFlag, vandalMemoryDataFlag, vandalEdgesFlag, vandalContractsFlag, vandalStreamFlag, vandalFactsFlag},
+	Description: `
+The vandal command executes a single transaction on top of the given prestate
+and prints the basic blocks produced by the Vandal logger, without needing a
+synced node. The output of every step is only captured with --returndata,
+leaving the Ret fields null otherwise, and the stack items consumed by every
+step only with --operands. The storage slots read and written by every block
+are recorded with --storage. With --edges, the output becomes an object of the
+blocks and of the jumps taken, from which the control flow graph is rebuilt.
+With --contracts, the code executed at every address is printed along with
+the blocks, so that their pcs can be lined up with the disassembled code.
+
+With --facts, the trace is written into the given directory as the tab
+separated Datalog facts consumed by Souffle instead. With --stream, the blocks
+are printed one per line while the transaction is executed, rather than held
+in memory until it ends.
+
+The transaction is given as the hex encoded RLP (or typed envelope) of the
+signed transaction, either directly or as the name of a file containing it.
+
+The prestate is a genesis formatted JSON file passed via --prestate. Its alloc
+holds the accounts touched by the transaction, e.g. the output of the
+prestateTracer, and its number, timestamp, coinbase, difficulty, gasLimit,
+baseFeePerGas and mixHash describe the block the transaction is included in.
+The chain config defaults to mainnet if missing. The BLOCKHASH opcode returns
+the zero hash, as historical block hashes are not part of the prestate.`,
+}
+
+func vandalCmd(ctx *cli.Context) error {
+	if ctx.Args().Len() != 1 {
+		return errors.New("missing raw transaction")
+	}
+	if ctx.String(GenesisFlag.Name) == "" {
+		return errors.New("missing --prestate")
+	}
+	if ctx.IsSet(vandalFactsFlag.Name) && ctx.Bool(vandalStreamFlag.Name) {
+		return errors.New("--facts and --stream are mutually exclusive")
+	}
+	// Decode the transaction, reading it from file if one is named
+	input := ctx.Args().First()
+	if blob, err := os.ReadFile(input); err == nil {
+		input = string(blob)
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(common.FromHex(strings.TrimSpace(input))); err != nil {
+		return fmt.Errorf("invalid transaction: %v", err)
+	}
+	// Assemble the prestate and the block context of the transaction
+	genesis := readGenesis(ctx.String(GenesisFlag.Name))
+	if genesis.Config == nil {
+		genesis.Config = params.MainnetChainConfig
+	}
+	prestate := tests.MakePreState(rawdb.NewMemoryDatabase(), genesis.Alloc, false, rawdb.HashScheme)
+	defer prestate.Close()
+
+	var (
+		header   = genesis.ToBlock().Header()
+		config   = genesis.Config
+		blockCtx = core.NewEVMBlockContext(header, nil, &header.Coinbase)
+		signer   = types.MakeSigner(config, header.Number, header.Time)
+	)
+	blockCtx.GetHash = func(uint64) common.Hash { return common.Hash{} }
+
+	msg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+	if err != nil {
+		return fmt.Errorf("invalid transaction: %v", err)
+	}
+	// Execute the transaction with the Vandal logger attached
+	tconfig := &logger.VandalConfig{
+		EnableReturnData: ctx.Bool(vandalReturnDataFlag.Name),
+		EnableOperands:   ctx.Bool(vandalOperandsFlag.Name),
+		EnableStorage:    ctx.Bool(vandalStorageFlag.Name),
+		EnableMemory:     ctx.Bool(vandalMemoryFlag.Name),
+		EnableMemoryData: ctx.Bool(vandalMemoryDataFlag.Name),
+		EnableEdges:      ctx.Bool(vandalEdgesFlag.Name),
+		EnableContracts:  ctx.Bool(vandalContractsFlag.Name),
+	}
+	tracer := logger.NewVandalTracer(tconfig)
+	if ctx.Bool(vandalStreamFlag.Name) {
+		tracer = logger.NewVandalStreamLogger(tconfig, os.Stdout)
+	}
+	evm := vm.NewEVM(blockCtx, core.NewEVMTxContext(msg), prestate.StateDB, config, vm.Config{VandalLogger: tracer})
+
+	prestate.StateDB.SetTxContext(tx.Hash(), 0)
+	if _, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(msg.GasLimit)); err != nil {
+		return fmt.Errorf("transaction execution failed: %v", err)
+	}
+	if dir := ctx.String(vandalFactsFlag.Name); dir != "" {
+		return tracer.WriteFacts(dir)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		return err
+	}
+	if ctx.Bool(vandalStreamFlag.Name) {
+		fmt.Fprintln(os.Stderr, string(res)) // Summary of the streamed blocks
+		return nil
+	}
+	fmt.Println(string(res))
+	return nil
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/eth/catalyst"
 	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/eth/tracers"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/internal/flags"
 	"github.com/ethereum/go-ethereum/internal/version"
@@ -97,6 +98,7 @@ type gethConfig struct {
 	Node     node.Config
 	Ethstats ethstatsConfig
 	Metrics  metrics.Config
+	Vandal   tracers.VandalConfig
 }
 
 func loadConfig(file string, cfg *gethConfig) error {
@@ -164,6 +166,7 @@ func makeConfigNode(ctx *cli.Context) (*node.Node, gethConfig) {
 		cfg.Ethstats.URL = ctx.String(utils.EthStatsURLFlag.Name)
 	}
 	applyMetricConfig(ctx, &cfg)
+	utils.SetVandalConfig(ctx, &cfg.Vandal)
 
 	return stack, cfg
 }
@@ -206,6 +209,42 @@ func makeFullNode(ctx *cli.Context) (*node.Node, ethapi.Backend) {
 	if cfg.Ethstats.URL != "" {
 		utils.RegisterEthStatsService(stack, backend, cfg.Ethstats.URL)
 	}
+	// Configure the Vandal tracing RPC API.
+	utils.RegisterVandalAPI(stack, eth, &cfg.Vandal)
+
+	// Trace imported blocks with the Vandal logger if requested.
+	if ctx.Bool(utils.VandalLiveFlag.Name) {
+		if eth == nil {
+			utils.Fatalf("Live Vandal tracing requires a full node")
+		}
+		dir := stack.ResolvePath("vandal")
+		if ctx.IsSet(utils.VandalLiveDirFlag.Name) {
+			dir = ctx.String(utils.VandalLiveDirFlag.Name)
+		}
+		utils.RegisterVandalLiveService(stack, eth, &cfg.Vandal, dir)
+	}
+	// Trace pending transactions with the Vandal logger if requested.
+	if ctx.Bool(utils.VandalPendingFlag.Name) {
+		if eth == nil {
+			utils.Fatalf("Pending Vandal tracing requires a full node")
+		}
+		dir := stack.ResolvePath("vandal-pending")
+		if ctx.IsSet(utils.VandalPendingDirFlag.Name) {
+			dir = ctx.String(utils.VandalPendingDirFlag.Name)
+		}
+		utils.RegisterVandalPendingService(stack, eth, &cfg.Vandal, dir)
+	}
+	// Run Vandal block range traces as background jobs if requested.
+	if ctx.Bool(utils.VandalJobsFlag.Name) {
+		if eth == nil {
+			utils.Fatalf("Vandal trace jobs require a full node")
+		}
+		dir := stack.ResolvePath("vandal-jobs")
+		if ctx.IsSet(utils.VandalJobsDirFlag.Name) {
+			dir = ctx.String(utils.VandalJobsDirFlag.Name)
+		}
+		utils.RegisterVandalJobsService(stack, eth, &cfg.Vandal, dir, ctx.Int(utils.VandalJobsConcurrencyFlag.Name))
+	}
 	// Configure full-sync tester service if requested
 	if ctx.IsSet(utils.SyncTargetFlag.Name) {
 		hex := hexutil.MustDecode(ctx.String(utils.SyncTargetFlag.Name))
@@ -154,6 +154,28 @@ var (
 		utils.BeaconGenesisRootFlag,
 		utils.BeaconGenesisTimeFlag,
 		utils.BeaconCheckpointFlag,
+		utils.VandalMaxConcurrentFlag,
+		utils.VandalMaxStepsFlag,
+		utils.VandalTimeoutFlag,
+		utils.VandalReexecFlag,
+		utils.VandalStateFlag,
+		utils.VandalMaxReexecFlag,
+		utils.VandalMaxMemoryFlag,
+		utils.VandalMaxTraceStepsFlag,
+		utils.VandalReturnDataFlag,
+		utils.VandalPageSizeFlag,
+		utils.VandalAuthFlag,
+		utils.VandalUpstreamFlag,
+		utils.VandalUpstreamCacheFlag,
+		utils.VandalLiveFlag,
+		utils.VandalLiveDirFlag,
+		utils.VandalPendingFlag,
+		utils.VandalPendingDirFlag,
+		utils.VandalJobsFlag,
+		utils.VandalJobsDirFlag,
+		utils.VandalJobsConcurrencyFlag,
+		utils.VandalJobsWorkersFlag,
+		utils.VandalPipelineFlag,
 	}, utils.NetworkFlags, utils.DatabaseFlags)
 
 	rpcFlags = []cli.Flag{
@@ -177,6 +199,7 @@ var (
 		utils.WSApiFlag,
 		utils.WSAllowedOriginsFlag,
 		utils.WSPathPrefixFlag,
+		utils.WSCompressionFlag,
 		utils.IPCDisabledFlag,
 		utils.IPCPathFlag,
 		utils.InsecureUnlockAllowedFlag,
@@ -243,6 +266,11 @@ func init() {
 		snapshotCommand,
 		// See verkle.go
 		verkleCommand,
+		// See vandalcmd.go
+		vandalExportCommand,
+		vandalVerifyCommand,
+		// See tracebenchcmd.go
+		traceBenchCommand,
 	}
 	if logTestCommand != nil {
 		app.Commands = append(app.Commands, logTestCommand)
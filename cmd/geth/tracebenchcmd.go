@@ -0,0 +1,343 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	traceBenchIterationsFlag = &cli.IntFlag{
+		Name:  "iterations",
+		Usage: "Number of times every transaction is traced with every tracer, the fastest run being reported",
+		Value: 3,
+	}
+	traceBenchTracerFlag = &cli.StringSliceFlag{
+		Name:  "tracer",
+		Usage: "Tracers to benchmark (default = all)",
+	}
+	traceBenchFixtureFlag = &cli.StringSliceFlag{
+		Name:  "fixture",
+		Usage: "Reference transactions to trace (default = all)",
+	}
+	traceBenchJSONFlag = &cli.BoolFlag{
+		Name:  "json",
+		Usage: "Print the results as JSON instead of a table",
+	}
+
+	traceBenchCommand = &cli.Command{
+		Action: traceBench,
+		Name:   "trace-bench",
+		Usage:  "Benchmark the tracers on a fixed set of reference transactions",
+		Flags: []cli.Flag{
+			traceBenchIterationsFlag,
+			traceBenchTracerFlag,
+			traceBenchFixtureFlag,
+			traceBenchJSONFlag,
+		},
+		Description: `
+The trace-bench command executes a fixed set of reference transactions, bundled
+with geth, on an in-memory state with each tracer attached, and reports the
+steps traced per second, the allocations and the peak heap size of every run.
+The results of different geth versions on the same machine are comparable,
+making performance regressions of the tracers measurable.
+
+The "none" tracer executes the transactions without tracing, as a baseline. The
+"vandalReturnData" tracer is the Vandal logger capturing the output of every step.`,
+	}
+)
+
+// traceBenchFixture is a reference transaction, calling a contract with the
+// given code on an otherwise empty state.
+type traceBenchFixture struct {
+	name string
+	code string // Hex encoded contract code
+}
+
+// traceBenchFixtures are the reference transactions of the benchmark. Each is
+// a loop exercising a different part of the EVM.
+var traceBenchFixtures = []traceBenchFixture{
+	// 20000 iterations of arithmetic and jumps:
+	//   PUSH2 20000; JUMPDEST; PUSH1 1; SWAP1; SUB; DUP1; PUSH1 3; JUMPI; STOP
+	{name: "loop", code: "614e205b600190038060035700"},
+
+	// 1000 writes to distinct storage slots:
+	//   PUSH2 1000; JUMPDEST; DUP1; DUP1; SSTORE; PUSH1 1; SWAP1; SUB; DUP1; PUSH1 3; JUMPI; STOP
+	{name: "storage", code: "6103e85b808055600190038060035700"},
+
+	// 2000 memory writes, each followed by hashing the head of the memory:
+	//   PUSH2 2000; JUMPDEST; DUP1; DUP1; PUSH1 5; SHL; MSTORE; PUSH1 64; PUSH1 0; KECCAK256; POP;
+	//   PUSH1 1; SWAP1; SUB; DUP1; PUSH1 3; JUMPI; STOP
+	{name: "memory", code: "6107d05b808060051b52604060002050600190038060035700"},
+
+	// 2000 static calls to the identity precompile:
+	//   PUSH2 2000; JUMPDEST; PUSH1 32; PUSH1 0; PUSH1 32; PUSH1 0; PUSH1 4; GAS; STATICCALL; POP;
+	//   PUSH1 1; SWAP1; SUB; DUP1; PUSH1 3; JUMPI; STOP
+	{name: "calls", code: "6107d05b602060006020600060045afa50600190038060035700"},
+}
+
+// traceBenchTracers are the tracers benchmarked by default.
+var traceBenchTracers = []string{"none", "vandal", "vandalReturnData", "structLogger", "callTracer", "prestateTracer", "4byteTracer"}
+
+// traceBenchResult is the measurement of a fixture traced by a tracer.
+type traceBenchResult struct {
+	Fixture      string        `json:"fixture"`
+	Tracer       string        `json:"tracer"`
+	Steps        int           `json:"steps"`
+	Duration     time.Duration `json:"duration"`
+	StepsPerSec  float64       `json:"stepsPerSec"`
+	Allocs       uint64        `json:"allocs"`
+	AllocBytes   uint64        `json:"allocBytes"`
+	PeakHeap     uint64        `json:"peakHeap"`
+	ResultLength int           `json:"resultLength"`
+}
+
+func traceBench(ctx *cli.Context) error {
+	var (
+		fixtures = traceBenchFixtures
+		names    = traceBenchTracers
+	)
+	if ctx.IsSet(traceBenchFixtureFlag.Name) {
+		fixtures = nil
+		for _, name := range ctx.StringSlice(traceBenchFixtureFlag.Name) {
+			fixture, ok := findTraceBenchFixture(name)
+			if !ok {
+				return fmt.Errorf("unknown fixture %q", name)
+			}
+			fixtures = append(fixtures, fixture)
+		}
+	}
+	if ctx.IsSet(traceBenchTracerFlag.Name) {
+		names = ctx.StringSlice(traceBenchTracerFlag.Name)
+	}
+	iterations := ctx.Int(traceBenchIterationsFlag.Name)
+	if iterations <= 0 {
+		iterations = 1
+	}
+	var results []*traceBenchResult
+	for _, fixture := range fixtures {
+		for _, name := range names {
+			res, err := runTraceBench(fixture, name, iterations)
+			if err != nil {
+				return fmt.Errorf("fixture %s, tracer %s: %w", fixture.name, name, err)
+			}
+			results = append(results, res)
+		}
+	}
+	if ctx.Bool(traceBenchJSONFlag.Name) {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Fixture", "Tracer", "Steps", "Time", "Steps/sec", "Allocs", "Alloc bytes", "Peak heap"})
+	for _, res := range results {
+		table.Append([]string{
+			res.Fixture,
+			res.Tracer,
+			fmt.Sprint(res.Steps),
+			common.PrettyDuration(res.Duration).String(),
+			fmt.Sprintf("%.0f", res.StepsPerSec),
+			fmt.Sprint(res.Allocs),
+			common.StorageSize(res.AllocBytes).String(),
+			common.StorageSize(res.PeakHeap).String(),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+// findTraceBenchFixture looks up a reference transaction by name.
+func findTraceBenchFixture(name string) (traceBenchFixture, bool) {
+	for _, fixture := range traceBenchFixtures {
+		if fixture.name == name {
+			return fixture, true
+		}
+	}
+	return traceBenchFixture{}, false
+}
+
+// runTraceBench traces the fixture with the named tracer the given number of
+// times, reporting the fastest run.
+func runTraceBench(fixture traceBenchFixture, name string, iterations int) (*traceBenchResult, error) {
+	// Count the steps of the transaction once, with the Vandal logger
+	counter := logger.NewVandalTracer(nil)
+	if _, err := execTraceBench(fixture, vm.Config{VandalLogger: counter}); err != nil {
+		return nil, err
+	}
+	var best *traceBenchResult
+	for i := 0; i < iterations; i++ {
+		res, err := measureTraceBench(fixture, name)
+		if err != nil {
+			return nil, err
+		}
+		if best == nil || res.Duration < best.Duration {
+			best = res
+		}
+	}
+	best.Steps = counter.Steps()
+	best.StepsPerSec = float64(best.Steps) / best.Duration.Seconds()
+	return best, nil
+}
+
+// measureTraceBench executes the fixture once with the named tracer attached
+// and produces its result, measuring the time and memory used.
+func measureTraceBench(fixture traceBenchFixture, name string) (*traceBenchResult, error) {
+	var (
+		cfg    vm.Config
+		result func() (json.RawMessage, error)
+	)
+	switch name {
+	case "none":
+		result = func() (json.RawMessage, error) { return nil, nil }
+	case "vandal", "vandalReturnData":
+		tracer := logger.NewVandalTracer(&logger.VandalConfig{EnableReturnData: name == "vandalReturnData"})
+		cfg.VandalLogger, result = tracer, tracer.GetResult
+	case "structLogger":
+		tracer := logger.NewStructLogger(nil)
+		cfg.Tracer, result = tracer, tracer.GetResult
+	default:
+		tracer, err := tracers.DefaultDirectory.New(name, new(tracers.Context), nil)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Tracer, result = tracer, tracer.GetResult
+	}
+	// Sample the heap size while tracing, starting from a clean slate
+	runtime.GC()
+	var (
+		before, after runtime.MemStats
+		peak          atomic.Uint64
+		done          = make(chan struct{})
+		sampled       = make(chan struct{})
+	)
+	runtime.ReadMemStats(&before)
+	peak.Store(before.HeapAlloc)
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+
+		var stats runtime.MemStats
+		for {
+			select {
+			case <-ticker.C:
+				runtime.ReadMemStats(&stats)
+				if stats.HeapAlloc > peak.Load() {
+					peak.Store(stats.HeapAlloc)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	start := time.Now()
+	_, err := execTraceBench(fixture, cfg)
+	var res json.RawMessage
+	if err == nil {
+		res, err = result()
+	}
+	elapsed := time.Since(start)
+
+	close(done)
+	<-sampled
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		return nil, err
+	}
+	if after.HeapAlloc > peak.Load() {
+		peak.Store(after.HeapAlloc)
+	}
+	return &traceBenchResult{
+		Fixture:      fixture.name,
+		Tracer:       name,
+		Duration:     elapsed,
+		Allocs:       after.Mallocs - before.Mallocs,
+		AllocBytes:   after.TotalAlloc - before.TotalAlloc,
+		PeakHeap:     peak.Load() - before.HeapAlloc,
+		ResultLength: len(res),
+	}, nil
+}
+
+// execTraceBench executes the fixture's transaction on a fresh in-memory state
+// with the given EVM configuration.
+func execTraceBench(fixture traceBenchFixture, cfg vm.Config) (*core.ExecutionResult, error) {
+	var (
+		config   = params.MergedTestChainConfig
+		sender   = common.HexToAddress("0x71562b71999873db5b286df957af199ec94617f7")
+		contract = common.HexToAddress("0xbe0c1b")
+		gasLimit = uint64(30_000_000)
+	)
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		return nil, err
+	}
+	statedb.SetBalance(sender, uint256.NewInt(params.Ether))
+	statedb.SetCode(contract, common.FromHex(fixture.code))
+
+	blockCtx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		BlockNumber: big.NewInt(1),
+		Time:        1,
+		Difficulty:  new(big.Int),
+		Random:      new(common.Hash),
+		GasLimit:    gasLimit,
+		BaseFee:     new(big.Int),
+		BlobBaseFee: new(big.Int),
+	}
+	msg := &core.Message{
+		From:      sender,
+		To:        &contract,
+		Value:     new(big.Int),
+		GasLimit:  gasLimit,
+		GasPrice:  new(big.Int),
+		GasFeeCap: new(big.Int),
+		GasTipCap: new(big.Int),
+	}
+	cfg.NoBaseFee = true
+	evm := vm.NewEVM(blockCtx, core.NewEVMTxContext(msg), statedb, config, cfg)
+
+	res, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(gasLimit))
+	if err != nil {
+		return nil, err
+	}
+	if res.Failed() {
+		return nil, fmt.Errorf("execution failed: %w", res.Err)
+	}
+	return res, nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import "testing"
+
+// Tests that every reference transaction of the trace benchmark executes
+// successfully and produces a trace.
+func TestTraceBenchFixtures(t *testing.T) {
+	t.Parallel()
+	for _, fixture := range traceBenchFixtures {
+		res, err := runTraceBench(fixture, "vandal", 1)
+		if err != nil {
+			t.Fatalf("fixture %s: %v", fixture.name, err)
+		}
+		if res.Steps == 0 || res.ResultLength == 0 {
+			t.Errorf("fixture %s: empty trace, steps %d, result length %d", fixture.name, res.Steps, res.ResultLength)
+		}
+	}
+	if _, err := runTraceBench(traceBenchFixtures[0], "callTracer", 1); err != nil {
+		t.Fatalf("callTracer: %v", err)
+	}
+}
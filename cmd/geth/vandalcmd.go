@@ -0,0 +1,628 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/internal/flags"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	vandalWorkersFlag = &cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of blocks to trace concurrently",
+		Value: runtime.NumCPU(),
+	}
+	vandalPerBlockFlag = &cli.BoolFlag{
+		Name:  "perblock",
+		Usage: "Write one file per block instead of one file per transaction",
+	}
+	vandalGzipFlag = &cli.BoolFlag{
+		Name:  "gzip",
+		Usage: "Gzip compress the exported files",
+	}
+	vandalReturnDataFlag = &cli.BoolFlag{
+		Name:  "returndata",
+		Usage: "Capture the output of every step (increases trace size)",
+	}
+	vandalOperandsFlag = &cli.BoolFlag{
+		Name:  "operands",
+		Usage: "Capture the stack items consumed by every step (increases trace size)",
+	}
+
+	vandalExportCommand = &cli.Command{
+		Action:    vandalExport,
+		Name:      "vandal-export",
+		Usage:     "Trace a block range with the Vandal logger and export the results",
+		ArgsUsage: "<outdir> <blockNumFirst> <blockNumLast>",
+		Flags: flags.Merge([]cli.Flag{
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+			vandalWorkersFlag,
+			vandalPerBlockFlag,
+			vandalGzipFlag,
+			vandalReturnDataFlag,
+			vandalOperandsFlag,
+		}, utils.NetworkFlags, utils.DatabaseFlags),
+		Description: `
+The vandal-export command re-executes every transaction in the given block
+range (both ends included) with the Vandal logger attached, and writes the
+resulting basic blocks as JSON into the output directory. The output of every
+step is only captured with --returndata, leaving the Ret fields null otherwise,
+and the stack items consumed by every step only with --operands.
+
+By default one file named <number>_<index>_<txhash>.json is written per
+transaction. With --perblock, one file named <number>_<blockhash>.json is
+written per block, holding the results of all its transactions. Files get a
+.gz suffix when --gzip is set. Every transaction's result is stamped with the
+client version, chain, fork, logger configuration hash and capture time.
+
+The state of the parent of every traced block must be available locally,
+which in practice requires an archive node.`,
+	}
+	vandalVerifyCommand = &cli.Command{
+		Action:    vandalVerify,
+		Name:      "vandal-verify",
+		Usage:     "Verify exported Vandal traces of a block range against the local chain",
+		ArgsUsage: "<dir> <blockNumFirst> <blockNumLast>",
+		Flags: flags.Merge([]cli.Flag{
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+		}, utils.NetworkFlags, utils.DatabaseFlags),
+		Description: `
+The vandal-verify command cross-checks the Vandal traces stored in a directory
+against the canonical blocks of the local chain in the given range (both ends
+included), and reports every discrepancy found:
+
+ - blocks without traces, or traced as a non-canonical block
+ - per-block files whose transaction count or hashes differ from the block's
+ - missing, unexpected or mismatching per-transaction files
+ - transactions whose trace failed, or files that cannot be decoded
+ - incomplete files left over by an interrupted writer
+ - traces whose LOG opcodes outside of reverted call frames differ in number
+   from the logs of the transaction's receipt
+ - traces exported with a gas usage differing from the receipt's
+
+Both layouts written by vandal-export are understood, compressed or not, as
+well as the per-block files of the live tracer and of Vandal jobs. The command
+fails if any discrepancy is found.`,
+	}
+)
+
+// vandalExportResult is the trace of a single transaction as exported by the
+// vandal-export command, stamped with how it was captured along with the gas
+// used by the transaction. Traces stored by the live tracer and Vandal jobs
+// share the format without the gas used, but may carry the error of a failed
+// trace instead of a result.
+type vandalExportResult struct {
+	TxHash  common.Hash              `json:"txHash"`
+	GasUsed hexutil.Uint64           `json:"gasUsed,omitempty"`
+	Result  json.RawMessage          `json:"result"`
+	Error   string                   `json:"error,omitempty"`
+	Meta    *tracers.VandalTraceMeta `json:"meta,omitempty"`
+}
+
+// vandalTraceFile is a file of a Vandal trace directory, holding the traces of
+// either a whole block or a single transaction.
+type vandalTraceFile struct {
+	path   string
+	number uint64
+	index  int         // Transaction index, -1 for a whole block
+	hash   common.Hash // Block hash, or transaction hash for a single transaction
+}
+
+// vandalExporter traces blocks from a local chain and writes the Vandal
+// results into an output directory.
+type vandalExporter struct {
+	chain      *core.BlockChain
+	outdir     string
+	perBlock   bool
+	gzip       bool
+	returnData bool
+	operands   bool
+}
+
+func vandalExport(ctx *cli.Context) error {
+	if ctx.Args().Len() != 3 {
+		utils.Fatalf("This command requires three arguments.")
+	}
+	first, ferr := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	last, lerr := strconv.ParseUint(ctx.Args().Get(2), 10, 64)
+	if ferr != nil || lerr != nil {
+		utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
+	}
+	if first == 0 {
+		utils.Fatalf("Export error: genesis is not traceable\n")
+	}
+	if first > last {
+		utils.Fatalf("Export error: first block %d larger than last block %d\n", first, last)
+	}
+	outdir := ctx.Args().First()
+	if err := os.MkdirAll(outdir, 0755); err != nil {
+		utils.Fatalf("Failed to create output directory: %v", err)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, db := utils.MakeChain(ctx, stack, true)
+	defer db.Close()
+
+	if head := chain.CurrentBlock(); last > head.Number.Uint64() {
+		utils.Fatalf("Export error: block number %d larger than head block %d\n", last, head.Number.Uint64())
+	}
+	exporter := &vandalExporter{
+		chain:      chain,
+		outdir:     outdir,
+		perBlock:   ctx.Bool(vandalPerBlockFlag.Name),
+		gzip:       ctx.Bool(vandalGzipFlag.Name),
+		returnData: ctx.Bool(vandalReturnDataFlag.Name),
+		operands:   ctx.Bool(vandalOperandsFlag.Name),
+	}
+	start := time.Now()
+	if err := exporter.export(first, last, ctx.Int(vandalWorkersFlag.Name)); err != nil {
+		utils.Fatalf("Export error: %v\n", err)
+	}
+	fmt.Printf("Export done in %v\n", time.Since(start))
+	return nil
+}
+
+// export traces all blocks in the range [first, last] using the given number
+// of concurrent workers. The first error encountered aborts the export.
+func (e *vandalExporter) export(first, last uint64, workers int) error {
+	if workers <= 0 {
+		workers = 1
+	}
+	if blocks := int(last - first + 1); workers > blocks {
+		workers = blocks
+	}
+	var (
+		pend   sync.WaitGroup
+		jobs   = make(chan uint64, workers)
+		abort  = make(chan struct{})
+		failed error
+		once   sync.Once
+		traced atomic.Uint64
+		begin  = time.Now()
+	)
+	for i := 0; i < workers; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+
+			for number := range jobs {
+				txs, err := e.exportBlock(number)
+				if err != nil {
+					once.Do(func() {
+						failed = fmt.Errorf("block #%d: %w", number, err)
+						close(abort)
+					})
+					return
+				}
+				traced.Add(uint64(txs))
+			}
+		}()
+	}
+	logged := time.Now()
+feed:
+	for number := first; number <= last; number++ {
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting Vandal traces", "first", first, "last", last, "current", number, "transactions", traced.Load(), "elapsed", common.PrettyDuration(time.Since(begin)))
+			logged = time.Now()
+		}
+		select {
+		case jobs <- number:
+		case <-abort:
+			break feed
+		}
+	}
+	close(jobs)
+	pend.Wait()
+
+	if failed != nil {
+		return failed
+	}
+	log.Info("Exported Vandal traces", "first", first, "last", last, "transactions", traced.Load(), "elapsed", common.PrettyDuration(time.Since(begin)))
+	return nil
+}
+
+// exportBlock traces all transactions of the given block on top of its parent
+// state and writes out the results, returning the number of transactions.
+func (e *vandalExporter) exportBlock(number uint64) (int, error) {
+	block := e.chain.GetBlockByNumber(number)
+	if block == nil {
+		return 0, errors.New("block not found")
+	}
+	parent := e.chain.GetHeader(block.ParentHash(), number-1)
+	if parent == nil {
+		return 0, errors.New("parent block not found")
+	}
+	statedb, err := e.chain.StateAt(parent.Root)
+	if err != nil {
+		return 0, fmt.Errorf("parent state not available: %w", err)
+	}
+	var (
+		config   = e.chain.Config()
+		signer   = types.MakeSigner(config, block.Number(), block.Time())
+		blockCtx = core.NewEVMBlockContext(block.Header(), e.chain, nil)
+		is158    = config.IsEIP158(block.Number())
+		tconfig  = &logger.VandalConfig{EnableReturnData: e.returnData, EnableOperands: e.operands}
+		meta     = tracers.NewVandalTraceMeta(config, block.Header(), tconfig)
+		results  = make([]*vandalExportResult, 0, len(block.Transactions()))
+	)
+	if beaconRoot := block.BeaconRoot(); beaconRoot != nil {
+		vmenv := vm.NewEVM(blockCtx, vm.TxContext{}, statedb, config, vm.Config{})
+		core.ProcessBeaconBlockRoot(*beaconRoot, vmenv, statedb)
+	}
+	for i, tx := range block.Transactions() {
+		msg, err := core.TransactionToMessage(tx, signer, block.BaseFee())
+		if err != nil {
+			return 0, fmt.Errorf("transaction %#x: %w", tx.Hash(), err)
+		}
+		tracer := logger.NewVandalTracer(tconfig)
+		vmenv := vm.NewEVM(blockCtx, core.NewEVMTxContext(msg), statedb, config, vm.Config{VandalLogger: tracer})
+
+		statedb.SetTxContext(tx.Hash(), i)
+		exec, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.GasLimit))
+		if err != nil {
+			return 0, fmt.Errorf("transaction %#x: %w", tx.Hash(), err)
+		}
+		statedb.Finalise(is158)
+
+		res, err := tracer.GetResult()
+		if err != nil {
+			return 0, fmt.Errorf("transaction %#x: %w", tx.Hash(), err)
+		}
+		result := &vandalExportResult{TxHash: tx.Hash(), GasUsed: hexutil.Uint64(exec.UsedGas), Result: res, Meta: meta}
+		if e.perBlock {
+			results = append(results, result)
+			continue
+		}
+		if err := e.write(fmt.Sprintf("%d_%d_%#x.json", number, i, tx.Hash()), result); err != nil {
+			return 0, err
+		}
+	}
+	if e.perBlock {
+		if err := e.write(fmt.Sprintf("%d_%#x.json", number, block.Hash()), results); err != nil {
+			return 0, err
+		}
+	}
+	return len(block.Transactions()), nil
+}
+
+// write encodes v as JSON into the named file within the output directory,
+// compressing it if requested.
+func (e *vandalExporter) write(name string, v interface{}) error {
+	if e.gzip {
+		name += ".gz"
+	}
+	fh, err := os.Create(filepath.Join(e.outdir, name))
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	if !e.gzip {
+		return json.NewEncoder(fh).Encode(v)
+	}
+	gz := gzip.NewWriter(fh)
+	if err := json.NewEncoder(gz).Encode(v); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func vandalVerify(ctx *cli.Context) error {
+	if ctx.Args().Len() != 3 {
+		utils.Fatalf("This command requires three arguments.")
+	}
+	first, ferr := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	last, lerr := strconv.ParseUint(ctx.Args().Get(2), 10, 64)
+	if ferr != nil || lerr != nil {
+		utils.Fatalf("Verify error in parsing parameters: block number not an integer\n")
+	}
+	if first > last {
+		utils.Fatalf("Verify error: first block %d larger than last block %d\n", first, last)
+	}
+	files, incomplete, err := readVandalTraceDir(ctx.Args().First())
+	if err != nil {
+		utils.Fatalf("Failed to read trace directory: %v", err)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, db := utils.MakeChain(ctx, stack, true)
+	defer db.Close()
+
+	var (
+		problems int
+		txs      int
+		start    = time.Now()
+		logged   = time.Now()
+	)
+	for _, path := range incomplete {
+		fmt.Printf("%s: incomplete file\n", path)
+		problems++
+	}
+	for number := first; number <= last; number++ {
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Verifying Vandal traces", "first", first, "last", last, "current", number, "discrepancies", problems)
+			logged = time.Now()
+		}
+		block := chain.GetBlockByNumber(number)
+		if block == nil {
+			utils.Fatalf("Verify error: block #%d not found\n", number)
+		}
+		receipts := rawdb.ReadReceipts(db, block.Hash(), number, block.Time(), chain.Config())
+		if receipts == nil && len(block.Transactions()) > 0 {
+			log.Warn("Receipts not available, logs and gas not verified", "number", number)
+		}
+		for _, problem := range verifyVandalBlock(block, receipts, files[number]) {
+			fmt.Printf("block #%d: %s\n", number, problem)
+			problems++
+		}
+		txs += len(block.Transactions())
+	}
+	fmt.Printf("Verified %d blocks with %d transactions in %v, %d discrepancies\n", last-first+1, txs, common.PrettyDuration(time.Since(start)), problems)
+	if problems > 0 {
+		return fmt.Errorf("%d discrepancies found", problems)
+	}
+	return nil
+}
+
+// readVandalTraceDir indexes the trace files of the given directory by block
+// number, also returning the incomplete files. Other files are ignored.
+func readVandalTraceDir(dir string) (map[uint64][]*vandalTraceFile, []string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		files      = make(map[uint64][]*vandalTraceFile)
+		incomplete []string
+	)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if strings.HasSuffix(entry.Name(), ".json.tmp") {
+			incomplete = append(incomplete, path)
+			continue
+		}
+		if file := parseVandalTraceFile(path); file != nil {
+			files[file.number] = append(files[file.number], file)
+		}
+	}
+	return files, incomplete, nil
+}
+
+// parseVandalTraceFile parses the name of a trace file, either
+// <number>_<blockhash>.json or <number>_<index>_<txhash>.json, optionally
+// gzipped. Nil is returned for other files.
+func parseVandalTraceFile(path string) *vandalTraceFile {
+	name := strings.TrimSuffix(filepath.Base(path), ".gz")
+	if !strings.HasSuffix(name, ".json") {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSuffix(name, ".json"), "_")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil
+	}
+	number, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+	file := &vandalTraceFile{path: path, number: number, index: -1}
+	if len(parts) == 3 {
+		if file.index, err = strconv.Atoi(parts[1]); err != nil || file.index < 0 {
+			return nil
+		}
+	}
+	hash := parts[len(parts)-1]
+	if len(hash) != 2+2*common.HashLength || !strings.HasPrefix(hash, "0x") {
+		return nil
+	}
+	file.hash = common.HexToHash(hash)
+	return file
+}
+
+// verifyVandalBlock checks the trace files of a block against it and against
+// its receipts if available, returning the discrepancies found.
+func verifyVandalBlock(block *types.Block, receipts types.Receipts, files []*vandalTraceFile) []string {
+	var (
+		problems []string
+		txs      = block.Transactions()
+		traced   = make(map[int]bool) // Transactions with a per-transaction file
+		complete bool                 // Whether a per-block file of the block exists
+	)
+	receipt := func(index int) *types.Receipt {
+		if len(receipts) != len(txs) {
+			return nil
+		}
+		return receipts[index]
+	}
+	for _, file := range files {
+		if file.index < 0 {
+			if file.hash != block.Hash() {
+				problems = append(problems, fmt.Sprintf("%s: traces non-canonical block %#x", file.path, file.hash))
+				continue
+			}
+			complete = true
+
+			var results []*vandalExportResult
+			if err := readVandalTraceFile(file.path, &results); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", file.path, err))
+				continue
+			}
+			if len(results) != len(txs) {
+				problems = append(problems, fmt.Sprintf("%s: %d traces, block has %d transactions", file.path, len(results), len(txs)))
+			}
+			for i, res := range results {
+				if i < len(txs) {
+					problems = append(problems, res.verify(file.path, i, txs[i].Hash(), receipt(i))...)
+				}
+			}
+			continue
+		}
+		if file.index >= len(txs) || txs[file.index].Hash() != file.hash {
+			problems = append(problems, fmt.Sprintf("%s: not transaction %d of the block", file.path, file.index))
+			continue
+		}
+		traced[file.index] = true
+
+		res := new(vandalExportResult)
+		if err := readVandalTraceFile(file.path, res); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", file.path, err))
+			continue
+		}
+		problems = append(problems, res.verify(file.path, file.index, file.hash, receipt(file.index))...)
+	}
+	if !complete {
+		if len(traced) == 0 && len(txs) > 0 {
+			problems = append(problems, "no traces")
+		} else {
+			for i, tx := range txs {
+				if !traced[i] {
+					problems = append(problems, fmt.Sprintf("transaction %d (%#x) not traced", i, tx.Hash()))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// verify checks the trace of the index-th transaction of a block, with the
+// given hash, against the transaction's receipt if not nil, returning the
+// discrepancies found.
+func (res *vandalExportResult) verify(path string, index int, hash common.Hash, receipt *types.Receipt) []string {
+	switch {
+	case res.TxHash != hash:
+		return []string{fmt.Sprintf("%s: trace %d is of transaction %#x, want %#x", path, index, res.TxHash, hash)}
+	case res.Error != "":
+		return []string{fmt.Sprintf("%s: trace of transaction %d failed: %s", path, index, res.Error)}
+	case len(res.Result) == 0 || res.Result[0] != '[':
+		return []string{fmt.Sprintf("%s: trace of transaction %d has no basic blocks", path, index)}
+	}
+	if receipt == nil {
+		return nil
+	}
+	var problems []string
+	if res.GasUsed != 0 && uint64(res.GasUsed) != receipt.GasUsed {
+		problems = append(problems, fmt.Sprintf("%s: transaction %d used %d gas, receipt has %d", path, index, res.GasUsed, receipt.GasUsed))
+	}
+	logs, err := countVandalLogs(res.Result)
+	if err != nil {
+		return append(problems, fmt.Sprintf("%s: trace of transaction %d: %v", path, index, err))
+	}
+	if logs != len(receipt.Logs) {
+		problems = append(problems, fmt.Sprintf("%s: transaction %d emitted %d logs, receipt has %d", path, index, logs, len(receipt.Logs)))
+	}
+	return problems
+}
+
+// countVandalLogs returns the number of LOG opcodes of a trace executed in call
+// frames whose changes were kept, i.e. neither they nor their callers failed.
+//
+// The blocks of failed frames are marked, but not those of the frames they
+// called, so the frames are followed through the depth and call index of the
+// steps. A frame is entered at the first step of a new call index one level
+// deeper, the steps of a frame preceding those of the frames it calls.
+func countVandalLogs(trace json.RawMessage) (int, error) {
+	var blocks []struct {
+		Ops []struct {
+			Op        vm.OpCode
+			Depth     int
+			CallIndex int
+		}
+		Failed bool
+	}
+	if err := json.Unmarshal(trace, &blocks); err != nil {
+		return 0, err
+	}
+	type frame struct {
+		depth, call int
+		reverted    bool
+	}
+	var (
+		frames []frame // Frames from the outermost to the one executing
+		logs   int
+	)
+	for _, block := range blocks {
+		for _, op := range block.Ops {
+			for len(frames) > 0 {
+				top := frames[len(frames)-1]
+				if top.depth < op.Depth || (top.depth == op.Depth && top.call == op.CallIndex) {
+					break
+				}
+				frames = frames[:len(frames)-1]
+			}
+			if len(frames) == 0 || frames[len(frames)-1].depth < op.Depth {
+				reverted := block.Failed || (len(frames) > 0 && frames[len(frames)-1].reverted)
+				frames = append(frames, frame{depth: op.Depth, call: op.CallIndex, reverted: reverted})
+			}
+			if op.Op >= vm.LOG0 && op.Op <= vm.LOG4 && !frames[len(frames)-1].reverted {
+				logs++
+			}
+		}
+	}
+	return logs, nil
+}
+
+// readVandalTraceFile decodes the JSON content of a trace file into v,
+// decompressing it if gzipped.
+func readVandalTraceFile(path string, v interface{}) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var r io.Reader = fh
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(fh)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return json.NewDecoder(r).Decode(v)
+}
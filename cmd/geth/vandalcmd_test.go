@@ -0,0 +1,149 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Tests that the traces exported for a block are checked against it, in both
+// the per-block and per-transaction layouts.
+func TestVandalVerifyBlock(t *testing.T) {
+	t.Parallel()
+
+	var txs []*types.Transaction
+	for i := uint64(0); i < 3; i++ {
+		txs = append(txs, types.NewTransaction(i, common.Address{1}, big.NewInt(1), 21000, big.NewInt(1), nil))
+	}
+	header := &types.Header{Number: big.NewInt(10), Difficulty: big.NewInt(1)}
+	block := types.NewBlock(header, txs, nil, nil, trie.NewStackTrie(nil))
+
+	// The first transaction emits a log in its top frame and a log in a frame
+	// called by a reverted one, which does not count
+	var (
+		logging = json.RawMessage(`[
+			{"Ops":[{"Op":160,"Depth":1,"CallIndex":0},{"Op":241,"Depth":1,"CallIndex":0}]},
+			{"Ops":[{"Op":241,"Depth":2,"CallIndex":1}],"Failed":true},
+			{"Ops":[{"Op":161,"Depth":3,"CallIndex":2}]},
+			{"Ops":[{"Op":253,"Depth":2,"CallIndex":1}],"Failed":true},
+			{"Ops":[{"Op":0,"Depth":1,"CallIndex":0}]}
+		]`)
+		receipts = func(logs int, gas uint64) types.Receipts {
+			receipts := make(types.Receipts, len(txs))
+			for i := range receipts {
+				receipts[i] = &types.Receipt{GasUsed: gas}
+			}
+			receipts[0].Logs = make([]*types.Log, logs)
+			return receipts
+		}
+		valid = receipts(1, 21000)
+	)
+	traces := func(n int) []*vandalExportResult {
+		results := make([]*vandalExportResult, n)
+		for i := range results {
+			results[i] = &vandalExportResult{TxHash: txs[i].Hash(), GasUsed: 21000, Result: json.RawMessage(`[]`)}
+		}
+		results[0].Result = logging
+		return results
+	}
+	write := func(dir, name string, v interface{}) string {
+		blob, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, blob, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name     string
+		files    func(dir string)
+		receipts types.Receipts // Receipts of the block, consistent ones if nil
+		problems int
+	}{
+		{"empty", func(dir string) {}, nil, 1},
+		{"block", func(dir string) {
+			write(dir, fmt.Sprintf("10_%#x.json", block.Hash()), traces(3))
+		}, nil, 0},
+		{"block-short", func(dir string) {
+			write(dir, fmt.Sprintf("10_%#x.json", block.Hash()), traces(2))
+		}, nil, 1},
+		{"block-failed", func(dir string) {
+			results := traces(3)
+			results[1] = &vandalExportResult{TxHash: txs[1].Hash(), Error: "execution timeout"}
+			write(dir, fmt.Sprintf("10_%#x.json", block.Hash()), results)
+		}, nil, 1},
+		{"block-reorged", func(dir string) {
+			write(dir, fmt.Sprintf("10_%#x.json", common.Hash{1}), traces(3))
+		}, nil, 2},
+		{"txs", func(dir string) {
+			for i, res := range traces(3) {
+				write(dir, fmt.Sprintf("10_%d_%#x.json", i, res.TxHash), res)
+			}
+		}, nil, 0},
+		{"txs-missing", func(dir string) {
+			for i, res := range traces(3)[:2] {
+				write(dir, fmt.Sprintf("10_%d_%#x.json", i, res.TxHash), res)
+			}
+		}, nil, 1},
+		{"txs-misplaced", func(dir string) {
+			for i, res := range traces(3) {
+				write(dir, fmt.Sprintf("10_%d_%#x.json", 2-i, res.TxHash), res)
+			}
+		}, nil, 4},
+		{"logs", func(dir string) {
+			write(dir, fmt.Sprintf("10_%#x.json", block.Hash()), traces(3))
+		}, receipts(2, 21000), 1},
+		{"gas", func(dir string) {
+			write(dir, fmt.Sprintf("10_%#x.json", block.Hash()), traces(3))
+		}, receipts(1, 30000), 3},
+		{"gas-unrecorded", func(dir string) {
+			results := traces(3)
+			for _, res := range results {
+				res.GasUsed = 0
+			}
+			write(dir, fmt.Sprintf("10_%#x.json", block.Hash()), results)
+		}, receipts(1, 30000), 0},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		tt.files(dir)
+
+		files, _, err := readVandalTraceDir(dir)
+		if err != nil {
+			t.Fatalf("%s: failed to read traces: %v", tt.name, err)
+		}
+		receipts := tt.receipts
+		if receipts == nil {
+			receipts = valid
+		}
+		if problems := verifyVandalBlock(block, receipts, files[10]); len(problems) != tt.problems {
+			t.Errorf("%s: discrepancy count mismatch: have %d, want %d: %q", tt.name, len(problems), tt.problems, problems)
+		}
+	}
+}
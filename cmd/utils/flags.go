@@ -595,6 +595,121 @@ var (
 		Category: flags.LoggingCategory,
 	}
 
+	// Vandal tracing settings
+	VandalTimeoutFlag = &cli.DurationFlag{
+		Name:     "vandal.timeout",
+		Usage:    "Default timeout of a single transaction traced via vandal RPC, unless set by the request",
+		Category: flags.VandalCategory,
+	}
+	VandalReexecFlag = &cli.Uint64Flag{
+		Name:     "vandal.reexec",
+		Usage:    "Default number of blocks re-executed to regenerate missing state via vandal RPC, unless set by the request",
+		Category: flags.VandalCategory,
+	}
+	VandalStateFlag = &cli.StringFlag{
+		Name:     "vandal.state",
+		Usage:    `Vandal tracing of blocks without available state ("reexec" to regenerate it, "available" to refuse)`,
+		Value:    tracers.VandalStateReexec,
+		Category: flags.VandalCategory,
+	}
+	VandalMaxReexecFlag = &cli.Uint64Flag{
+		Name:     "vandal.maxreexec",
+		Usage:    "Maximum number of blocks a Vandal trace request may re-execute to regenerate state (0 = unlimited)",
+		Category: flags.VandalCategory,
+	}
+	VandalMaxMemoryFlag = &cli.Uint64Flag{
+		Name:     "vandal.maxmemory",
+		Usage:    "Maximum memory in bytes a single Vandal transaction trace may use before it is aborted (0 = unlimited)",
+		Category: flags.VandalCategory,
+	}
+	VandalMaxTraceStepsFlag = &cli.IntFlag{
+		Name:     "vandal.maxtracesteps",
+		Usage:    "Maximum number of steps of a single Vandal transaction trace, further steps being truncated (0 = unlimited)",
+		Category: flags.VandalCategory,
+	}
+	VandalReturnDataFlag = &cli.BoolFlag{
+		Name:     "vandal.returndata",
+		Usage:    "Capture the output of every step in Vandal traces by default (increases trace size)",
+		Category: flags.VandalCategory,
+	}
+	VandalPageSizeFlag = &cli.IntFlag{
+		Name:     "vandal.pagesize",
+		Usage:    "Maximum size in bytes of a vandal RPC transaction trace, larger traces are paginated (0 = unlimited)",
+		Category: flags.VandalCategory,
+	}
+	VandalAuthFlag = &cli.BoolFlag{
+		Name:     "vandal.authrpc",
+		Usage:    "Serve the Vandal tracing APIs on the JWT authenticated RPC endpoint only (see --authrpc.*)",
+		Category: flags.VandalCategory,
+	}
+	VandalUpstreamFlag = &cli.StringFlag{
+		Name:     "vandal.upstream",
+		Usage:    "RPC endpoint of a trusted archive node to forward vandal RPC traces to instead of tracing locally",
+		Category: flags.VandalCategory,
+	}
+	VandalUpstreamCacheFlag = &cli.IntFlag{
+		Name:     "vandal.upstream.cache",
+		Usage:    "Number of vandal RPC traces forwarded to the upstream node cached locally (0 = disabled)",
+		Category: flags.VandalCategory,
+	}
+	VandalLiveFlag = &cli.BoolFlag{
+		Name:     "vandal.live",
+		Usage:    "Trace every canonical block imported by the node with the Vandal logger",
+		Category: flags.VandalCategory,
+	}
+	VandalLiveDirFlag = &flags.DirectoryFlag{
+		Name:     "vandal.live.dir",
+		Usage:    "Directory to write live Vandal traces into (default = inside the datadir)",
+		Category: flags.VandalCategory,
+	}
+	VandalPendingFlag = &cli.BoolFlag{
+		Name:     "vandal.pending",
+		Usage:    "Trace every transaction entering the transaction pool with the Vandal logger",
+		Category: flags.VandalCategory,
+	}
+	VandalPendingDirFlag = &flags.DirectoryFlag{
+		Name:     "vandal.pending.dir",
+		Usage:    "Directory to write pending Vandal traces into (default = inside the datadir)",
+		Category: flags.VandalCategory,
+	}
+	VandalMaxConcurrentFlag = &cli.IntFlag{
+		Name:     "vandal.maxconcurrent",
+		Usage:    "Maximum number of vandal RPC traces running at once per HTTP/WS client (0 = unlimited)",
+		Category: flags.VandalCategory,
+	}
+	VandalMaxStepsFlag = &cli.Uint64Flag{
+		Name:     "vandal.maxsteps",
+		Usage:    "Maximum number of opcodes traced per minute via vandal RPC per HTTP/WS client (0 = unlimited)",
+		Category: flags.VandalCategory,
+	}
+	VandalJobsFlag = &cli.BoolFlag{
+		Name:     "vandal.jobs",
+		Usage:    "Enable the vandal namespace methods running block range traces as background jobs",
+		Category: flags.VandalCategory,
+	}
+	VandalJobsDirFlag = &flags.DirectoryFlag{
+		Name:     "vandal.jobs.dir",
+		Usage:    "Directory to write Vandal trace job results into (default = inside the datadir)",
+		Category: flags.VandalCategory,
+	}
+	VandalJobsConcurrencyFlag = &cli.IntFlag{
+		Name:     "vandal.jobs.concurrency",
+		Usage:    "Maximum number of Vandal trace jobs running at the same time",
+		Value:    2,
+		Category: flags.VandalCategory,
+	}
+	VandalJobsWorkersFlag = &cli.IntFlag{
+		Name:     "vandal.jobs.workers",
+		Usage:    "Number of blocks of a Vandal trace job traced concurrently (capped at the number of CPUs)",
+		Value:    1,
+		Category: flags.VandalCategory,
+	}
+	VandalPipelineFlag = &cli.BoolFlag{
+		Name:     "vandal.pipeline",
+		Usage:    "Encode and write out Vandal trace job results in the background while tracing the next blocks",
+		Category: flags.VandalCategory,
+	}
+
 	// MISC settings
 	SyncTargetFlag = &cli.StringFlag{
 		Name:      "synctarget",
@@ -707,6 +822,11 @@ var (
 		Value:    "",
 		Category: flags.APICategory,
 	}
+	WSCompressionFlag = &cli.BoolFlag{
+		Name:     "ws.compression",
+		Usage:    "Enable per-message compression of WS-RPC responses for clients supporting it",
+		Category: flags.APICategory,
+	}
 	ExecFlag = &cli.StringFlag{
 		Name:     "exec",
 		Usage:    "Execute JavaScript statement",
@@ -1214,6 +1334,9 @@ func setWS(ctx *cli.Context, cfg *node.Config) {
 	if ctx.IsSet(WSPathPrefixFlag.Name) {
 		cfg.WSPathPrefix = ctx.String(WSPathPrefixFlag.Name)
 	}
+	if ctx.IsSet(WSCompressionFlag.Name) {
+		cfg.WSCompression = ctx.Bool(WSCompressionFlag.Name)
+	}
 }
 
 // setIPC creates an IPC path configuration from the set command line flags,
@@ -1891,6 +2014,60 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	}
 }
 
+// SetVandalConfig applies Vandal tracing related command line flags to the config.
+func SetVandalConfig(ctx *cli.Context, cfg *tracers.VandalConfig) {
+	if ctx.IsSet(VandalMaxConcurrentFlag.Name) {
+		cfg.MaxConcurrentTraces = ctx.Int(VandalMaxConcurrentFlag.Name)
+	}
+	if ctx.IsSet(VandalMaxStepsFlag.Name) {
+		cfg.MaxStepsPerMinute = ctx.Uint64(VandalMaxStepsFlag.Name)
+	}
+	if ctx.IsSet(VandalTimeoutFlag.Name) {
+		cfg.Timeout = ctx.Duration(VandalTimeoutFlag.Name)
+	}
+	if ctx.IsSet(VandalReexecFlag.Name) {
+		cfg.Reexec = ctx.Uint64(VandalReexecFlag.Name)
+	}
+	if ctx.IsSet(VandalStateFlag.Name) {
+		cfg.StateStrategy = ctx.String(VandalStateFlag.Name)
+	}
+	switch cfg.StateStrategy {
+	case "", tracers.VandalStateReexec, tracers.VandalStateAvailable:
+	default:
+		Fatalf("Invalid Vandal state strategy %q, want %q or %q", cfg.StateStrategy, tracers.VandalStateReexec, tracers.VandalStateAvailable)
+	}
+	if ctx.IsSet(VandalMaxReexecFlag.Name) {
+		cfg.MaxReexec = ctx.Uint64(VandalMaxReexecFlag.Name)
+	}
+	if ctx.IsSet(VandalMaxMemoryFlag.Name) {
+		cfg.MaxTraceMemory = ctx.Uint64(VandalMaxMemoryFlag.Name)
+	}
+	if ctx.IsSet(VandalMaxTraceStepsFlag.Name) {
+		cfg.MaxTraceSteps = ctx.Int(VandalMaxTraceStepsFlag.Name)
+	}
+	if ctx.IsSet(VandalReturnDataFlag.Name) {
+		cfg.ReturnData = ctx.Bool(VandalReturnDataFlag.Name)
+	}
+	if ctx.IsSet(VandalPageSizeFlag.Name) {
+		cfg.PageSize = ctx.Int(VandalPageSizeFlag.Name)
+	}
+	if ctx.IsSet(VandalAuthFlag.Name) {
+		cfg.Authenticated = ctx.Bool(VandalAuthFlag.Name)
+	}
+	if ctx.IsSet(VandalJobsWorkersFlag.Name) {
+		cfg.JobWorkers = ctx.Int(VandalJobsWorkersFlag.Name)
+	}
+	if ctx.IsSet(VandalPipelineFlag.Name) {
+		cfg.Pipeline = ctx.Bool(VandalPipelineFlag.Name)
+	}
+	if ctx.IsSet(VandalUpstreamFlag.Name) {
+		cfg.Upstream = ctx.String(VandalUpstreamFlag.Name)
+	}
+	if ctx.IsSet(VandalUpstreamCacheFlag.Name) {
+		cfg.UpstreamCache = ctx.Int(VandalUpstreamCacheFlag.Name)
+	}
+}
+
 // SetDNSDiscoveryDefaults configures DNS discovery with the given URL if
 // no URLs are set.
 func SetDNSDiscoveryDefaults(cfg *ethconfig.Config, genesis common.Hash) {
@@ -1915,6 +2092,51 @@ func RegisterEthService(stack *node.Node, cfg *ethconfig.Config) (ethapi.Backend
 	return backend.APIBackend, backend
 }
 
+// RegisterVandalAPI adds the Vandal tracing RPC methods to the node, forwarding
+// them to the configured upstream node if any.
+func RegisterVandalAPI(stack *node.Node, eth *eth.Ethereum, cfg *tracers.VandalConfig) {
+	if cfg.Upstream == "" {
+		stack.RegisterAPIs(tracers.VandalAPIs(eth.APIBackend, cfg))
+		return
+	}
+	client, err := rpc.Dial(cfg.Upstream)
+	if err != nil {
+		Fatalf("Failed to connect to the Vandal upstream node: %v", err)
+	}
+	stack.RegisterAPIs(tracers.VandalProxyAPIs(client, cfg))
+	log.Info("Forwarding Vandal traces to upstream node", "url", cfg.Upstream, "cache", cfg.UpstreamCache)
+}
+
+// RegisterVandalLiveService adds a service tracing every canonical block with
+// the Vandal logger to the node.
+func RegisterVandalLiveService(stack *node.Node, eth *eth.Ethereum, cfg *tracers.VandalConfig, dir string) {
+	live := tracers.NewVandalLive(eth.APIBackend, cfg, dir)
+	stack.RegisterLifecycle(live)
+	stack.RegisterAPIs(live.APIs())
+	log.Info("Registered live Vandal tracer", "dir", dir)
+
+	if len(cfg.Webhooks) > 0 {
+		stack.RegisterLifecycle(tracers.NewVandalWebhooks(eth.APIBackend, live, cfg.Webhooks))
+		log.Info("Registered Vandal webhooks", "hooks", len(cfg.Webhooks))
+	}
+}
+
+// RegisterVandalPendingService adds a service tracing every transaction entering
+// the transaction pool with the Vandal logger to the node.
+func RegisterVandalPendingService(stack *node.Node, eth *eth.Ethereum, cfg *tracers.VandalConfig, dir string) {
+	stack.RegisterLifecycle(tracers.NewVandalPending(eth.APIBackend, cfg, dir))
+	log.Info("Registered pending Vandal tracer", "dir", dir)
+}
+
+// RegisterVandalJobsService adds a scheduler running Vandal block range traces
+// as background jobs to the node.
+func RegisterVandalJobsService(stack *node.Node, eth *eth.Ethereum, cfg *tracers.VandalConfig, dir string, concurrency int) {
+	jobs := tracers.NewVandalJobs(eth.APIBackend, cfg, dir, concurrency)
+	stack.RegisterLifecycle(jobs)
+	stack.RegisterAPIs(jobs.APIs())
+	log.Info("Registered Vandal trace job scheduler", "dir", dir, "concurrency", concurrency)
+}
+
 // RegisterEthStatsService configures the Ethereum Stats daemon and adds it to the node.
 func RegisterEthStatsService(stack *node.Node, backend ethapi.Backend, url string) {
 	if err := ethstats.New(stack, backend, backend.Engine(), url); err != nil {
@@ -386,6 +386,12 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 			tracer.CaptureTxEnd(st.gasRemaining)
 		}()
 	}
+	if tracer := st.evm.Config.VandalLogger; tracer != nil {
+		tracer.CaptureTxStart(st.initialGas)
+		defer func() {
+			tracer.CaptureTxEnd(st.gasRemaining)
+		}()
+	}
 
 	var (
 		msg              = st.msg
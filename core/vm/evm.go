@@ -201,6 +201,15 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 					evm.Config.Tracer.CaptureExit(ret, 0, nil)
 				}
 			}
+			if vandal := evm.Config.VandalLogger; vandal != nil {
+				if evm.depth == 0 {
+					vandal.CaptureStart(evm, caller.Address(), addr, false, input, gas, value.ToBig())
+					vandal.CaptureEnd(ret, 0, nil)
+				} else {
+					vandal.CaptureEnter(CALL, caller.Address(), addr, input, gas, value.ToBig())
+					vandal.CaptureExit(ret, 0, nil)
+				}
+			}
 			return nil, gas, nil
 		}
 		evm.StateDB.CreateAccount(addr)
@@ -222,6 +231,19 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 			}(gas)
 		}
 	}
+	if vandal := evm.Config.VandalLogger; vandal != nil {
+		if evm.depth == 0 {
+			vandal.CaptureStart(evm, caller.Address(), addr, false, input, gas, value.ToBig())
+			defer func(startGas uint64) {
+				vandal.CaptureEnd(ret, startGas-gas, err)
+			}(gas)
+		} else {
+			vandal.CaptureEnter(CALL, caller.Address(), addr, input, gas, value.ToBig())
+			defer func(startGas uint64) {
+				vandal.CaptureExit(ret, startGas-gas, err)
+			}(gas)
+		}
+	}
 
 	if isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
@@ -284,6 +306,12 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 			evm.Config.Tracer.CaptureExit(ret, startGas-gas, err)
 		}(gas)
 	}
+	if vandal := evm.Config.VandalLogger; vandal != nil {
+		vandal.CaptureEnter(CALLCODE, caller.Address(), addr, input, gas, value.ToBig())
+		defer func(startGas uint64) {
+			vandal.CaptureExit(ret, startGas-gas, err)
+		}(gas)
+	}
 
 	// It is allowed to call precompiles, even via delegatecall
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
@@ -329,6 +357,13 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 			evm.Config.Tracer.CaptureExit(ret, startGas-gas, err)
 		}(gas)
 	}
+	if vandal := evm.Config.VandalLogger; vandal != nil {
+		parent := caller.(*Contract)
+		vandal.CaptureEnter(DELEGATECALL, caller.Address(), addr, input, gas, parent.value.ToBig())
+		defer func(startGas uint64) {
+			vandal.CaptureExit(ret, startGas-gas, err)
+		}(gas)
+	}
 
 	// It is allowed to call precompiles, even via delegatecall
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
@@ -379,6 +414,12 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 			evm.Config.Tracer.CaptureExit(ret, startGas-gas, err)
 		}(gas)
 	}
+	if vandal := evm.Config.VandalLogger; vandal != nil {
+		vandal.CaptureEnter(STATICCALL, caller.Address(), addr, input, gas, nil)
+		defer func(startGas uint64) {
+			vandal.CaptureExit(ret, startGas-gas, err)
+		}(gas)
+	}
 
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
@@ -463,6 +504,13 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 			evm.Config.Tracer.CaptureEnter(typ, caller.Address(), address, codeAndHash.code, gas, value.ToBig())
 		}
 	}
+	if vandal := evm.Config.VandalLogger; vandal != nil {
+		if evm.depth == 0 {
+			vandal.CaptureStart(evm, caller.Address(), address, true, codeAndHash.code, gas, value.ToBig())
+		} else {
+			vandal.CaptureEnter(typ, caller.Address(), address, codeAndHash.code, gas, value.ToBig())
+		}
+	}
 
 	ret, err := evm.interpreter.Run(contract, nil, false)
 
@@ -506,6 +554,13 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 			evm.Config.Tracer.CaptureExit(ret, gas-contract.Gas, err)
 		}
 	}
+	if vandal := evm.Config.VandalLogger; vandal != nil {
+		if evm.depth == 0 {
+			vandal.CaptureEnd(ret, gas-contract.Gas, err)
+		} else {
+			vandal.CaptureExit(ret, gas-contract.Gas, err)
+		}
+	}
 	return ret, address, contract.Gas, err
 }
 
@@ -823,6 +823,10 @@ func opSelfdestruct(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext
 		tracer.CaptureEnter(SELFDESTRUCT, scope.Contract.Address(), beneficiary.Bytes20(), []byte{}, 0, balance.ToBig())
 		tracer.CaptureExit([]byte{}, 0, nil)
 	}
+	if vandal := interpreter.evm.Config.VandalLogger; vandal != nil {
+		vandal.CaptureEnter(SELFDESTRUCT, scope.Contract.Address(), beneficiary.Bytes20(), []byte{}, 0, balance.ToBig())
+		vandal.CaptureExit([]byte{}, 0, nil)
+	}
 	return nil, beneficiary.Bytes(), errStopToken
 }
 
@@ -839,6 +843,10 @@ func opSelfdestruct6780(pc *uint64, interpreter *EVMInterpreter, scope *ScopeCon
 		tracer.CaptureEnter(SELFDESTRUCT, scope.Contract.Address(), beneficiary.Bytes20(), []byte{}, 0, balance.ToBig())
 		tracer.CaptureExit([]byte{}, 0, nil)
 	}
+	if vandal := interpreter.evm.Config.VandalLogger; vandal != nil {
+		vandal.CaptureEnter(SELFDESTRUCT, scope.Contract.Address(), beneficiary.Bytes20(), []byte{}, 0, balance.ToBig())
+		vandal.CaptureExit([]byte{}, 0, nil)
+	}
 	return nil, beneficiary.Bytes(), errStopToken
 }
 
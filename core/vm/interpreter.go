@@ -147,6 +147,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		res     []byte // result of the opcode execution function
 		out     []byte // output data of the last instruction
 		debug   = in.evm.Config.Tracer != nil
+		vandal  = in.evm.Config.VandalLogger != nil
 	)
 	// Don't move this deferred function, it's placed before the capturestate-deferred method,
 	// so that it gets executed _after_: the capturestate needs the stacks before
@@ -167,12 +168,19 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			}
 		}()
 	}
+	if vandal {
+		defer func() {
+			if err != nil {
+				in.evm.Config.VandalLogger.CaptureFault(pcCopy, op, gasCopy, cost, callContext, in.evm.depth, err)
+			}
+		}()
+	}
 	// The Interpreter main run loop (contextual). This loop runs until either an
 	// explicit STOP, RETURN or SELFDESTRUCT is executed, an error occurred during
 	// the execution of one of the operations or until the done flag is set by the
 	// parent context.
 	for {
-		if debug {
+		if debug || vandal {
 			// Capture pre-execution values for tracing.
 			logged, pcCopy, gasCopy = false, pc, contract.Gas
 		}
@@ -228,10 +236,16 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			in.evm.Config.Tracer.CaptureState(pc, op, gasCopy, cost, callContext, in.returnData, in.evm.depth, err)
 			logged = true
 		}
+		if vandal {
+			in.evm.Config.VandalLogger.CaptureOperands(op, stack.data[stack.len()-operation.minStack:])
+		}
 		// execute the operation
 		res, out, err = operation.execute(&pc, in, callContext)
 
-		if in.evm.Config.VandalLogger != nil {
+		if vandal {
+			if err == nil && operation.memorySize != nil {
+				in.evm.Config.VandalLogger.CaptureMemory(op, mem.Data())
+			}
 			in.evm.Config.VandalLogger.CaptureState(pcCopy, op, gasCopy, cost, out)
 		}
 
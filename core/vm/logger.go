@@ -20,6 +20,7 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
 )
 
 // EVMLogger is used to collect execution traces from an EVM transaction
@@ -43,7 +44,19 @@ type EVMLogger interface {
 }
 
 type VandalLogger interface {
+	// CaptureOperands is called right before an opcode is executed with the
+	// stack items it consumes, topmost last. The items are only valid during
+	// the call.
+	CaptureOperands(op OpCode, operands []uint256.Int)
+	// CaptureMemory is called once an opcode accessing memory is executed
+	// successfully, right before CaptureState, with the memory of its frame.
+	// The memory is only valid during the call.
+	CaptureMemory(op OpCode, memory []byte)
+	// CaptureState is called once an opcode is executed with its output.
 	CaptureState(pc uint64, op OpCode, gas, cost uint64, res []byte)
+	// CaptureFault is called when an opcode fails, either before its execution,
+	// in which case CaptureState is not called for it, or during it.
+	CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, depth int, err error)
 	CaptureTxStart(gasLimit uint64)
 	CaptureTxEnd(restGas uint64)
 
@@ -156,6 +156,18 @@ type TraceConfig struct {
 	// Config specific to given tracer. Note struct logger
 	// config are historically embedded in main object.
 	TracerConfig json.RawMessage
+
+	// vandalMemoryLimit is the memory limit of the Vandal logger, set by the
+	// node's policy rather than the request.
+	vandalMemoryLimit uint64
+
+	// vandalStepLimit is the step limit of the Vandal logger, set by the
+	// node's policy rather than the request.
+	vandalStepLimit int
+
+	// vandalReturnData makes the Vandal logger capture return data even if
+	// the request does not enable it, set by the node's defaults.
+	vandalReturnData bool
 }
 
 // TraceCallConfig is the config for traceCall API. It holds one more
@@ -226,7 +238,7 @@ func (api *API) TraceChain(ctx context.Context, start, end rpc.BlockNumber, conf
 	}
 	sub := notifier.CreateSubscription()
 
-	resCh := api.traceChain(from, to, config, sub.Err())
+	resCh := api.traceChain(from, to, config, sub.Err(), api.traceTx)
 	go func() {
 		for result := range resCh {
 			notifier.Notify(sub.ID, result)
@@ -240,7 +252,7 @@ func (api *API) TraceChain(ctx context.Context, start, end rpc.BlockNumber, conf
 // the end block but excludes the start one. The return value will be one item per
 // transaction, dependent on the requested tracer.
 // The tracing procedure should be aborted in case the closed signal is received.
-func (api *API) traceChain(start, end *types.Block, config *TraceConfig, closed <-chan error) chan *blockTraceResult {
+func (api *API) traceChain(start, end *types.Block, config *TraceConfig, closed <-chan error, trace txTraceFn) chan *blockTraceResult {
 	reexec := defaultTraceReexec
 	if config != nil && config.Reexec != nil {
 		reexec = *config.Reexec
@@ -277,7 +289,7 @@ func (api *API) traceChain(start, end *types.Block, config *TraceConfig, closed
 						TxIndex:     i,
 						TxHash:      tx.Hash(),
 					}
-					res, err := api.traceTx(ctx, msg, txctx, blockCtx, task.statedb, config)
+					res, err := trace(ctx, msg, txctx, blockCtx, task.statedb, config)
 					if err != nil {
 						task.results[i] = &txTraceResult{TxHash: tx.Hash(), Error: err.Error()}
 						log.Warn("Tracing failed", "hash", tx.Hash(), "block", task.block.NumberU64(), "err", err)
@@ -914,6 +926,39 @@ func (api *API) TraceVandalTransaction(ctx context.Context, hash common.Hash, co
 // the trace will be conducted on the state after executing the specified transaction
 // within the specified block.
 func (api *API) TraceCall(ctx context.Context, args ethapi.TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (interface{}, error) {
+	return api.traceCall(ctx, args, blockNrOrHash, config, api.traceTx)
+}
+
+// txTraceFn executes a message in the provided environment with a tracer
+// attached and returns the tracer specific result.
+type txTraceFn func(ctx context.Context, message *core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, config *TraceConfig) (interface{}, error)
+
+// traceCall prepares the state and message for tracing a given eth_call and
+// runs it through the given trace function.
+func (api *API) traceCall(ctx context.Context, args ethapi.TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig, trace txTraceFn) (interface{}, error) {
+	statedb, vmctx, release, err := api.callEnv(ctx, blockNrOrHash, config)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Execute the trace
+	msg, err := args.ToMessage(api.backend.RPCGasCap(), vmctx.BaseFee)
+	if err != nil {
+		return nil, err
+	}
+
+	var traceConfig *TraceConfig
+	if config != nil {
+		traceConfig = &config.TraceConfig
+	}
+	return trace(ctx, msg, new(Context), vmctx, statedb, traceConfig)
+}
+
+// callEnv retrieves the state and block context calls are executed in on top
+// of the given block, with the overrides of the config applied. The returned
+// function must be called to release the state once done.
+func (api *API) callEnv(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (*state.StateDB, vm.BlockContext, StateReleaseFunc, error) {
 	// Try to retrieve the specified block
 	var (
 		err     error
@@ -930,14 +975,14 @@ func (api *API) TraceCall(ctx context.Context, args ethapi.TransactionArgs, bloc
 			// more flexibility and stability than trying to trace on 'pending', since
 			// the contents of 'pending' is unstable and probably not a true representation
 			// of what the next actual block is likely to contain.
-			return nil, errors.New("tracing on top of pending is not supported")
+			return nil, vm.BlockContext{}, nil, errors.New("tracing on top of pending is not supported")
 		}
 		block, err = api.blockByNumber(ctx, number)
 	} else {
-		return nil, errors.New("invalid arguments; neither block nor hash specified")
+		return nil, vm.BlockContext{}, nil, errors.New("invalid arguments; neither block nor hash specified")
 	}
 	if err != nil {
-		return nil, err
+		return nil, vm.BlockContext{}, nil, err
 	}
 	// try to recompute the state
 	reexec := defaultTraceReexec
@@ -951,29 +996,26 @@ func (api *API) TraceCall(ctx context.Context, args ethapi.TransactionArgs, bloc
 		statedb, release, err = api.backend.StateAtBlock(ctx, block, reexec, nil, true, false)
 	}
 	if err != nil {
-		return nil, err
+		return nil, vm.BlockContext{}, nil, err
 	}
-	defer release()
 
 	vmctx := core.NewEVMBlockContext(block.Header(), api.chainContext(ctx), nil)
 	// Apply the customization rules if required.
 	if config != nil {
 		if err := config.StateOverrides.Apply(statedb); err != nil {
-			return nil, err
+			release()
+			return nil, vm.BlockContext{}, nil, err
 		}
 		config.BlockOverrides.Apply(&vmctx)
 	}
-	// Execute the trace
-	msg, err := args.ToMessage(api.backend.RPCGasCap(), vmctx.BaseFee)
-	if err != nil {
-		return nil, err
-	}
+	return statedb, vmctx, release, nil
+}
 
-	var traceConfig *TraceConfig
-	if config != nil {
-		traceConfig = &config.TraceConfig
-	}
-	return api.traceTx(ctx, msg, new(Context), vmctx, statedb, traceConfig)
+// vandalLoggerTracer is implemented by tracers producing their result with a
+// Vandal logger. The logger is attached to the EVM in place of the tracer, as
+// it observes the output of every opcode, which tracers cannot.
+type vandalLoggerTracer interface {
+	VandalLogger() vm.VandalLogger
 }
 
 // traceTx configures a new tracer according to the provided configuration, and
@@ -997,7 +1039,11 @@ func (api *API) traceTx(ctx context.Context, message *core.Message, txctx *Conte
 			return nil, err
 		}
 	}
-	vmenv := vm.NewEVM(vmctx, txContext, statedb, api.backend.ChainConfig(), vm.Config{Tracer: tracer, NoBaseFee: true})
+	vmconf := vm.Config{Tracer: tracer, NoBaseFee: true}
+	if vandal, ok := tracer.(vandalLoggerTracer); ok {
+		vmconf = vm.Config{VandalLogger: vandal.VandalLogger(), NoBaseFee: true}
+	}
+	vmenv := vm.NewEVM(vmctx, txContext, statedb, api.backend.ChainConfig(), vmconf)
 
 	// Define a meaningful timeout of a single transaction trace
 	if config.Timeout != nil {
@@ -1027,8 +1073,35 @@ func (api *API) traceTx(ctx context.Context, message *core.Message, txctx *Conte
 // traceVandalTx is a special tracer that is used to trace a tx to be outputted to Vandal. Similar to regular traceTx, but
 // returns Vandal.GetResult()
 func (api *API) traceVandalTx(ctx context.Context, message *core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, config *TraceConfig) (interface{}, error) {
+	_, result, err := api.applyVandalTx(ctx, message, txctx, vmctx, statedb, config, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// applyVandalTx executes the given message with the Vandal logger attached,
+// returning both the execution result and the basic blocks traced. A tracer
+// may be passed in to run alongside the logger, as with runVandalTx.
+func (api *API) applyVandalTx(ctx context.Context, message *core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, config *TraceConfig, tracer Tracer) (*core.ExecutionResult, json.RawMessage, error) {
+	res, vandalTracer, err := api.runVandalTx(ctx, message, txctx, vmctx, statedb, config, nil, tracer)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := vandalTracer.GetResult()
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, result, nil
+}
+
+// runVandalTx executes the given message with the Vandal logger attached,
+// returning the execution result and the logger holding the unencoded trace.
+// A reset logger may be passed in to reuse its buffers, nil creating one. A
+// tracer may be passed in to run alongside the logger, nil running the one
+// requested by the config if any.
+func (api *API) runVandalTx(ctx context.Context, message *core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, config *TraceConfig, vandalTracer *logger.VandalLogger, tracer Tracer) (*core.ExecutionResult, *logger.VandalLogger, error) {
 	var (
-		tracer    Tracer
 		err       error
 		timeout   = defaultTraceTimeout
 		txContext = core.NewEVMTxContext(message)
@@ -1036,42 +1109,66 @@ func (api *API) traceVandalTx(ctx context.Context, message *core.Message, txctx
 	if config == nil {
 		config = &TraceConfig{}
 	}
-	// Default tracer is the struct logger
-	tracer = logger.NewStructLogger(config.Config)
-	if config.Tracer != nil {
+	// No other tracer runs unless requested, as its memory would not be
+	// bounded by the limit of the Vandal logger
+	if tracer == nil && config.Tracer != nil {
 		tracer, err = DefaultDirectory.New(*config.Tracer, txctx, config.TracerConfig)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
+	if vandalTracer == nil {
+		cfg, err := config.vandalLoggerConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		vandalTracer = logger.NewVandalTracer(cfg)
+	}
 
-	vandalTracer := logger.NewVandalTracer()
-
-	vmenv := vm.NewEVM(vmctx, txContext, statedb, api.backend.ChainConfig(), vm.Config{Tracer: tracer, VandalLogger: vandalTracer, NoBaseFee: true})
+	vmConfig := vm.Config{VandalLogger: vandalTracer, NoBaseFee: true}
+	if tracer != nil {
+		vmConfig.Tracer = tracer
+	}
+	vmenv := vm.NewEVM(vmctx, txContext, statedb, api.backend.ChainConfig(), vmConfig)
 
 	// Define a meaningful timeout of a single transaction trace
 	if config.Timeout != nil {
 		if timeout, err = time.ParseDuration(*config.Timeout); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 	deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
+	watchdog := make(chan struct{})
 	go func() {
+		defer close(watchdog)
+
 		<-deadlineCtx.Done()
 		if errors.Is(deadlineCtx.Err(), context.DeadlineExceeded) {
-			tracer.Stop(errors.New("execution timeout"))
+			// Fail the trace, or mark it as truncated if partial results are
+			// requested, rather than returning it cut short as if complete
+			vandalTracer.Stop(errors.New("execution timeout"))
+			if tracer != nil {
+				tracer.Stop(errors.New("execution timeout"))
+			}
 			// Stop evm execution. Note cancellation is not necessarily immediate.
 			vmenv.Cancel()
 		}
 	}()
-	defer cancel()
+	defer func() {
+		cancel()
+		<-watchdog // The logger must not be stopped once reused
+	}()
 
 	// Call Prepare to clear out the statedb access list
 	statedb.SetTxContext(txctx.TxHash, txctx.TxIndex)
-	if _, err = core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.GasLimit)); err != nil {
-		return nil, fmt.Errorf("tracing failed: %w", err)
+	res, err := core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.GasLimit))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tracing failed: %w", err)
+	}
+	if err := chargeVandalSteps(ctx, vandalTracer.Steps()); err != nil {
+		return nil, nil, err
 	}
-	return vandalTracer.GetResult()
+	return res, vandalTracer, nil
 }
 
 // APIs return the collection of RPC services the tracer package offers.
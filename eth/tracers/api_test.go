@@ -967,7 +967,7 @@ func TestTraceChain(t *testing.T) {
 
 		from, _ := api.blockByNumber(context.Background(), rpc.BlockNumber(c.start))
 		to, _ := api.blockByNumber(context.Background(), rpc.BlockNumber(c.end))
-		resCh := api.traceChain(from, to, c.config, nil)
+		resCh := api.traceChain(from, to, c.config, nil, api.traceTx)
 
 		next := c.start + 1
 		for result := range resCh {
@@ -0,0 +1,757 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// vandalTxTraceResult is a single transaction trace returned by
+// vandal_traceTransactions or streamed by vandal_traceChain, tagged with the
+// block it was included in.
+type vandalTxTraceResult struct {
+	Block  hexutil.Uint64   `json:"block"`            // Block number containing the transaction
+	Hash   common.Hash      `json:"hash"`             // Block hash containing the transaction
+	TxHash common.Hash      `json:"txHash"`           // Transaction hash
+	Result interface{}      `json:"result,omitempty"` // Basic blocks produced by the Vandal logger
+	Error  string           `json:"error,omitempty"`  // Trace failure produced by the tracer
+	Meta   *VandalTraceMeta `json:"meta,omitempty"`   // How the trace was captured, if stored
+}
+
+// vandalBundleResult is the trace of a single transaction of a simulated
+// bundle, along with a summary of its execution.
+type vandalBundleResult struct {
+	From      common.Address  `json:"from"`                // Sender of the transaction
+	To        *common.Address `json:"to,omitempty"`        // Recipient, nil for contract creations
+	Value     *hexutil.Big    `json:"value"`               // Ether transferred to the recipient
+	GasUsed   hexutil.Uint64  `json:"gasUsed"`             // Gas used by the execution
+	Failed    bool            `json:"failed"`              // Whether the execution reverted or failed
+	Transfers json.RawMessage `json:"transfers,omitempty"` // Token and ether transfers, with their net flows
+	Result    interface{}     `json:"result,omitempty"`    // Basic blocks produced by the Vandal logger
+	Error     string          `json:"error,omitempty"`     // Execution or trace failure
+}
+
+// vandalTransfersTracer is the tracer recording the transfers of the
+// transactions of a simulated bundle, if registered.
+const vandalTransfersTracer = "tokenTransferTracer"
+
+// errBundlePending is returned when simulating a bundle on top of the pending
+// block.
+var errBundlePending = errors.New("bundles cannot be simulated on top of the pending block, use block overrides on top of the latest one")
+
+const (
+	// VandalStateReexec regenerates missing historical states by re-executing
+	// blocks from the nearest available state, as requested by config.Reexec.
+	VandalStateReexec = "reexec"
+
+	// VandalStateAvailable only traces blocks whose state is directly available,
+	// failing fast otherwise. This bounds the cost of requests on non-archive
+	// nodes, while archive nodes serve all of history.
+	VandalStateAvailable = "available"
+)
+
+// VandalConfig contains the node-wide settings of the Vandal tracing APIs.
+type VandalConfig struct {
+	// Limits applied to every remote (HTTP or WebSocket) client, zero meaning
+	// unlimited. Steps are the opcodes executed by the traced transactions.
+	MaxConcurrentTraces int    `toml:",omitempty"`
+	MaxStepsPerMinute   uint64 `toml:",omitempty"`
+
+	// Defaults for the trace settings a request leaves unset, zero meaning
+	// the defaults of the debug namespace.
+	Timeout time.Duration `toml:",omitempty"`
+	Reexec  uint64        `toml:",omitempty"`
+
+	// Policy for tracing blocks whose state is not directly available, either
+	// VandalStateReexec (default) or VandalStateAvailable. With the former,
+	// MaxReexec caps the number of blocks a request may re-execute to
+	// regenerate a state, zero meaning uncapped.
+	StateStrategy string `toml:",omitempty"`
+	MaxReexec     uint64 `toml:",omitempty"`
+
+	// Maximum memory in bytes the Vandal logger may use tracing a single
+	// transaction before the trace is aborted, protecting the node against
+	// transactions executing huge numbers of steps. Zero means unlimited.
+	MaxTraceMemory uint64 `toml:",omitempty"`
+
+	// Maximum number of steps of a single transaction trace, further steps
+	// being dropped and the trace marked as truncated. Requests may lower it
+	// with opLimit. Zero means unlimited.
+	MaxTraceSteps int `toml:",omitempty"`
+
+	// ReturnData captures the output of every traced step by default, which
+	// requests may otherwise enable with enableReturnData. It is off by
+	// default as it is rarely needed and grows the size of traces.
+	ReturnData bool `toml:",omitempty"`
+
+	// Maximum size in bytes of a single transaction trace response, larger
+	// traces being returned in pages. Zero means unlimited.
+	PageSize int `toml:",omitempty"`
+
+	// JobWorkers is the number of blocks of a block range job traced
+	// concurrently, capped at the number of CPUs. Every worker regenerates
+	// and holds its own state, so this also multiplies the state accesses
+	// and memory used by a job. Zero means one.
+	JobWorkers int `toml:",omitempty"`
+
+	// Pipeline overlaps encoding the traces of block range jobs with the
+	// execution of the following transactions, at the cost of holding more
+	// traces in memory.
+	Pipeline bool `toml:",omitempty"`
+
+	// Webhooks notified of the transactions traced by the live tracer.
+	Webhooks []VandalWebhook `toml:",omitempty"`
+
+	// Authenticated restricts the vandal namespace to the JWT authenticated
+	// RPC endpoint, as re-executing historical blocks is expensive.
+	Authenticated bool `toml:",omitempty"`
+
+	// Upstream is the RPC endpoint of a trusted node, typically an archive
+	// node, the vandal namespace forwards its requests to instead of tracing
+	// locally. UpstreamCache is the number of its immutable results cached,
+	// zero disabling caching.
+	Upstream      string `toml:",omitempty"`
+	UpstreamCache int    `toml:",omitempty"`
+}
+
+// StateProvider retrieves the states transactions are traced on.
+type StateProvider interface {
+	StateAtBlock(ctx context.Context, block *types.Block, reexec uint64, base *state.StateDB, readOnly bool, preferDisk bool) (*state.StateDB, StateReleaseFunc, error)
+	StateAtTransaction(ctx context.Context, block *types.Block, txIndex int, reexec uint64) (*core.Message, vm.BlockContext, *state.StateDB, StateReleaseFunc, error)
+}
+
+// WithStateProvider returns a tracing backend retrieving the states to trace on
+// from the given provider rather than from the given backend, e.g. to serve
+// historical traces from a separate state store.
+func WithStateProvider(backend Backend, provider StateProvider) Backend {
+	return &stateProviderBackend{Backend: backend, provider: provider}
+}
+
+// stateProviderBackend is a tracing backend with its state retrieval replaced.
+type stateProviderBackend struct {
+	Backend
+	provider StateProvider
+}
+
+func (b *stateProviderBackend) StateAtBlock(ctx context.Context, block *types.Block, reexec uint64, base *state.StateDB, readOnly bool, preferDisk bool) (*state.StateDB, StateReleaseFunc, error) {
+	return b.provider.StateAtBlock(ctx, block, reexec, base, readOnly, preferDisk)
+}
+
+func (b *stateProviderBackend) StateAtTransaction(ctx context.Context, block *types.Block, txIndex int, reexec uint64) (*core.Message, vm.BlockContext, *state.StateDB, StateReleaseFunc, error) {
+	return b.provider.StateAtTransaction(ctx, block, txIndex, reexec)
+}
+
+// VandalAPI is the collection of Vandal tracing APIs exposed over the vandal
+// namespace. The results are the basic blocks produced by the Vandal logger,
+// ready to be consumed by the Vandal decompiler. The trace settings passed
+// with a request take precedence over the node-wide defaults.
+type VandalAPI struct {
+	api      *API
+	defaults *VandalConfig  // Node-wide trace settings, nil if none
+	limiter  *vandalLimiter // Per-client limits, nil if unlimited
+	pager    *vandalPager   // Splitter of large traces, nil if unlimited
+}
+
+// NewVandalAPI creates a new API definition for the Vandal tracing methods of
+// the Ethereum service.
+func NewVandalAPI(backend Backend) *VandalAPI {
+	return &VandalAPI{api: NewAPI(backend)}
+}
+
+// VandalAPIs returns the Vandal tracing RPC services, configured with the given
+// node-wide settings.
+func VandalAPIs(backend Backend, config *VandalConfig) []rpc.API {
+	api := newVandalAPI(backend, config)
+	return []rpc.API{
+		{
+			Namespace:     "vandal",
+			Service:       api,
+			Authenticated: api.authenticated(),
+		},
+	}
+}
+
+// newVandalAPI creates the Vandal tracing methods, configured with the given
+// node-wide settings.
+func newVandalAPI(backend Backend, config *VandalConfig) *VandalAPI {
+	api := NewVandalAPI(backend)
+	api.defaults = config
+	if config != nil && (config.MaxConcurrentTraces > 0 || config.MaxStepsPerMinute > 0) {
+		api.limiter = newVandalLimiter(config.MaxConcurrentTraces, config.MaxStepsPerMinute, mclock.System{})
+	}
+	if config != nil && config.PageSize > 0 {
+		api.pager = newVandalPager(config.PageSize, mclock.System{})
+	}
+	return api
+}
+
+// authenticated reports whether the vandal namespace is to be served on the
+// authenticated RPC endpoint only.
+func (api *VandalAPI) authenticated() bool {
+	return api.defaults != nil && api.defaults.Authenticated
+}
+
+// TraceTransaction returns the basic blocks created during the execution of
+// the given transaction. Missing historical state is regenerated by
+// re-executing up to config.Reexec blocks, as with debug_traceTransaction.
+//
+// If pagination is enabled and the trace exceeds the page size, only its first
+// page is returned along with a cursor to retrieve the rest via TraceNextPage.
+func (api *VandalAPI) TraceTransaction(ctx context.Context, hash common.Hash, config *TraceConfig) (interface{}, error) {
+	ctx, release, err := api.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	res, err := api.api.TraceVandalTransaction(ctx, hash, api.traceConfig(config))
+	if err != nil || api.pager == nil {
+		return res, err
+	}
+	return api.pager.paginate(res)
+}
+
+// TraceNextPage returns the page of a paginated trace following the given
+// cursor. Every cursor can be followed once, within five minutes.
+func (api *VandalAPI) TraceNextPage(cursor rpc.ID) (*vandalPage, error) {
+	if api.pager == nil {
+		return nil, errors.New("trace pagination disabled")
+	}
+	return api.pager.next(cursor)
+}
+
+// TraceTransactions returns the basic blocks created during the execution of
+// each of the given transactions, in the order requested. Transactions included
+// in the same block share a single re-execution of that block, so tracing many
+// transactions of one block costs about as much as tracing the last of them.
+//
+// Failures are reported per transaction in the error field of the result.
+func (api *VandalAPI) TraceTransactions(ctx context.Context, hashes []common.Hash, config *TraceConfig) ([]*vandalTxTraceResult, error) {
+	ctx, release, err := api.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	config = api.traceConfig(config)
+	var (
+		results = make([]*vandalTxTraceResult, len(hashes))
+		batches []*vandalTxBatch
+		lookup  = make(map[common.Hash]*vandalTxBatch)
+	)
+	for i, hash := range hashes {
+		results[i] = &vandalTxTraceResult{TxHash: hash}
+
+		found, _, blockHash, blockNumber, index, err := api.api.backend.GetTransaction(ctx, hash)
+		if err != nil {
+			return nil, ethapi.NewTxIndexingError()
+		}
+		if !found {
+			results[i].Error = errTxNotFound.Error()
+			continue
+		}
+		results[i].Block, results[i].Hash = hexutil.Uint64(blockNumber), blockHash
+
+		batch := lookup[blockHash]
+		if batch == nil {
+			batch = &vandalTxBatch{hash: blockHash, number: blockNumber, indexes: make(map[int][]int)}
+			lookup[blockHash] = batch
+			batches = append(batches, batch)
+		}
+		batch.indexes[int(index)] = append(batch.indexes[int(index)], i)
+		if int(index) > batch.last {
+			batch.last = int(index)
+		}
+	}
+	for _, batch := range batches {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := api.traceBatch(ctx, batch, config, results); err != nil {
+			// Mark all the transactions not reached before the failure
+			for _, positions := range batch.indexes {
+				for _, i := range positions {
+					if results[i].Result == nil && results[i].Error == "" {
+						results[i].Error = err.Error()
+					}
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+// TraceCall returns the basic blocks created during the execution of the given
+// call on top of the state of the provided block, as with debug_traceCall. It
+// allows analysing unsigned transactions before they are submitted.
+// Large traces are paginated as with TraceTransaction.
+func (api *VandalAPI) TraceCall(ctx context.Context, args ethapi.TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (interface{}, error) {
+	ctx, release, err := api.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if config != nil || api.defaults != nil {
+		var override TraceCallConfig
+		if config != nil {
+			override = *config
+		}
+		override.TraceConfig = *api.traceConfig(&override.TraceConfig)
+		config = &override
+	}
+	res, err := api.api.traceCall(ctx, args, blockNrOrHash, config, api.api.traceVandalTx)
+	if err != nil || api.pager == nil {
+		return res, err
+	}
+	return api.pager.paginate(res)
+}
+
+// TraceBundle simulates the given transactions one after the other on top of
+// the given block and returns the basic blocks created during the execution of
+// each, along with a summary of the ether it transferred. Every transaction
+// sees the state changes of the ones before it. A transaction that can't be
+// executed, e.g. due to an insufficient balance, is reported in its error
+// field and leaves the state unmodified for the rest. As with eth_call, nonces
+// are not checked.
+//
+// The token and ether transfers of every transaction are recorded by the
+// tokenTransferTracer along with their net flows per address, if the native
+// tracers are registered.
+//
+// The state and block overrides of the config apply to the whole bundle.
+// Simulating on top of the pending block is rejected, as its contents change
+// while it is built: block overrides on top of the latest block simulate the
+// next one instead.
+func (api *VandalAPI) TraceBundle(ctx context.Context, bundle []ethapi.TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) ([]*vandalBundleResult, error) {
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		return nil, errBundlePending
+	}
+	ctx, release, err := api.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var traceConfig *TraceConfig
+	if config != nil || api.defaults != nil {
+		var override TraceCallConfig
+		if config != nil {
+			override = *config
+		}
+		override.TraceConfig = *api.traceConfig(&override.TraceConfig)
+		config, traceConfig = &override, &override.TraceConfig
+	}
+	statedb, vmctx, releaseState, err := api.api.callEnv(ctx, blockNrOrHash, config)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseState()
+
+	var (
+		results            = make([]*vandalBundleResult, len(bundle))
+		deleteEmptyObjects = api.api.backend.ChainConfig().IsEIP158(vmctx.BlockNumber)
+		_, transfers       = DefaultDirectory.elems[vandalTransfersTracer]
+	)
+	for i, args := range bundle {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msg, err := args.ToMessage(api.api.backend.RPCGasCap(), vmctx.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("bundle transaction %d: %w", i, err)
+		}
+		results[i] = &vandalBundleResult{
+			From:  msg.From,
+			To:    msg.To,
+			Value: (*hexutil.Big)(msg.Value),
+		}
+		var (
+			txctx  = &Context{TxIndex: i}
+			tracer Tracer
+		)
+		if transfers {
+			if tracer, err = DefaultDirectory.New(vandalTransfersTracer, txctx, json.RawMessage(`{"withSummary":true}`)); err != nil {
+				return nil, err
+			}
+		}
+		res, trace, err := api.api.applyVandalTx(ctx, msg, txctx, vmctx, statedb, traceConfig, tracer)
+		statedb.Finalise(deleteEmptyObjects)
+		if err != nil {
+			if errors.Is(err, errStepBudgetSpent) {
+				return nil, err
+			}
+			results[i].Failed, results[i].Error = true, err.Error()
+			continue
+		}
+		results[i].GasUsed = hexutil.Uint64(res.UsedGas)
+		results[i].Result = trace
+		// Transfers cut short, e.g. by a timeout, are not returned as if
+		// complete
+		if tracer != nil {
+			if results[i].Transfers, err = tracer.GetResult(); err != nil {
+				results[i].Transfers, results[i].Error = nil, err.Error()
+			}
+		}
+		if res.Err != nil {
+			results[i].Failed, results[i].Error = true, res.Err.Error()
+		}
+	}
+	return results, nil
+}
+
+// TraceBlockByNumber returns the basic blocks created during the execution of
+// every transaction in the given block, one result per transaction.
+func (api *VandalAPI) TraceBlockByNumber(ctx context.Context, number rpc.BlockNumber, config *TraceConfig) ([]*txTraceResult, error) {
+	ctx, release, err := api.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	config = api.traceConfig(config)
+	block, err := api.api.blockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return api.traceBlock(ctx, block, config)
+}
+
+// TraceBlockByHash returns the basic blocks created during the execution of
+// every transaction in the given block, one result per transaction.
+func (api *VandalAPI) TraceBlockByHash(ctx context.Context, hash common.Hash, config *TraceConfig) ([]*txTraceResult, error) {
+	ctx, release, err := api.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	config = api.traceConfig(config)
+	block, err := api.api.blockByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return api.traceBlock(ctx, block, config)
+}
+
+// TraceChain traces all the transactions between two blocks (excluding start)
+// and streams the Vandal results back one transaction at a time, in chain
+// order, as soon as the containing block has been traced.
+//
+// Flow control is inherited from debug_traceChain: tracing stalls while the
+// subscriber is not consuming notifications and the number of pending trace
+// states is bounded, so the node never buffers the full range.
+func (api *VandalAPI) TraceChain(ctx context.Context, start, end rpc.BlockNumber, config *TraceConfig) (*rpc.Subscription, error) {
+	from, err := api.api.blockByNumber(ctx, start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := api.api.blockByNumber(ctx, end)
+	if err != nil {
+		return nil, err
+	}
+	if from.Number().Cmp(to.Number()) >= 0 {
+		return nil, fmt.Errorf("end block (#%d) needs to come after start block (#%d)", end, start)
+	}
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	ctx, release, err := api.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub := notifier.CreateSubscription()
+
+	resCh := api.traceChain(ctx, from, to, api.traceConfig(config), sub.Err())
+	go func() {
+		defer release()
+
+		for result := range resCh {
+			notifier.Notify(sub.ID, result)
+		}
+	}()
+	return sub, nil
+}
+
+// traceConfig returns the settings of a trace request, with the node defaults
+// filling in those left unset by the request and the node's historical state
+// policy enforced.
+func (api *VandalAPI) traceConfig(config *TraceConfig) *TraceConfig {
+	if api.defaults == nil {
+		return config
+	}
+	var override TraceConfig
+	if config != nil {
+		override = *config
+	}
+	if override.Timeout == nil && api.defaults.Timeout != 0 {
+		timeout := api.defaults.Timeout.String()
+		override.Timeout = &timeout
+	}
+	if override.Reexec == nil && api.defaults.Reexec != 0 {
+		reexec := api.defaults.Reexec
+		override.Reexec = &reexec
+	}
+	override.vandalMemoryLimit = api.defaults.MaxTraceMemory
+	override.vandalStepLimit = api.defaults.MaxTraceSteps
+	override.vandalReturnData = api.defaults.ReturnData
+
+	// Restrict the historical state regeneration to the node's policy
+	if api.defaults.StateStrategy == VandalStateAvailable {
+		override.Reexec = new(uint64)
+	} else if limit := api.defaults.MaxReexec; limit != 0 {
+		reexec := defaultTraceReexec
+		if override.Reexec != nil {
+			reexec = *override.Reexec
+		}
+		if reexec > limit {
+			reexec = limit
+		}
+		override.Reexec = &reexec
+	}
+	return &override
+}
+
+// traceChain traces the given block range with the Vandal logger and flattens
+// the per-block results into a stream of per-transaction results. The context
+// only carries the request's rate limit meter, tracing outlives the request.
+func (api *VandalAPI) traceChain(ctx context.Context, start, end *types.Block, config *TraceConfig, closed <-chan error) <-chan *vandalTxTraceResult {
+	var (
+		blockCh = api.api.traceChain(start, end, config, closed, meteredVandalTx(ctx, api.api.traceVandalTx))
+		txCh    = make(chan *vandalTxTraceResult)
+	)
+	go func() {
+		defer close(txCh)
+
+		for res := range blockCh {
+			for _, trace := range res.Traces {
+				// Transactions following a failed one are not traced
+				if trace == nil {
+					continue
+				}
+				txCh <- &vandalTxTraceResult{
+					Block:  res.Block,
+					Hash:   res.Hash,
+					TxHash: trace.TxHash,
+					Result: trace.Result,
+					Error:  trace.Error,
+				}
+			}
+		}
+	}()
+	return txCh
+}
+
+// traceBlock re-executes all the transactions contained within the given block
+// on top of its parent state, tracing each of them with a fresh Vandal logger.
+// As with debug_traceBlock, transactions whose trace fails, e.g. by timing out,
+// are reported in the error field of their result.
+func (api *VandalAPI) traceBlock(ctx context.Context, block *types.Block, config *TraceConfig) ([]*txTraceResult, error) {
+	results := make([]*txTraceResult, 0, len(block.Transactions()))
+	err := api.traceBlockTxs(ctx, block, config, nil, func(tx *vandalTxTrace) error {
+		results = append(results, tx.result())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// vandalTxTrace is the trace of a transaction, which may still be in the
+// process of being encoded.
+type vandalTxTrace struct {
+	hash     common.Hash
+	encoding *logger.VandalEncoding // Nil if the transaction failed to trace
+	err      error                  // Tracing failure of the transaction
+}
+
+// result waits for the trace to be encoded and returns it as the result of the
+// transaction, any tracing or encoding failure being set as its error.
+func (tx *vandalTxTrace) result() *txTraceResult {
+	if tx.err == nil {
+		res, err := tx.encoding.Wait()
+		if err == nil {
+			return &txTraceResult{TxHash: tx.hash, Result: res}
+		}
+		tx.err = err
+	}
+	return &txTraceResult{TxHash: tx.hash, Error: tx.err.Error()}
+}
+
+// vandalRequestFailed reports whether the failure to trace a transaction must
+// abort the whole request rather than being reported for the transaction only,
+// i.e. when the request was cancelled or spent its step budget.
+func vandalRequestFailed(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, errStepBudgetSpent)
+}
+
+// traceBlockTxs is like traceBlock, but hands every transaction trace to emit
+// as soon as the transaction is executed rather than collecting them, so the
+// traces need not all be held in memory. The traces are encoded by the given
+// encoder, or synchronously before being emitted if nil. Transactions failing
+// to trace are emitted along with their error.
+func (api *VandalAPI) traceBlockTxs(ctx context.Context, block *types.Block, config *TraceConfig, enc *logger.VandalEncoder, emit func(*vandalTxTrace) error) error {
+	if block.NumberU64() == 0 {
+		return errors.New("genesis is not traceable")
+	}
+	parent, err := api.api.blockByNumberAndHash(ctx, rpc.BlockNumber(block.NumberU64()-1), block.ParentHash())
+	if err != nil {
+		return err
+	}
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	statedb, release, err := api.api.backend.StateAtBlock(ctx, parent, reexec, nil, true, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var (
+		txs       = block.Transactions()
+		blockHash = block.Hash()
+		is158     = api.api.backend.ChainConfig().IsEIP158(block.Number())
+		blockCtx  = core.NewEVMBlockContext(block.Header(), api.api.chainContext(ctx), nil)
+		signer    = types.MakeSigner(api.api.backend.ChainConfig(), block.Number(), block.Time())
+		reuse     *logger.VandalLogger // Encoded logger whose buffers to reuse
+	)
+	for i, tx := range txs {
+		msg, _ := core.TransactionToMessage(tx, signer, block.BaseFee())
+		txctx := &Context{
+			BlockHash:   blockHash,
+			BlockNumber: block.Number(),
+			TxIndex:     i,
+			TxHash:      tx.Hash(),
+		}
+		_, tracer, err := api.api.runVandalTx(ctx, msg, txctx, blockCtx, statedb, config, reuse, nil)
+		if err != nil {
+			if vandalRequestFailed(ctx, err) {
+				return err
+			}
+			// The logger may hold a partial trace, don't reuse it
+			if err := emit(&vandalTxTrace{hash: tx.Hash(), err: err}); err != nil {
+				return err
+			}
+			reuse = nil
+		} else {
+			if err := emit(&vandalTxTrace{hash: tx.Hash(), encoding: enc.Encode(tracer)}); err != nil {
+				return err
+			}
+			reuse = enc.Recycle(tracer)
+		}
+		// Finalize the state so any modifications are written to the trie
+		// Only delete empty objects if EIP158/161 (a.k.a Spurious Dragon) is in effect
+		statedb.Finalise(is158)
+	}
+	return nil
+}
+
+// vandalTxBatch is the set of transactions requested from a single block in
+// a vandal_traceTransactions call.
+type vandalTxBatch struct {
+	hash    common.Hash
+	number  uint64
+	indexes map[int][]int // Transaction index -> positions in the results
+	last    int           // Highest transaction index requested
+}
+
+// traceBatch re-executes the transactions of the batch's block up to the last
+// one requested, tracing those requested and storing their results into the
+// given positions. The others are applied untraced, only to advance the state.
+func (api *VandalAPI) traceBatch(ctx context.Context, batch *vandalTxBatch, config *TraceConfig, results []*vandalTxTraceResult) error {
+	if batch.number == 0 {
+		return errors.New("genesis is not traceable")
+	}
+	block, err := api.api.blockByNumberAndHash(ctx, rpc.BlockNumber(batch.number), batch.hash)
+	if err != nil {
+		return err
+	}
+	parent, err := api.api.blockByNumberAndHash(ctx, rpc.BlockNumber(batch.number-1), block.ParentHash())
+	if err != nil {
+		return err
+	}
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	statedb, release, err := api.api.backend.StateAtBlock(ctx, parent, reexec, nil, true, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var (
+		chainConfig = api.api.backend.ChainConfig()
+		is158       = chainConfig.IsEIP158(block.Number())
+		blockCtx    = core.NewEVMBlockContext(block.Header(), api.api.chainContext(ctx), nil)
+		signer      = types.MakeSigner(chainConfig, block.Number(), block.Time())
+	)
+	for i, tx := range block.Transactions()[:batch.last+1] {
+		msg, _ := core.TransactionToMessage(tx, signer, block.BaseFee())
+
+		positions, traced := batch.indexes[i]
+		if !traced {
+			vmenv := vm.NewEVM(blockCtx, core.NewEVMTxContext(msg), statedb, chainConfig, vm.Config{NoBaseFee: true})
+			statedb.SetTxContext(tx.Hash(), i)
+			if _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.GasLimit)); err != nil {
+				return fmt.Errorf("transaction %#x failed: %w", tx.Hash(), err)
+			}
+		} else {
+			txctx := &Context{
+				BlockHash:   batch.hash,
+				BlockNumber: block.Number(),
+				TxIndex:     i,
+				TxHash:      tx.Hash(),
+			}
+			// A failed trace is reported for its transaction only, the state
+			// still being advanced for the ones following it
+			res, err := api.api.traceVandalTx(ctx, msg, txctx, blockCtx, statedb, config)
+			if err != nil && vandalRequestFailed(ctx, err) {
+				return err
+			}
+			for _, pos := range positions {
+				if err != nil {
+					results[pos].Error = err.Error()
+				} else {
+					results[pos].Result = res
+				}
+			}
+		}
+		// Finalize the state so any modifications are written to the trie
+		// Only delete empty objects if EIP158/161 (a.k.a Spurious Dragon) is in effect
+		statedb.Finalise(is158)
+	}
+	return nil
+}
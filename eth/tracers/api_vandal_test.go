@@ -0,0 +1,676 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/exp/slices"
+)
+
+// vandalTestContract stores 1 into slot 0 and stops:
+//
+//	PUSH1 0x01 PUSH1 0x00 SSTORE STOP
+var vandalTestContract = common.FromHex("0x600160005500")
+
+// newVandalTestGenesis creates a genesis with a funded account and the
+// vandalTestContract deployed.
+func newVandalTestGenesis() (*core.Genesis, []Account, common.Address) {
+	var (
+		accounts = newAccounts(1)
+		contract = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+	)
+	genesis := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: types.GenesisAlloc{
+			accounts[0].addr: {Balance: big.NewInt(params.Ether)},
+			contract:         {Code: vandalTestContract, Balance: common.Big0},
+		},
+	}
+	return genesis, accounts, contract
+}
+
+// vandalTestGenerator returns a block generator adding a single call from the
+// sender into the contract to every block, collecting the transaction hashes.
+func vandalTestGenerator(sender Account, contract common.Address, hashes *[]common.Hash) func(i int, b *core.BlockGen) {
+	signer := types.HomesteadSigner{}
+	return func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTx(&types.LegacyTx{
+			Nonce:    b.TxNonce(sender.addr),
+			To:       &contract,
+			Value:    big.NewInt(0),
+			Gas:      100000,
+			GasPrice: b.BaseFee(),
+		}), signer, sender.key)
+		b.AddTx(tx)
+		*hashes = append(*hashes, tx.Hash())
+	}
+}
+
+// newVandalTestBackend creates a backend with n blocks, each containing a
+// single call from accounts[0] into vandalTestContract. The hashes of the
+// generated transactions are returned in block order.
+func newVandalTestBackend(t *testing.T, n int) (*testBackend, []Account, common.Address, []common.Hash) {
+	var (
+		genesis, accounts, contract = newVandalTestGenesis()
+		hashes                      []common.Hash
+	)
+	backend := newTestBackend(t, n, genesis, vandalTestGenerator(accounts[0], contract, &hashes))
+	return backend, accounts, contract, hashes
+}
+
+func TestVandalTraceTransaction(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, hashes := newVandalTestBackend(t, 1)
+	defer backend.teardown()
+	api := NewVandalAPI(backend)
+
+	result, err := api.TraceTransaction(context.Background(), hashes[0], nil)
+	if err != nil {
+		t.Fatalf("failed to trace transaction: %v", err)
+	}
+	var blocks []struct {
+		Entry uint64
+		Exit  uint64
+		Ops   []struct{ Pc uint64 }
+	}
+	if err := json.Unmarshal(result.(json.RawMessage), &blocks); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	var pcs []uint64
+	for _, block := range blocks {
+		for _, op := range block.Ops {
+			pcs = append(pcs, op.Pc)
+		}
+	}
+	if want := []uint64{0, 2, 4, 5}; !slices.Equal(pcs, want) {
+		t.Fatalf("traced pcs mismatch: have %v, want %v", pcs, want)
+	}
+	// Test non-existent transaction
+	_, err = api.TraceTransaction(context.Background(), common.Hash{42}, nil)
+	if !errors.Is(err, errTxNotFound) {
+		t.Fatalf("want %v, have %v", errTxNotFound, err)
+	}
+}
+
+func TestVandalTraceTransactions(t *testing.T) {
+	t.Parallel()
+
+	// Include three calls per block, so that requested transactions need
+	// the preceding ones of the block to be replayed untraced
+	var (
+		genesis, accounts, contract = newVandalTestGenesis()
+		hashes                      []common.Hash
+		generate                    = vandalTestGenerator(accounts[0], contract, &hashes)
+	)
+	backend := newTestBackend(t, 2, genesis, func(i int, b *core.BlockGen) {
+		for j := 0; j < 3; j++ {
+			generate(i, b)
+		}
+	})
+	defer backend.teardown()
+	api := NewVandalAPI(backend)
+
+	request := []common.Hash{hashes[5], hashes[1], {42}, hashes[1]}
+	results, err := api.TraceTransactions(context.Background(), request, nil)
+	if err != nil {
+		t.Fatalf("failed to trace transactions: %v", err)
+	}
+	if len(results) != len(request) {
+		t.Fatalf("result count mismatch: have %d, want %d", len(results), len(request))
+	}
+	for i, res := range results {
+		if res.TxHash != request[i] {
+			t.Fatalf("result %d: transaction mismatch: have %x, want %x", i, res.TxHash, request[i])
+		}
+		want, err := api.TraceTransaction(context.Background(), request[i], nil)
+		if errors.Is(err, errTxNotFound) {
+			if res.Error != errTxNotFound.Error() {
+				t.Fatalf("result %d: want error %v, have %q", i, errTxNotFound, res.Error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to trace transaction %x: %v", request[i], err)
+		}
+		have, _ := json.Marshal(res.Result)
+		if string(have) != string(want.(json.RawMessage)) {
+			t.Fatalf("result %d: trace mismatch: have %s, want %s", i, have, want)
+		}
+	}
+}
+
+func TestVandalTraceConfig(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, _ := newVandalTestBackend(t, 0)
+	defer backend.teardown()
+
+	// Without node defaults the request config is used as is
+	api := VandalAPIs(backend, nil)[0].Service.(*VandalAPI)
+	if config := api.traceConfig(nil); config != nil {
+		t.Fatalf("unexpected config without defaults: %+v", config)
+	}
+	// Node defaults fill in the settings left unset by the request
+	api = VandalAPIs(backend, &VandalConfig{Timeout: 5 * time.Second, Reexec: 7})[0].Service.(*VandalAPI)
+
+	config := api.traceConfig(nil)
+	if config.Timeout == nil || *config.Timeout != "5s" || config.Reexec == nil || *config.Reexec != 7 {
+		t.Fatalf("node defaults not applied: %+v", config)
+	}
+	reexec := uint64(1)
+	request := &TraceConfig{Reexec: &reexec}
+
+	config = api.traceConfig(request)
+	if config.Timeout == nil || *config.Timeout != "5s" || *config.Reexec != 1 {
+		t.Fatalf("request settings not preferred: %+v", config)
+	}
+	if request.Timeout != nil {
+		t.Fatal("request config modified")
+	}
+}
+
+func TestVandalStateStrategy(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, hashes := newVandalTestBackend(t, 2)
+	defer backend.teardown()
+
+	// Requested re-executions are capped
+	api := VandalAPIs(backend, &VandalConfig{MaxReexec: 16})[0].Service.(*VandalAPI)
+	if config := api.traceConfig(nil); config.Reexec == nil || *config.Reexec != 16 {
+		t.Fatalf("default reexec not capped: %+v", config)
+	}
+	reexec := uint64(8)
+	if config := api.traceConfig(&TraceConfig{Reexec: &reexec}); *config.Reexec != 8 {
+		t.Fatalf("reexec within cap modified: %d", *config.Reexec)
+	}
+	// Only available states are used with the available strategy
+	api = VandalAPIs(backend, &VandalConfig{StateStrategy: VandalStateAvailable, Reexec: 64})[0].Service.(*VandalAPI)
+	if config := api.traceConfig(&TraceConfig{Reexec: &reexec}); *config.Reexec != 0 {
+		t.Fatalf("reexec allowed with available strategy: %d", *config.Reexec)
+	}
+	// States can be retrieved from an injected provider
+	provider := &countingStateProvider{Backend: backend}
+	api = NewVandalAPI(WithStateProvider(backend, provider))
+	if _, err := api.TraceTransaction(context.Background(), hashes[1], nil); err != nil {
+		t.Fatalf("failed to trace transaction: %v", err)
+	}
+	if provider.calls == 0 {
+		t.Fatal("state provider not used")
+	}
+}
+
+// countingStateProvider counts the state retrievals served by a backend.
+type countingStateProvider struct {
+	Backend
+	calls int
+}
+
+func (p *countingStateProvider) StateAtTransaction(ctx context.Context, block *types.Block, txIndex int, reexec uint64) (*core.Message, vm.BlockContext, *state.StateDB, StateReleaseFunc, error) {
+	p.calls++
+	return p.Backend.StateAtTransaction(ctx, block, txIndex, reexec)
+}
+
+func TestVandalAuthenticated(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, _ := newVandalTestBackend(t, 0)
+	defer backend.teardown()
+
+	for _, config := range []*VandalConfig{nil, {}, {Authenticated: true}} {
+		want := config != nil && config.Authenticated
+
+		apis := append(VandalAPIs(backend, config), NewVandalJobs(backend, config, t.TempDir(), 1).APIs()...)
+		for _, api := range apis {
+			if api.Authenticated != want {
+				t.Errorf("%T authenticated mismatch: have %v, want %v", api.Service, api.Authenticated, want)
+			}
+		}
+	}
+}
+
+func TestVandalMemoryLimit(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, hashes := newVandalTestBackend(t, 1)
+	defer backend.teardown()
+
+	for _, tt := range []struct {
+		limit uint64
+		fail  bool
+	}{
+		{0, false},
+		{1 << 20, false},
+		{1, true},
+	} {
+		api := VandalAPIs(backend, &VandalConfig{MaxTraceMemory: tt.limit})[0].Service.(*VandalAPI)
+		_, err := api.TraceTransaction(context.Background(), hashes[0], nil)
+		if tt.fail != errors.Is(err, logger.ErrVandalMemoryLimit) {
+			t.Errorf("limit %d: unexpected result: %v", tt.limit, err)
+		}
+	}
+}
+
+// vandalLoopContract jumps back to its JUMPDEST until running out of gas,
+// without allocating for every step as pushes do:
+//
+//	PUSH1 0x02 JUMPDEST DUP1 JUMP
+var vandalLoopContract = common.FromHex("0x60025b8056")
+
+// Tests that tracing a transaction executing a huge number of steps over the
+// memory limit allocates about as much as the limit, no other tracer keeping
+// every step alive.
+func TestVandalMemoryBounded(t *testing.T) {
+	var (
+		genesis, accounts, _ = newVandalTestGenesis()
+		loop                 = common.HexToAddress("0x1000")
+		gas                  = hexutil.Uint64(3_000_000) // About 750k steps
+	)
+	genesis.Alloc[loop] = types.Account{Code: vandalLoopContract}
+	backend := newTestBackend(t, 0, genesis, nil)
+	defer backend.teardown()
+
+	api := VandalAPIs(backend, &VandalConfig{MaxTraceMemory: 1 << 16})[0].Service.(*VandalAPI)
+	args := ethapi.TransactionArgs{From: &accounts[0].addr, To: &loop, Gas: &gas}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	_, err := api.TraceCall(context.Background(), args, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), nil)
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, logger.ErrVandalMemoryLimit) {
+		t.Fatalf("expected memory limit error, have %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("tracing allocated %d bytes over a limit of %d", allocated, 1<<16)
+	}
+}
+
+// Tests that a trace timing out is failed rather than returned cut short.
+func TestVandalTimeout(t *testing.T) {
+	t.Parallel()
+
+	var (
+		genesis, accounts, _ = newVandalTestGenesis()
+		loop                 = common.HexToAddress("0x1000")
+		gas                  = hexutil.Uint64(25_000_000)
+		timeout              = "1ms"
+	)
+	genesis.Alloc[loop] = types.Account{Code: vandalLoopContract}
+	backend := newTestBackend(t, 0, genesis, nil)
+	defer backend.teardown()
+	api := NewVandalAPI(backend)
+
+	args := ethapi.TransactionArgs{From: &accounts[0].addr, To: &loop, Gas: &gas}
+	config := &TraceCallConfig{TraceConfig: TraceConfig{Timeout: &timeout}}
+	_, err := api.TraceCall(context.Background(), args, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), config)
+	if err == nil || err.Error() != "execution timeout" {
+		t.Fatalf("expected timeout error, have %v", err)
+	}
+}
+
+// Tests that the trace options of a request are applied, within the limits of
+// the node.
+func TestVandalTraceOptions(t *testing.T) {
+	t.Parallel()
+
+	var (
+		genesis, accounts, _ = newVandalTestGenesis()
+		loop                 = common.HexToAddress("0x1000")
+		gas                  = hexutil.Uint64(100_000)
+	)
+	genesis.Alloc[loop] = types.Account{Code: vandalLoopContract}
+	backend := newTestBackend(t, 0, genesis, nil)
+	defer backend.teardown()
+
+	for i, tt := range []struct {
+		node    *VandalConfig
+		options string
+		gas     hexutil.Uint64
+		timeout string
+		want    []string // Substrings of the result
+		err     string
+	}{
+		// Options of the request
+		{node: nil, options: `{"opLimit":10}`, want: []string{`"truncated":true,"steps":10}`}},
+		{node: nil, options: `{"opLimit":10,"includeOperands":true}`, want: []string{`"Operands":[`}},
+		{node: nil, options: `{"opLimit":10,"includeEdges":true}`, want: []string{`"edges":[`}},
+		{node: nil, options: `{"partialOnStop":true}`, gas: 25_000_000, timeout: "1ms", want: []string{`"truncated":true`}},
+		{node: nil, options: `{"opLimit":-1}`, err: "opLimit must not be negative"},
+		{node: nil, options: `{"format":"xml"}`, err: `unsupported format "xml"`},
+
+		// Limits of the node
+		{node: &VandalConfig{MaxTraceSteps: 5}, options: ``, want: []string{`"truncated":true,"steps":5}`}},
+		{node: &VandalConfig{MaxTraceSteps: 5}, options: `{"opLimit":10}`, want: []string{`"truncated":true,"steps":5}`}},
+		{node: &VandalConfig{MaxTraceSteps: 50}, options: `{"opLimit":10}`, want: []string{`"truncated":true,"steps":10}`}},
+		{node: &VandalConfig{MaxTraceMemory: 1}, options: `{"memoryLimit":1073741824}`, err: logger.ErrVandalMemoryLimit.Error()},
+	} {
+		api := VandalAPIs(backend, tt.node)[0].Service.(*VandalAPI)
+		args := ethapi.TransactionArgs{From: &accounts[0].addr, To: &loop, Gas: &gas}
+		if tt.gas != 0 {
+			args.Gas = &tt.gas
+		}
+		config := &TraceCallConfig{TraceConfig: TraceConfig{TracerConfig: json.RawMessage(tt.options)}}
+		if tt.timeout != "" {
+			config.Timeout = &tt.timeout
+		}
+		result, err := api.TraceCall(context.Background(), args, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), config)
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to trace call: %v", i, err)
+			continue
+		}
+		for _, want := range tt.want {
+			if !bytes.Contains(result.(json.RawMessage), []byte(want)) {
+				t.Errorf("test %d: result lacks %s", i, want)
+			}
+		}
+	}
+}
+
+func TestVandalReturnData(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, hashes := newVandalTestBackend(t, 1)
+	defer backend.teardown()
+
+	for _, tt := range []struct {
+		node    bool
+		request *logger.Config
+		want    bool
+	}{
+		{false, nil, false},
+		{false, &logger.Config{EnableReturnData: true}, true},
+		{true, nil, true},
+	} {
+		api := VandalAPIs(backend, &VandalConfig{ReturnData: tt.node})[0].Service.(*VandalAPI)
+		result, err := api.TraceTransaction(context.Background(), hashes[0], &TraceConfig{Config: tt.request})
+		if err != nil {
+			t.Fatalf("failed to trace transaction: %v", err)
+		}
+		if have := bytes.Contains(result.(json.RawMessage), []byte(`"Ret":"`)); have != tt.want {
+			t.Errorf("node %v, request %v: return data captured: %v", tt.node, tt.request, have)
+		}
+	}
+}
+
+func TestVandalTraceBlock(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, hashes := newVandalTestBackend(t, 2)
+	defer backend.teardown()
+	api := NewVandalAPI(backend)
+
+	byNumber, err := api.TraceBlockByNumber(context.Background(), rpc.BlockNumber(2), nil)
+	if err != nil {
+		t.Fatalf("failed to trace block by number: %v", err)
+	}
+	if len(byNumber) != 1 || byNumber[0].TxHash != hashes[1] {
+		t.Fatalf("unexpected block trace result: %v", byNumber)
+	}
+	block, _ := backend.BlockByNumber(context.Background(), rpc.BlockNumber(2))
+	byHash, err := api.TraceBlockByHash(context.Background(), block.Hash(), nil)
+	if err != nil {
+		t.Fatalf("failed to trace block by hash: %v", err)
+	}
+	have, _ := json.Marshal(byHash)
+	want, _ := json.Marshal(byNumber)
+	if string(have) != string(want) {
+		t.Fatalf("trace by hash differs from trace by number: have %s, want %s", have, want)
+	}
+	// Tracing the genesis block is not supported
+	if _, err := api.TraceBlockByNumber(context.Background(), rpc.BlockNumber(0), nil); err == nil {
+		t.Fatal("expected error tracing genesis")
+	}
+}
+
+// newVandalLoopBackend creates a backend with a single block of three calls
+// from accounts[0]: into vandalTestContract, into vandalLoopContract running
+// out of gas after about 250k steps, and into vandalTestContract again. The
+// hashes of the transactions are returned in order.
+func newVandalLoopBackend(t *testing.T) (*testBackend, []common.Hash) {
+	var (
+		genesis, accounts, contract = newVandalTestGenesis()
+		loop                        = common.HexToAddress("0x1000")
+		signer                      = types.HomesteadSigner{}
+		hashes                      []common.Hash
+	)
+	genesis.Alloc[loop] = types.Account{Code: vandalLoopContract}
+	backend := newTestBackend(t, 1, genesis, func(i int, b *core.BlockGen) {
+		for _, to := range []common.Address{contract, loop, contract} {
+			tx, _ := types.SignTx(types.NewTx(&types.LegacyTx{
+				Nonce:    b.TxNonce(accounts[0].addr),
+				To:       &to,
+				Value:    big.NewInt(0),
+				Gas:      1_000_000,
+				GasPrice: b.BaseFee(),
+			}), signer, accounts[0].key)
+			b.AddTx(tx)
+			hashes = append(hashes, tx.Hash())
+		}
+	})
+	return backend, hashes
+}
+
+// Tests that a transaction failing to trace is reported in its result, the
+// other transactions of the block still being traced.
+func TestVandalTraceBlockFailure(t *testing.T) {
+	t.Parallel()
+
+	backend, hashes := newVandalLoopBackend(t)
+	defer backend.teardown()
+	api := VandalAPIs(backend, &VandalConfig{MaxTraceMemory: 1 << 16})[0].Service.(*VandalAPI)
+
+	results, err := api.TraceBlockByNumber(context.Background(), rpc.BlockNumber(1), nil)
+	if err != nil {
+		t.Fatalf("failed to trace block: %v", err)
+	}
+	if len(results) != len(hashes) {
+		t.Fatalf("result count mismatch: have %d, want %d", len(results), len(hashes))
+	}
+	for i, res := range results {
+		if res.TxHash != hashes[i] {
+			t.Fatalf("result %d: transaction mismatch: have %x, want %x", i, res.TxHash, hashes[i])
+		}
+		if failed := i == 1; failed != (res.Error != "") || failed == (res.Result != nil) {
+			t.Fatalf("result %d: unexpected outcome: result %v, error %q", i, res.Result != nil, res.Error)
+		}
+	}
+	if !strings.HasPrefix(results[1].Error, logger.ErrVandalMemoryLimit.Error()) {
+		t.Fatalf("unexpected trace failure: %v", results[1].Error)
+	}
+}
+
+// Tests that a requested transaction failing to trace is reported in its
+// result, the transactions following it in the block still being traced.
+func TestVandalTraceTransactionsFailure(t *testing.T) {
+	t.Parallel()
+
+	backend, hashes := newVandalLoopBackend(t)
+	defer backend.teardown()
+	api := VandalAPIs(backend, &VandalConfig{MaxTraceMemory: 1 << 16})[0].Service.(*VandalAPI)
+
+	results, err := api.TraceTransactions(context.Background(), []common.Hash{hashes[1], hashes[2]}, nil)
+	if err != nil {
+		t.Fatalf("failed to trace transactions: %v", err)
+	}
+	if !strings.HasPrefix(results[0].Error, logger.ErrVandalMemoryLimit.Error()) || results[0].Result != nil {
+		t.Fatalf("unexpected result of failing transaction: %+v", results[0])
+	}
+	want, err := api.TraceTransaction(context.Background(), hashes[2], nil)
+	if err != nil {
+		t.Fatalf("failed to trace transaction: %v", err)
+	}
+	have, _ := json.Marshal(results[1].Result)
+	if results[1].Error != "" || string(have) != string(want.(json.RawMessage)) {
+		t.Fatalf("trace mismatch: have %s (error %q), want %s", have, results[1].Error, want)
+	}
+}
+
+func TestVandalTraceCall(t *testing.T) {
+	t.Parallel()
+
+	backend, accounts, contract, _ := newVandalTestBackend(t, 1)
+	defer backend.teardown()
+	api := NewVandalAPI(backend)
+
+	result, err := api.TraceCall(context.Background(), ethapi.TransactionArgs{
+		From: &accounts[0].addr,
+		To:   &contract,
+	}, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), nil)
+	if err != nil {
+		t.Fatalf("failed to trace call: %v", err)
+	}
+	var blocks []struct {
+		Ops []struct{ Pc uint64 }
+	}
+	if err := json.Unmarshal(result.(json.RawMessage), &blocks); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if len(blocks) == 0 || len(blocks[0].Ops) == 0 {
+		t.Fatalf("expected traced basic blocks, have %s", result)
+	}
+	// Pending state is not available to the tracer
+	_, err = api.TraceCall(context.Background(), ethapi.TransactionArgs{
+		From: &accounts[0].addr,
+		To:   &contract,
+	}, rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber), nil)
+	if err == nil {
+		t.Fatal("expected error tracing on top of pending")
+	}
+}
+
+// The native tracers can't be imported by this package, so the transfers of
+// bundles are recorded by a stand-in reporting the value of the transaction.
+func init() {
+	DefaultDirectory.Register(vandalTransfersTracer, func(*Context, json.RawMessage) (Tracer, error) {
+		return &transfersStub{StructLogger: logger.NewStructLogger(nil)}, nil
+	}, false)
+}
+
+type transfersStub struct {
+	*logger.StructLogger
+	value *big.Int
+}
+
+func (t *transfersStub) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.StructLogger.CaptureStart(env, from, to, create, input, gas, value)
+	t.value = value
+}
+
+func (t *transfersStub) GetResult() (json.RawMessage, error) {
+	return json.Marshal(map[string]*hexutil.Big{"value": (*hexutil.Big)(t.value)})
+}
+
+func TestVandalTraceBundle(t *testing.T) {
+	t.Parallel()
+
+	backend, accounts, contract, _ := newVandalTestBackend(t, 1)
+	defer backend.teardown()
+	api := NewVandalAPI(backend)
+
+	var (
+		funded = common.HexToAddress("0x1111")
+		other  = common.HexToAddress("0x2222")
+		value  = (*hexutil.Big)(big.NewInt(params.GWei))
+	)
+	results, err := api.TraceBundle(context.Background(), []ethapi.TransactionArgs{
+		{From: &accounts[0].addr, To: &contract},
+		{From: &accounts[0].addr, To: &funded, Value: value},
+		{From: &funded, To: &other, Value: value}, // Only funded by the previous one
+		{From: &other, To: &funded, Value: (*hexutil.Big)(big.NewInt(params.Ether))},
+	}, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), nil)
+	if err != nil {
+		t.Fatalf("failed to trace bundle: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("unexpected number of results: have %d, want 4", len(results))
+	}
+	for i, res := range results[:3] {
+		if res.Failed || res.Error != "" || res.Result == nil || res.GasUsed == 0 {
+			t.Fatalf("unexpected result %d: %+v", i, res)
+		}
+	}
+	if *results[2].To != other || results[2].Value.ToInt().Cmp(value.ToInt()) != 0 {
+		t.Fatalf("unexpected transfer summary: %+v", results[2])
+	}
+	if want := `{"value":"0x3b9aca00"}`; string(results[2].Transfers) != want {
+		t.Fatalf("transfers mismatch: have %s, want %s", results[2].Transfers, want)
+	}
+	if !results[3].Failed || results[3].Error == "" || results[3].Result != nil {
+		t.Fatalf("expected underfunded transfer to fail: %+v", results[3])
+	}
+	// Bundles are not simulated on top of the pending block
+	bundle := []ethapi.TransactionArgs{{From: &accounts[0].addr, To: &contract}}
+	if _, err := api.TraceBundle(context.Background(), bundle, rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber), nil); !errors.Is(err, errBundlePending) {
+		t.Fatalf("want %v, have %v", errBundlePending, err)
+	}
+}
+
+func TestVandalTraceChain(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, hashes := newVandalTestBackend(t, 10)
+	defer backend.teardown()
+	api := NewVandalAPI(backend)
+
+	from, _ := api.api.blockByNumber(context.Background(), rpc.BlockNumber(2))
+	to, _ := api.api.blockByNumber(context.Background(), rpc.BlockNumber(8))
+
+	next := uint64(3)
+	for result := range api.traceChain(context.Background(), from, to, nil, nil) {
+		if have, want := uint64(result.Block), next; have != want {
+			t.Fatalf("unexpected tracing block, have %d want %d", have, want)
+		}
+		if have, want := result.TxHash, hashes[next-1]; have != want {
+			t.Fatalf("unexpected transaction, have %x want %x", have, want)
+		}
+		if result.Error != "" || result.Result == nil {
+			t.Fatalf("missing trace result for %x: %s", result.TxHash, result.Error)
+		}
+		next++
+	}
+	if next != 9 {
+		t.Fatalf("missing traced transactions, stopped at block %d", next)
+	}
+}
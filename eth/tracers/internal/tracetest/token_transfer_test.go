@@ -0,0 +1,233 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracetest
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// tokenEventCode returns the code emitting an event with the given data words
+// and topics.
+func tokenEventCode(data []common.Hash, topics ...common.Hash) []byte {
+	var code []byte
+	for i := range data {
+		code = append(append(code, byte(vm.PUSH32)), data[i][:]...)
+		code = append(code, byte(vm.PUSH1), byte(32*i), byte(vm.MSTORE))
+	}
+	for i := len(topics) - 1; i >= 0; i-- {
+		code = append(append(code, byte(vm.PUSH32)), topics[i][:]...)
+	}
+	size := 32 * len(data)
+	return append(code, byte(vm.PUSH2), byte(size>>8), byte(size), byte(vm.PUSH1), 0, byte(vm.LOG0)+byte(len(topics)))
+}
+
+// tokenCallCode returns the code calling the given address with some value
+// through the given opcode, CALL or CALLCODE, without input or output.
+func tokenCallCode(op vm.OpCode, to byte, value byte) []byte {
+	return []byte{
+		byte(vm.PUSH1), 0, byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1),
+		byte(vm.PUSH1), value, byte(vm.PUSH1), to, byte(vm.GAS), byte(op), byte(vm.POP),
+	}
+}
+
+// tokenDelegateCode returns the code delegating to the given address, without
+// input or output.
+func tokenDelegateCode(to byte) []byte {
+	return []byte{
+		byte(vm.PUSH1), 0, byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1),
+		byte(vm.PUSH1), to, byte(vm.GAS), byte(vm.DELEGATECALL), byte(vm.POP),
+	}
+}
+
+// Tests that the token transfer tracer decodes the transfers and approvals of
+// token events and wrapped native tokens, and the ether transferred by calls,
+// dropping those reverted, and aggregates their net flows if requested.
+func TestTokenTransferTracer(t *testing.T) {
+	var (
+		to        = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		origin    = common.HexToAddress("0x00000000000000000000000000000000feed")
+		txContext = vm.TxContext{
+			Origin:   origin,
+			GasPrice: big.NewInt(1),
+		}
+		context = vm.BlockContext{
+			CanTransfer: core.CanTransfer,
+			Transfer:    core.Transfer,
+			Coinbase:    common.Address{},
+			BlockNumber: new(big.Int).SetUint64(8000000),
+			Time:        5,
+			Difficulty:  big.NewInt(0x30000),
+			GasLimit:    uint64(6000000),
+		}
+		transfer       = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+		approvalForAll = crypto.Keccak256Hash([]byte("ApprovalForAll(address,address,bool)"))
+		transferSingle = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+		transferBatch  = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+		deposit        = crypto.Keccak256Hash([]byte("Deposit(address,uint256)"))
+		withdrawal     = crypto.Keccak256Hash([]byte("Withdrawal(address,uint256)"))
+		word           = func(v byte) common.Hash { return common.BytesToHash([]byte{v}) }
+		words          = func(vs ...byte) []common.Hash {
+			hashes := make([]common.Hash, len(vs))
+			for i, v := range vs {
+				hashes[i] = word(v)
+			}
+			return hashes
+		}
+	)
+	// The reverted call emits a transfer too, which is dropped along with it
+	reverter := append(tokenEventCode(words(9), transfer, word(3), word(4)), byte(vm.PUSH1), 0, byte(vm.DUP1), byte(vm.REVERT))
+
+	var code []byte
+	code = append(code, tokenEventCode(words(5), transfer, word(1), word(2))...)
+	code = append(code, tokenEventCode(nil, transfer, word(1), word(2), word(7))...)
+	code = append(code, tokenCallCode(vm.CALL, 0xbb, 1)...)
+	code = append(code, tokenCallCode(vm.CALL, 0xcc, 2)...)
+	code = append(code, tokenEventCode(words(1), approvalForAll, word(1), word(4))...)
+	code = append(code, tokenEventCode(words(8, 3), transferSingle, word(4), word(1), word(2))...)
+	// Batches of ids 10 and 11, transferring 1 and 2 of them
+	code = append(code, tokenEventCode(words(0x40, 0xa0, 2, 10, 11, 2, 1, 2), transferBatch, word(4), word(1), word(2))...)
+
+	// The implementation behind a proxy emits on behalf of it
+	implementation := tokenEventCode(words(6), transfer, word(1), word(2))
+
+	// Wrapping and unwrapping of the native token
+	var wrap []byte
+	wrap = append(wrap, tokenEventCode(words(4), deposit, word(1))...)
+	wrap = append(wrap, tokenEventCode(words(3), withdrawal, word(1))...)
+	wrap = append(wrap, byte(vm.STOP))
+
+	for _, tc := range []struct {
+		name   string
+		code   []byte
+		value  int64 // Value of the transaction
+		config json.RawMessage
+		want   string
+	}{
+		{
+			name: "Transfers",
+			code: append(code, byte(vm.STOP)),
+			want: `{"transfers":[{"standard":"erc20","token":"0x00000000000000000000000000000000deadbeef","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","value":"0x5"},{"standard":"erc721","token":"0x00000000000000000000000000000000deadbeef","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"0x7"},{"standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","operator":"0x0000000000000000000000000000000000000004","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"0x8","value":"0x3"},{"standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","operator":"0x0000000000000000000000000000000000000004","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"0xa","value":"0x1"},{"standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","operator":"0x0000000000000000000000000000000000000004","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"0xb","value":"0x2"}],"approvals":[{"token":"0x00000000000000000000000000000000deadbeef","owner":"0x0000000000000000000000000000000000000001","spender":"0x0000000000000000000000000000000000000004","approved":true}],"ethTransfers":[{"type":"NATIVE","op":"CALL","from":"0x00000000000000000000000000000000deadbeef","to":"0x00000000000000000000000000000000000000cc","value":"0x2","depth":1,"traceAddress":[1]}]}`,
+		},
+		{
+			name:   "Summary",
+			code:   append(code, byte(vm.STOP)),
+			config: json.RawMessage(`{"withSummary": true}`),
+			want:   `{"transfers":[{"standard":"erc20","token":"0x00000000000000000000000000000000deadbeef","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","value":"0x5"},{"standard":"erc721","token":"0x00000000000000000000000000000000deadbeef","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"0x7"},{"standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","operator":"0x0000000000000000000000000000000000000004","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"0x8","value":"0x3"},{"standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","operator":"0x0000000000000000000000000000000000000004","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"0xa","value":"0x1"},{"standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","operator":"0x0000000000000000000000000000000000000004","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"0xb","value":"0x2"}],"approvals":[{"token":"0x00000000000000000000000000000000deadbeef","owner":"0x0000000000000000000000000000000000000001","spender":"0x0000000000000000000000000000000000000004","approved":true}],"ethTransfers":[{"type":"NATIVE","op":"CALL","from":"0x00000000000000000000000000000000deadbeef","to":"0x00000000000000000000000000000000000000cc","value":"0x2","depth":1,"traceAddress":[1]}],"summary":{"flows":[{"address":"0x0000000000000000000000000000000000000001","standard":"erc20","token":"0x00000000000000000000000000000000deadbeef","net":"-0x5"},{"address":"0x0000000000000000000000000000000000000002","standard":"erc20","token":"0x00000000000000000000000000000000deadbeef","net":"0x5"},{"address":"0x0000000000000000000000000000000000000001","standard":"erc721","token":"0x00000000000000000000000000000000deadbeef","net":"-0x1"},{"address":"0x0000000000000000000000000000000000000002","standard":"erc721","token":"0x00000000000000000000000000000000deadbeef","net":"0x1"},{"address":"0x0000000000000000000000000000000000000001","standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","tokenId":"0x8","net":"-0x3"},{"address":"0x0000000000000000000000000000000000000002","standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","tokenId":"0x8","net":"0x3"},{"address":"0x0000000000000000000000000000000000000001","standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","tokenId":"0xa","net":"-0x1"},{"address":"0x0000000000000000000000000000000000000002","standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","tokenId":"0xa","net":"0x1"},{"address":"0x0000000000000000000000000000000000000001","standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","tokenId":"0xb","net":"-0x2"},{"address":"0x0000000000000000000000000000000000000002","standard":"erc1155","token":"0x00000000000000000000000000000000deadbeef","tokenId":"0xb","net":"0x2"},{"address":"0x00000000000000000000000000000000deadbeef","net":"-0x2"},{"address":"0x00000000000000000000000000000000000000cc","net":"0x2"}],"hops":6,"tokens":["0x00000000000000000000000000000000deadbeef"]}}`,
+		},
+		{
+			name: "Reverted",
+			code: append(code, byte(vm.PUSH1), 0, byte(vm.DUP1), byte(vm.REVERT)),
+			want: `{"transfers":[],"approvals":[],"ethTransfers":[]}`,
+		},
+		{
+			name:   "Wrapped",
+			code:   wrap,
+			config: json.RawMessage(`{"wrappedNative": ["0x00000000000000000000000000000000deadbeef"]}`),
+			want:   `{"transfers":[{"standard":"erc20","event":"deposit","token":"0x00000000000000000000000000000000deadbeef","from":"0x00000000000000000000000000000000deadbeef","to":"0x0000000000000000000000000000000000000001","value":"0x4"},{"standard":"erc20","event":"withdrawal","token":"0x00000000000000000000000000000000deadbeef","from":"0x0000000000000000000000000000000000000001","to":"0x00000000000000000000000000000000deadbeef","value":"0x3"}],"approvals":[],"ethTransfers":[]}`,
+		},
+		{
+			name:   "NotWrapped",
+			code:   wrap,
+			config: json.RawMessage(`{"wrappedNative": ["0x00000000000000000000000000000000000000ee"]}`),
+			want:   `{"transfers":[],"approvals":[],"ethTransfers":[]}`,
+		},
+		{
+			// Other contracts than the chain's WETH emit the same events
+			name: "NotWETH",
+			code: wrap,
+			want: `{"transfers":[],"approvals":[],"ethTransfers":[]}`,
+		},
+		{
+			name: "Delegated",
+			code: append(tokenDelegateCode(0xee), byte(vm.STOP)),
+			want: `{"transfers":[{"standard":"erc20","token":"0x00000000000000000000000000000000deadbeef","emitter":"0x00000000000000000000000000000000000000ee","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","value":"0x6"}],"approvals":[],"ethTransfers":[]}`,
+		},
+		{
+			// The value of the transaction flows from the sender
+			name:   "Value",
+			code:   append(tokenCallCode(vm.CALL, 0xcc, 2), byte(vm.STOP)),
+			value:  7,
+			config: json.RawMessage(`{"withSummary": true}`),
+			want:   `{"transfers":[],"approvals":[],"ethTransfers":[{"type":"NATIVE","op":"CALL","from":"0x000000000000000000000000000000000000feed","to":"0x00000000000000000000000000000000deadbeef","value":"0x7","depth":0,"traceAddress":[]},{"type":"NATIVE","op":"CALL","from":"0x00000000000000000000000000000000deadbeef","to":"0x00000000000000000000000000000000000000cc","value":"0x2","depth":1,"traceAddress":[0]}],"summary":{"flows":[{"address":"0x000000000000000000000000000000000000feed","net":"-0x7"},{"address":"0x00000000000000000000000000000000deadbeef","net":"0x5"},{"address":"0x00000000000000000000000000000000000000cc","net":"0x2"}],"hops":2,"tokens":[]}}`,
+		},
+		{
+			// The value of a CALLCODE stays with the caller
+			name:   "CallCode",
+			code:   append(tokenCallCode(vm.CALLCODE, 0xcc, 2), byte(vm.STOP)),
+			config: json.RawMessage(`{"withSummary": true}`),
+			want:   `{"transfers":[],"approvals":[],"ethTransfers":[],"summary":{"flows":[],"hops":0,"tokens":[]}}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			state := tests.MakePreState(rawdb.NewMemoryDatabase(),
+				types.GenesisAlloc{
+					to: types.Account{
+						Code:    tc.code,
+						Balance: big.NewInt(10),
+					},
+					common.HexToAddress("0xbb"): types.Account{
+						Code: reverter,
+					},
+					common.HexToAddress("0xee"): types.Account{
+						Code: implementation,
+					},
+					origin: types.Account{
+						Balance: big.NewInt(500000000000000),
+					},
+				}, false, rawdb.HashScheme)
+			defer state.Close()
+
+			tracer, err := tracers.DefaultDirectory.New("tokenTransferTracer", nil, tc.config)
+			if err != nil {
+				t.Fatalf("failed to create token transfer tracer: %v", err)
+			}
+			evm := vm.NewEVM(context, txContext, state.StateDB, params.MainnetChainConfig, vm.Config{Tracer: tracer})
+			msg := &core.Message{
+				To:        &to,
+				From:      origin,
+				Value:     big.NewInt(tc.value),
+				GasLimit:  200000,
+				GasPrice:  big.NewInt(0),
+				GasFeeCap: big.NewInt(0),
+				GasTipCap: big.NewInt(0),
+			}
+			st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(msg.GasLimit))
+			if _, err := st.TransitionDb(); err != nil {
+				t.Fatalf("failed to execute transaction: %v", err)
+			}
+			res, err := tracer.GetResult()
+			if err != nil {
+				t.Fatalf("failed to retrieve trace result: %v", err)
+			}
+			if string(res) != tc.want {
+				t.Errorf("trace mismatch\n have: %v\n want: %v\n", string(res), tc.want)
+			}
+		})
+	}
+}
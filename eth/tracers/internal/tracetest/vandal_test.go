@@ -0,0 +1,416 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/tests"
+	"golang.org/x/exp/slices"
+)
+
+// vandalBlock is the boundary of a basic block split by the Vandal logger: the
+// range of steps it spans, the address of its code, and the program counters
+// and call depths of the opcodes it holds.
+type vandalBlock struct {
+	Entry   uint64         `json:"entry"`
+	Exit    uint64         `json:"exit"`
+	Address common.Address `json:"address"`
+	Pcs     []uint64       `json:"pcs"`
+	Depths  []int          `json:"depths"`
+}
+
+// vandalTest defines a single transaction to check the Vandal logger's block
+// boundaries against. The transactions are those of the call tracer suite.
+// The results are snapshots recorded from the Vandal logger itself, not from
+// the Vandal decompiler: they catch changes of the splitting heuristics, not
+// divergences from the decompiler's.
+type vandalTest struct {
+	Genesis *core.Genesis  `json:"genesis"`
+	Context *callContext   `json:"context"`
+	Input   string         `json:"input"`
+	Result  []*vandalBlock `json:"result"`
+}
+
+// Iterates over the real transactions of the Vandal test suite and checks the
+// basic blocks split by the Vandal logger against the committed snapshots,
+// catching changes of the splitting heuristics.
+func TestVandalLoggerSnapshot(t *testing.T) {
+	files, err := os.ReadDir(filepath.Join("testdata", "vandal_snapshot"))
+	if err != nil {
+		t.Fatalf("failed to retrieve vandal snapshot suite: %v", err)
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		file := file // capture range variable
+		t.Run(camel(strings.TrimSuffix(file.Name(), ".json")), func(t *testing.T) {
+			t.Parallel()
+
+			test := new(vandalTest)
+			if blob, err := os.ReadFile(filepath.Join("testdata", "vandal_snapshot", file.Name())); err != nil {
+				t.Fatalf("failed to read testcase: %v", err)
+			} else if err := json.Unmarshal(blob, test); err != nil {
+				t.Fatalf("failed to parse testcase: %v", err)
+			}
+			have, err := runVandalTest(test)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(have) != len(test.Result) {
+				t.Fatalf("block count mismatch: have %d, want %d", len(have), len(test.Result))
+			}
+			for i, want := range test.Result {
+				if have[i].Entry != want.Entry || have[i].Exit != want.Exit || have[i].Address != want.Address || !slices.Equal(have[i].Pcs, want.Pcs) || !slices.Equal(have[i].Depths, want.Depths) {
+					t.Fatalf("block %d mismatch\n have: %+v\n want: %+v", i, *have[i], *want)
+				}
+			}
+		})
+	}
+}
+
+// Tests that the Vandal tracer registered with the tracer directory produces
+// the same output as the Vandal logger attached to the EVM directly.
+func TestVandalNativeTracer(t *testing.T) {
+	files, err := os.ReadDir(filepath.Join("testdata", "vandal_snapshot"))
+	if err != nil {
+		t.Fatalf("failed to retrieve vandal test suite: %v", err)
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		file := file // capture range variable
+		t.Run(camel(strings.TrimSuffix(file.Name(), ".json")), func(t *testing.T) {
+			t.Parallel()
+
+			test := new(vandalTest)
+			if blob, err := os.ReadFile(filepath.Join("testdata", "vandal_snapshot", file.Name())); err != nil {
+				t.Fatalf("failed to read testcase: %v", err)
+			} else if err := json.Unmarshal(blob, test); err != nil {
+				t.Fatalf("failed to parse testcase: %v", err)
+			}
+			for _, config := range []json.RawMessage{nil, json.RawMessage(`{"includeOperands": true}`), json.RawMessage(`{"includeStorage": true}`), json.RawMessage(`{"includeContracts": true}`), json.RawMessage(`{"format": "protobuf", "includeStorage": true}`), json.RawMessage(`{"includeMemory": true, "includeMemoryData": true}`)} {
+				want, err := execVandalTest(test, false, config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				have, err := execVandalTest(test, true, config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(have, want) {
+					t.Fatalf("config %s: trace mismatch\n have: %s\n want: %s", config, have, want)
+				}
+			}
+		})
+	}
+}
+
+// Tests that the configuration of the Vandal tracer is applied, and that a
+// trace over the step limit is returned truncated rather than lost.
+func TestVandalNativeTracerConfig(t *testing.T) {
+	t.Parallel()
+
+	test := new(vandalTest)
+	if blob, err := os.ReadFile(filepath.Join("testdata", "vandal_snapshot", "deep_calls.json")); err != nil {
+		t.Fatalf("failed to read testcase: %v", err)
+	} else if err := json.Unmarshal(blob, test); err != nil {
+		t.Fatalf("failed to parse testcase: %v", err)
+	}
+	res, err := execVandalTest(test, true, json.RawMessage(`{"opLimit": 10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var truncated struct {
+		Blocks    []json.RawMessage `json:"blocks"`
+		Truncated bool              `json:"truncated"`
+		Steps     int               `json:"steps"`
+	}
+	if err := json.Unmarshal(res, &truncated); err != nil {
+		t.Fatalf("failed to decode truncated trace: %v", err)
+	}
+	if len(truncated.Blocks) == 0 || !truncated.Truncated || truncated.Steps != 10 {
+		t.Fatalf("unexpected truncated trace: %s", res)
+	}
+	// Tracing only the code of an uncalled contract yields no steps
+	res, err = execVandalTest(test, true, json.RawMessage(`{"onlyAddresses": ["0x0000000000000000000000000000000000000001"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "[]" {
+		t.Fatalf("unexpected filtered trace: %s", res)
+	}
+	if _, err := execVandalTest(test, true, json.RawMessage(`{"opLimit": -1}`)); err == nil {
+		t.Fatal("expected error on negative step limit")
+	}
+	// Traces timing out return the steps traced so far if partial results are enabled
+	tracer, err := tracers.DefaultDirectory.New("vandalTracer", new(tracers.Context), json.RawMessage(`{"partialOnStop": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Stop(errors.New("execution timeout"))
+	if res, err := tracer.GetResult(); err != nil || string(res) != `{"blocks":[],"truncated":true,"steps":0}` {
+		t.Fatalf("unexpected partial trace: %s, %v", res, err)
+	}
+	// Facts hold every traced step
+	res, err = execVandalTest(test, true, json.RawMessage(`{"format": "facts"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var facts map[string]string
+	if err := json.Unmarshal(res, &facts); err != nil {
+		t.Fatalf("failed to decode facts: %v", err)
+	}
+	if ops := strings.Count(facts["op.facts"], "\n"); ops != int(test.Result[len(test.Result)-1].Exit)+1 {
+		t.Fatalf("op facts count mismatch: have %d, want %d", ops, test.Result[len(test.Result)-1].Exit+1)
+	}
+	if _, err := execVandalTest(test, true, json.RawMessage(`{"format": "csv"}`)); err == nil {
+		t.Fatal("expected error on unsupported format")
+	}
+	// Every jump taken lands on a JUMPDEST of the same contract
+	res, err = execVandalTest(test, true, json.RawMessage(`{"includeEdges": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		Blocks []struct {
+			Ops []struct {
+				Pc      uint64
+				Op      vm.OpCode
+				Address common.Address
+			}
+		}
+		Edges []struct {
+			Step     int
+			Address  common.Address
+			From, To uint64
+		}
+	}
+	if err := json.Unmarshal(res, &trace); err != nil {
+		t.Fatalf("failed to decode edges: %v", err)
+	}
+	var ops []struct {
+		Pc      uint64
+		Op      vm.OpCode
+		Address common.Address
+	}
+	for _, block := range trace.Blocks {
+		ops = append(ops, block.Ops...)
+	}
+	if len(trace.Edges) == 0 {
+		t.Fatal("no edges traced")
+	}
+	for _, edge := range trace.Edges {
+		jump, dest := ops[edge.Step], ops[edge.Step+1]
+		if jump.Pc != edge.From || (jump.Op != vm.JUMP && jump.Op != vm.JUMPI) || dest.Pc != edge.To || dest.Op != vm.JUMPDEST || dest.Address != edge.Address {
+			t.Fatalf("edge %+v mismatches trace: jump %+v, destination %+v", edge, jump, dest)
+		}
+	}
+}
+
+// Tests that the reason of a reverted call is attached to the last block of
+// its frame, as is the reason of the transaction reverting along with it.
+func TestVandalNativeTracerFaults(t *testing.T) {
+	t.Parallel()
+
+	test := new(vandalTest)
+	if blob, err := os.ReadFile(filepath.Join("testdata", "vandal_snapshot", "inner_revert_reason.json")); err != nil {
+		t.Fatalf("failed to read testcase: %v", err)
+	} else if err := json.Unmarshal(blob, test); err != nil {
+		t.Fatalf("failed to parse testcase: %v", err)
+	}
+	res, err := execVandalTest(test, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []struct {
+		Failed bool
+		Fault  *struct {
+			Error  string
+			Reason string
+			Op     vm.OpCode
+		}
+	}
+	if err := json.Unmarshal(res, &blocks); err != nil {
+		t.Fatalf("failed to decode trace: %v", err)
+	}
+	var faults int
+	for i, block := range blocks {
+		if block.Fault == nil {
+			continue
+		}
+		faults++
+		if !block.Failed || block.Fault.Error != vm.ErrExecutionReverted.Error() || block.Fault.Reason != "This called failed" || block.Fault.Op != vm.REVERT {
+			t.Errorf("block %d: unexpected fault %+v", i, *block.Fault)
+		}
+	}
+	if faults != 2 {
+		t.Fatalf("fault count mismatch: have %d, want %d", faults, 2)
+	}
+}
+
+// Tests that the code collected by the Vandal tracer lines up with the traced
+// steps: every step executes the opcode at its pc in the code of its address.
+func TestVandalNativeTracerContracts(t *testing.T) {
+	files, err := os.ReadDir(filepath.Join("testdata", "vandal_snapshot"))
+	if err != nil {
+		t.Fatalf("failed to retrieve vandal test suite: %v", err)
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		file := file // capture range variable
+		t.Run(camel(strings.TrimSuffix(file.Name(), ".json")), func(t *testing.T) {
+			t.Parallel()
+
+			test := new(vandalTest)
+			if blob, err := os.ReadFile(filepath.Join("testdata", "vandal_snapshot", file.Name())); err != nil {
+				t.Fatalf("failed to read testcase: %v", err)
+			} else if err := json.Unmarshal(blob, test); err != nil {
+				t.Fatalf("failed to parse testcase: %v", err)
+			}
+			res, err := execVandalTest(test, true, json.RawMessage(`{"includeContracts": true}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var trace struct {
+				Blocks []struct {
+					Ops []struct {
+						Pc      uint64
+						Op      vm.OpCode
+						Address common.Address
+					}
+				}
+				Contracts []struct {
+					Address common.Address
+					Code    hexutil.Bytes
+				}
+			}
+			if err := json.Unmarshal(res, &trace); err != nil {
+				t.Fatalf("failed to decode trace: %v", err)
+			}
+			for _, block := range trace.Blocks {
+				for _, op := range block.Ops {
+					// Init and runtime code may both run at the same address
+					var found bool
+					for _, contract := range trace.Contracts {
+						if contract.Address == op.Address && op.Pc < uint64(len(contract.Code)) && vm.OpCode(contract.Code[op.Pc]) == op.Op {
+							found = true
+							break
+						}
+					}
+					if !found {
+						t.Fatalf("op %+v not found in the contracts", op)
+					}
+				}
+			}
+		})
+	}
+}
+
+// runVandalTest executes the transaction of the test on its prestate with the
+// Vandal logger attached, returning the boundaries of the basic blocks.
+func runVandalTest(test *vandalTest) ([]*vandalBlock, error) {
+	res, err := execVandalTest(test, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []struct {
+		Entry   uint64
+		Exit    uint64
+		Address common.Address
+		Ops     []struct {
+			Pc    uint64
+			Depth int
+		}
+	}
+	if err := json.Unmarshal(res, &blocks); err != nil {
+		return nil, err
+	}
+	boundaries := make([]*vandalBlock, len(blocks))
+	for i, block := range blocks {
+		boundaries[i] = &vandalBlock{Entry: block.Entry, Exit: block.Exit, Address: block.Address, Pcs: []uint64{}, Depths: []int{}}
+		for _, op := range block.Ops {
+			boundaries[i].Pcs = append(boundaries[i].Pcs, op.Pc)
+			boundaries[i].Depths = append(boundaries[i].Depths, op.Depth)
+		}
+	}
+	return boundaries, nil
+}
+
+// execVandalTest executes the transaction of the test on its prestate, traced
+// by the registered Vandal tracer with the given configuration, returning the
+// trace. The tracer is either attached as such, or its logger is attached to
+// the EVM directly as done by the debug API.
+func execVandalTest(test *vandalTest, native bool, config json.RawMessage) (json.RawMessage, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(common.FromHex(test.Input)); err != nil {
+		return nil, err
+	}
+	var (
+		signer  = types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)), uint64(test.Context.Time))
+		context = vm.BlockContext{
+			CanTransfer: core.CanTransfer,
+			Transfer:    core.Transfer,
+			Coinbase:    test.Context.Miner,
+			BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+			Time:        uint64(test.Context.Time),
+			Difficulty:  (*big.Int)(test.Context.Difficulty),
+			GasLimit:    uint64(test.Context.GasLimit),
+			BaseFee:     test.Genesis.BaseFee,
+		}
+		state = tests.MakePreState(rawdb.NewMemoryDatabase(), test.Genesis.Alloc, false, rawdb.HashScheme)
+	)
+	defer state.Close()
+
+	msg, err := core.TransactionToMessage(tx, signer, context.BaseFee)
+	if err != nil {
+		return nil, err
+	}
+	tracer, err := tracers.DefaultDirectory.New("vandalTracer", new(tracers.Context), config)
+	if err != nil {
+		return nil, err
+	}
+	var vmconf vm.Config
+	if native {
+		vmconf.Tracer = tracer
+	} else {
+		vmconf.VandalLogger = tracer.(interface{ VandalLogger() vm.VandalLogger }).VandalLogger()
+	}
+	evm := vm.NewEVM(context, core.NewEVMTxContext(msg), state.StateDB, test.Genesis.Config, vmconf)
+	if _, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(tx.Gas())); err != nil {
+		return nil, err
+	}
+	return tracer.GetResult()
+}
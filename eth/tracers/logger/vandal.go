@@ -1,178 +1,804 @@
 package logger
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"math/big"
+	"strconv"
 	"sync/atomic"
+	"unsafe"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
 )
 
+const (
+	// vandalGasPerStep is the assumed average gas cost of an executed opcode,
+	// used to estimate the number of steps of a transaction from its gas limit.
+	vandalGasPerStep = 8
+
+	// vandalMaxPresize caps the number of steps preallocated for a transaction,
+	// as gas limits are often far above the gas actually used.
+	vandalMaxPresize = 1 << 18
+
+	// vandalStepSize is the memory used by a traced step across the columns of
+	// the trace, excluding its return data.
+	vandalStepSize = uint64(3*unsafe.Sizeof(uint64(0)) + unsafe.Sizeof(vm.OpCode(0)) + 2*unsafe.Sizeof(int32(0)))
+
+	// vandalRetSize is the memory used by the return data of a traced step,
+	// excluding the data itself.
+	vandalRetSize = uint64(unsafe.Sizeof([]byte(nil)))
+
+	// vandalOperandsSize is the memory used by the operands of a traced step,
+	// excluding the operands themselves.
+	vandalOperandsSize = uint64(unsafe.Sizeof(uint32(0)))
+
+	// vandalStorageSize is the memory used by a traced storage access.
+	vandalStorageSize = uint64(unsafe.Sizeof(vandalStorageAccess{}))
+
+	// vandalMemoryWriteSize is the memory used by a traced memory write,
+	// excluding the bytes written.
+	vandalMemoryWriteSize = uint64(unsafe.Sizeof(vandalMemoryWrite{}))
+
+	// vandalMaxRetained is the maximum memory in bytes of the buffers a reset
+	// logger retains for the next transaction. Larger ones, left over by an
+	// exceptionally large trace, are released instead.
+	vandalMaxRetained = 64 * 1024 * 1024
+)
+
+// ErrVandalMemoryLimit is returned by a Vandal logger whose trace outgrew its
+// configured memory limit.
+var ErrVandalMemoryLimit = errors.New("vandal trace memory limit exceeded")
+
+// errVandalStreamWrite is returned by a streaming Vandal logger whose stream
+// could not be written.
+var errVandalStreamWrite = errors.New("vandal stream write failed")
+
+// VandalConfig are the configuration options for the Vandal logger.
+type VandalConfig struct {
+	// MemoryLimit is the maximum memory in bytes the traced steps may use
+	// before tracing is stopped with ErrVandalMemoryLimit, 0 = unlimited.
+	MemoryLimit uint64
+
+	// EnableReturnData captures the output of every step, encoded as its Ret.
+	// It is rarely needed, and retaining it keeps the output of every step
+	// alive, up to a word for most opcodes and the full return data of calls,
+	// growing the memory and encoded size of traces. When disabled, Ret is
+	// null.
+	EnableReturnData bool
+
+	// StepLimit is the maximum number of steps traced, 0 = unlimited. Steps
+	// beyond it are dropped and the result is marked as truncated, rather than
+	// the whole trace being lost.
+	StepLimit int
+
+	// PartialOnStop returns the steps traced so far when the trace is stopped,
+	// e.g. on an RPC timeout or over the memory limit, instead of the error it
+	// was stopped with. The result is then marked as truncated like a trace
+	// over the step limit. A trace whose stream failed is lost regardless.
+	PartialOnStop bool
+
+	// OnlyAddresses restricts the trace to the steps executing the code of the
+	// given contracts, e.g. a library called via DELEGATECALL. All steps are
+	// traced if empty.
+	OnlyAddresses []common.Address
+
+	// DisableValueCapture leaves the Value fields null. Otherwise the ether
+	// transferred by calls, creations and self-destructs is recorded as the
+	// Value of their opcode, and totalled as the Value of their block. Calls
+	// failing before entering their callee, e.g. over the caller's balance,
+	// transfer nothing.
+	DisableValueCapture bool
+
+	// EnableOperands captures the stack items consumed by every step, encoded
+	// topmost first as its Operands, so that def-use chains can be rebuilt
+	// from the trace. The field is omitted when disabled.
+	EnableOperands bool
+
+	// EnableStorage records the slots read by SLOAD and written by SSTORE,
+	// along with their values and the address of the storage accessed, which
+	// under DELEGATECALL and CALLCODE is the caller's. The accesses are encoded
+	// as the Storage of the blocks executing them.
+	EnableStorage bool
+
+	// EnableMemory records the memory written by MSTORE, MSTORE8 and the
+	// opcodes copying data into memory, as the offset and size of the range
+	// written along with the Keccak-256 hash of the bytes written, encoded as
+	// the Memory of their opcode. Writes of zero bytes, which leave memory
+	// untouched, are not recorded.
+	EnableMemory bool
+
+	// EnableMemoryData includes the bytes written in the recorded memory
+	// writes, if enabled. Copies may write large ranges, so the bytes are
+	// only hashed by default.
+	EnableMemoryData bool
+
+	// EnableEdges adds the jumps taken to the result, which then becomes an
+	// object of the blocks and the edges, so that the control flow graph can
+	// be rebuilt as executed. Streamed traces hold no edges.
+	EnableEdges bool
+
+	// EnableContracts adds the code executed at every address entered to the
+	// result, which then becomes an object of the blocks and the contracts, so
+	// that the traced pcs can be lined up with the disassembled code. Creations
+	// execute their init code, which is listed apart from the runtime code of
+	// the created contract if it is called in the same transaction.
+	EnableContracts bool
+
+	// PerTransaction traces every transaction apart, completing the trace of
+	// each when it ends, so that a single logger can be attached to the EVM
+	// executing a whole block. The result is then the list of the traces of
+	// the transactions, by hash, a trace failing for a transaction, e.g. over
+	// the memory limit, not failing those of the next ones.
+	PerTransaction bool
+
+	// Format is the encoding of the result, VandalFormatJSON if empty. With
+	// VandalFormatFacts, the result is a JSON object of the contents of the
+	// Datalog facts files of the trace, by file name. With VandalFormatProtobuf,
+	// it is the base64 JSON string of the trace encoded in protobuf.
+	Format string
+}
+
+// vandalOptions are the JSON options of a Vandal trace request, as accepted in
+// the tracer config of the vandalTracer and of the vandal APIs.
+type vandalOptions struct {
+	OpLimit             int              `json:"opLimit"`
+	MemoryLimit         uint64           `json:"memoryLimit"`
+	OnlyAddresses       []common.Address `json:"onlyAddresses"`
+	IncludeReturnData   bool             `json:"includeReturnData"`
+	DisableValueCapture bool             `json:"disableValueCapture"`
+	IncludeOperands     bool             `json:"includeOperands"`
+	IncludeEdges        bool             `json:"includeEdges"`
+	IncludeStorage      bool             `json:"includeStorage"`
+	IncludeContracts    bool             `json:"includeContracts"`
+	IncludeMemory       bool             `json:"includeMemory"`
+	IncludeMemoryData   bool             `json:"includeMemoryData"`
+	PartialOnStop       bool             `json:"partialOnStop"`
+	Format              string           `json:"format"`
+}
+
+// ParseVandalConfig parses the JSON options of a Vandal trace request into the
+// configuration of the logger. Empty options yield the default configuration.
+func ParseVandalConfig(raw json.RawMessage) (*VandalConfig, error) {
+	var opts vandalOptions
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.OpLimit < 0 {
+		return nil, errors.New("opLimit must not be negative")
+	}
+	switch opts.Format {
+	case "", VandalFormatJSON, VandalFormatFacts, VandalFormatProtobuf:
+	default:
+		return nil, fmt.Errorf("unsupported format %q", opts.Format)
+	}
+	return &VandalConfig{
+		MemoryLimit:         opts.MemoryLimit,
+		StepLimit:           opts.OpLimit,
+		OnlyAddresses:       opts.OnlyAddresses,
+		EnableReturnData:    opts.IncludeReturnData,
+		DisableValueCapture: opts.DisableValueCapture,
+		EnableOperands:      opts.IncludeOperands,
+		EnableEdges:         opts.IncludeEdges,
+		EnableStorage:       opts.IncludeStorage,
+		EnableContracts:     opts.IncludeContracts,
+		EnableMemory:        opts.IncludeMemory,
+		EnableMemoryData:    opts.IncludeMemoryData,
+		PartialOnStop:       opts.PartialOnStop,
+		Format:              opts.Format,
+	}, nil
+}
+
+// vandalBasicBlock is a basic block of a trace, its opcodes being the traced
+// steps from Entry to Exit, both included, rather than a copy of them.
 type vandalBasicBlock struct {
 	Entry   uint64
 	Exit    uint64
-	Ops     []*vandalLogMarshalling
 	Address common.Address
 }
 
-type vandalLog struct {
-	Pc    uint64
-	Op    vm.OpCode
-	Gas   uint64
-	Cost  uint64
-	Ret   []byte
-	Value *big.Int
+// VandalTxResult is the trace of a transaction traced by a logger attached to
+// a whole block.
+type VandalTxResult struct {
+	TxHash common.Hash     `json:"txHash"`           // Transaction hash
+	Result json.RawMessage `json:"result,omitempty"` // Trace of the transaction
+	Error  string          `json:"error,omitempty"`  // Trace failure of the transaction
 }
 
-type vandalLogMarshalling struct {
-	Pc        uint64
-	Op        vm.OpCode
-	Gas       uint64
-	Cost      uint64
-	Depth     int
-	CallIndex int
-	Ret       []byte
-	Value     *big.Int
-	Block     *vandalBasicBlock `json:"-"`
+// vandalCode identifies the code executed at an address.
+type vandalCode struct {
+	address common.Address
+	hash    common.Hash
 }
 
-type VandalLogger struct {
-	env *vm.EVM
+// vandalContract is the code executed at an address during a trace.
+type vandalContract struct {
+	vandalCode
+	code []byte
+	init bool // Whether the code is the init code of a creation
+}
+
+// vandalFrame is a call frame being executed.
+type vandalFrame struct {
+	code     common.Address // Address of the code executed, not of the storage
+	index    int32          // Call index, the number of frames entered before it
+	value    *big.Int       // Ether transferred by entering the frame, nil if none
+	operands []uint256.Int  // Operands of the opcode being executed, if captured
 
-	logs      []vandalLog
+	slot  common.Hash // Storage slot accessed by the opcode being executed
+	store common.Hash // Value stored by the opcode being executed
+
+	write   vandalMemoryWrite // Memory written by the opcode being executed
+	written bool              // Whether the memory write was captured
+
+	last  int         // Index of the last step traced in the frame, -1 if none
+	fault vandalFault // Opcode the frame faulted on, if any
+}
+
+type VandalLogger struct {
+	env  *vm.EVM
+	cfg  VandalConfig
+	only map[common.Address]bool // Contracts to trace, nil if all
+
+	steps     vandalSteps
+	bbs       []vandalBasicBlock // Basic blocks of the last result, retained for reuse
+	frames    []vandalFrame      // Call frames being executed
+	value     *big.Int           // Ether transferred by the frame just exited
+	memory    uint64             // Memory used by the traced steps, if limited
+	truncated bool               // Whether steps were dropped over the step limit
 	reason    error
 	interrupt atomic.Bool
 
-	CallStack []vandalLog
-}
+	contracts []vandalContract    // Code executed during the trace, if collected
+	executed  map[vandalCode]bool // Code already in the contracts
 
-func (bb *vandalBasicBlock) Split(entry uint64) vandalBasicBlock {
-	new := vandalBasicBlock{entry, bb.Exit, make([]*vandalLogMarshalling, 0), bb.Address}
-	bb.Exit = entry - 1
-	bb.Ops = bb.Ops[:entry-bb.Entry]
-	new.Ops = bb.Ops[entry-bb.Entry:]
+	txs []VandalTxResult // Traces of the transactions completed, if traced apart
 
-	for _, op := range new.Ops {
-		op.Block = &new
-	}
+	stream  *bufio.Writer // Destination of the completed blocks, if streaming
+	blocked int           // Number of blocks streamed
+	buf     []byte        // Encoding buffer of streamed blocks
+}
 
-	for _, op := range bb.Ops {
-		op.Block = bb
+func NewVandalTracer(cfg *VandalConfig) *VandalLogger {
+	logger := &VandalLogger{}
+	if cfg != nil {
+		logger.cfg = *cfg
 	}
-
-	return new
+	if len(logger.cfg.OnlyAddresses) > 0 {
+		logger.only = make(map[common.Address]bool, len(logger.cfg.OnlyAddresses))
+		for _, addr := range logger.cfg.OnlyAddresses {
+			logger.only[addr] = true
+		}
+	}
+	return logger
 }
 
-func NewVandalTracer() *VandalLogger {
-	return &VandalLogger{}
+// NewVandalStreamLogger returns a Vandal logger which writes every basic block
+// to w as soon as it is complete, one JSON encoded block per line, instead of
+// holding the whole trace until GetResult. Only the steps of the block being
+// traced are kept in memory. The result is then a summary of the trace, and
+// the format of the configuration is ignored. The blocks of a failed frame
+// written before it failed are not marked as failed, only its last block is.
+func NewVandalStreamLogger(cfg *VandalConfig, w io.Writer) *VandalLogger {
+	logger := NewVandalTracer(cfg)
+	logger.stream = bufio.NewWriter(w)
+	return logger
 }
 
 // CaptureStart implements the EVMLogger interface to initialize the tracing operation.
 func (l *VandalLogger) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
 	l.env = env
-	l.CallStack = make([]vandalLog, 0)
+	l.frames = l.frames[:0]
+	l.pushFrame(to, to, nil)
+	l.collectContract(to, create, input)
+}
+
+// collectContract adds the code executed by a frame entered at the given address
+// to the contracts, unless already there or not collected: the init code given
+// as input for creations, the code of the address otherwise.
+func (l *VandalLogger) collectContract(addr common.Address, create bool, input []byte) {
+	if !l.cfg.EnableContracts || (l.only != nil && !l.only[addr]) {
+		return
+	}
+	var contract vandalContract
+	switch {
+	case create:
+		contract = vandalContract{vandalCode{addr, crypto.Keccak256Hash(input)}, common.CopyBytes(input), true}
+	case l.env != nil:
+		contract = vandalContract{vandalCode{addr, l.env.StateDB.GetCodeHash(addr)}, l.env.StateDB.GetCode(addr), false}
+	}
+	// Accounts without code, e.g. precompiles, execute nothing
+	if len(contract.code) == 0 || l.executed[contract.vandalCode] {
+		return
+	}
+	if l.executed == nil {
+		l.executed = make(map[vandalCode]bool)
+	}
+	l.executed[contract.vandalCode] = true
+	l.contracts = append(l.contracts, contract)
+}
+
+// pushFrame enters a call frame executing the given code on the storage of the
+// given owner, reusing the buffers of a previous frame.
+func (l *VandalLogger) pushFrame(code common.Address, owner common.Address, value *big.Int) {
+	if len(l.frames) < cap(l.frames) {
+		l.frames = l.frames[:len(l.frames)+1]
+	} else {
+		l.frames = append(l.frames, vandalFrame{})
+	}
+	frame := &l.frames[len(l.frames)-1]
+	frame.code, frame.value, frame.operands = code, value, frame.operands[:0]
+	frame.index = int32(len(l.steps.codes))
+	frame.last, frame.fault = -1, vandalFault{}
+	l.steps.codes = append(l.steps.codes, code)
+	l.steps.owners = append(l.steps.owners, owner)
+	l.steps.faults = append(l.steps.faults, nil)
+}
+
+// popFrame exits the innermost call frame, recording its failure if it ended
+// with an error. The reason of a revert is decoded from the output if it holds
+// a Solidity error or panic.
+func (l *VandalLogger) popFrame(output []byte, err error) {
+	frame := &l.frames[len(l.frames)-1]
+	if err != nil {
+		fault := frame.fault
+		fault.step, fault.err = frame.last, err.Error()
+		if errors.Is(err, vm.ErrExecutionReverted) {
+			if reason, err := abi.UnpackRevert(output); err == nil {
+				fault.reason = reason
+			}
+		}
+		l.steps.faults[frame.index] = &fault
+	}
+	l.value = frame.value
+	l.frames = l.frames[:len(l.frames)-1]
+}
+
+// CaptureOperands implements the VandalLogger interface to capture the stack
+// items consumed by the opcode about to be executed.
+func (l *VandalLogger) CaptureOperands(op vm.OpCode, operands []uint256.Int) {
+	var (
+		storage = l.cfg.EnableStorage && (op == vm.SLOAD || op == vm.SSTORE)
+		memory  = l.cfg.EnableMemory && writesVandalMemory(op)
+	)
+	if !l.cfg.EnableOperands && !storage && !memory {
+		return
+	}
+	if len(l.frames) == 0 {
+		l.pushFrame(common.Address{}, common.Address{}, nil)
+	}
+	// Frames spawned by the opcode are traced before it, so hold the operands
+	// in its frame until then
+	frame := &l.frames[len(l.frames)-1]
+	if storage && len(operands) > 0 {
+		frame.slot = operands[len(operands)-1].Bytes32()
+		if op == vm.SSTORE && len(operands) > 1 {
+			frame.store = operands[len(operands)-2].Bytes32()
+		}
+	}
+	if memory {
+		frame.write.offset, frame.write.size = vandalMemoryRange(op, operands)
+		frame.written = false
+	}
+	if l.cfg.EnableOperands {
+		frame.operands = append(frame.operands[:0], operands...)
+	}
+}
+
+// CaptureMemory implements the VandalLogger interface to capture the memory
+// written by the opcode just executed, in the range held since its operands
+// were captured.
+func (l *VandalLogger) CaptureMemory(op vm.OpCode, memory []byte) {
+	if !l.cfg.EnableMemory || !writesVandalMemory(op) || len(l.frames) == 0 {
+		return
+	}
+	frame := &l.frames[len(l.frames)-1]
+	end := frame.write.offset + frame.write.size
+	if frame.write.size == 0 || end < frame.write.offset || end > uint64(len(memory)) {
+		return
+	}
+	data := memory[frame.write.offset:end]
+	frame.write.hash = crypto.Keccak256Hash(data)
+	frame.write.data = nil
+	if l.cfg.EnableMemoryData {
+		frame.write.data = common.CopyBytes(data)
+	}
+	frame.written = true
 }
 
 // CaptureState implements the EVMLogger interface to trace a single step of VM execution.
 func (l *VandalLogger) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, res []byte) {
+	// The value transferred by a frame belongs to the opcode that spawned it,
+	// which is captured once the frame is exited
+	value := l.value
+	l.value = nil
+
 	if l.interrupt.Load() {
 		return
 	}
-
-	log := vandalLog{
-		Pc:   pc,
-		Op:   op,
-		Gas:  gas,
-		Cost: cost,
-		Ret:  res,
+	if l.only != nil && (len(l.frames) == 0 || !l.only[l.frames[len(l.frames)-1].code]) {
+		return
+	}
+	if limit := l.cfg.StepLimit; limit != 0 && l.Steps() >= limit {
+		l.truncated = true
+		return
+	}
+	var (
+		depth, call int32
+		operands    []uint256.Int
+	)
+	if len(l.frames) > 0 {
+		frame := &l.frames[len(l.frames)-1]
+		depth, call = int32(len(l.frames)), frame.index
+		if l.cfg.EnableOperands {
+			operands, frame.operands = frame.operands, frame.operands[:0]
+		}
+	}
+	// When streaming, the steps held form a complete block once another starts
+	if n := l.steps.len(); l.stream != nil && n > 0 && splitsVandalBlock(l.steps.call[n-1], l.steps.pc[n-1], l.steps.op[n-1], call, pc, op) {
+		if l.flushBlock(); l.reason != nil {
+			return
+		}
 	}
+	if limit := l.cfg.MemoryLimit; limit != 0 {
+		l.memory += vandalStepSize
+		if l.cfg.EnableReturnData {
+			l.memory += vandalRetSize + uint64(len(res))
+		}
+		if l.cfg.EnableOperands {
+			l.memory += vandalOperandsSize + uint64(len(operands))*32
+		}
+		if l.cfg.EnableStorage && (op == vm.SLOAD || op == vm.SSTORE) {
+			l.memory += vandalStorageSize
+		}
+		if write := l.memoryWrite(op); write != nil {
+			l.memory += vandalMemoryWriteSize + uint64(len(write.data))
+		}
+		if l.memory > limit {
+			l.Stop(fmt.Errorf("%w: %d bytes after %d steps", ErrVandalMemoryLimit, limit, l.Steps()))
+			return
+		}
+	}
+	l.steps.pc = append(l.steps.pc, pc)
+	l.steps.op = append(l.steps.op, op)
+	l.steps.gas = append(l.steps.gas, gas)
+	l.steps.cost = append(l.steps.cost, cost)
+	l.steps.depth = append(l.steps.depth, depth)
+	l.steps.call = append(l.steps.call, call)
+	if l.cfg.EnableReturnData {
+		l.steps.ret = append(l.steps.ret, res)
+	}
+	if l.cfg.EnableOperands {
+		l.steps.appendOperands(operands)
+	}
+	if l.cfg.EnableStorage && (op == vm.SLOAD || op == vm.SSTORE) && len(l.frames) > 0 {
+		frame := &l.frames[len(l.frames)-1]
+		access := vandalStorageAccess{step: l.steps.len() - 1, slot: frame.slot, value: frame.store}
+		if op == vm.SLOAD {
+			access.value = common.BytesToHash(res)
+		}
+		l.steps.storage = append(l.steps.storage, access)
+	}
+	if write := l.memoryWrite(op); write != nil {
+		write.step = l.steps.len() - 1
+		l.steps.memory = append(l.steps.memory, *write)
+	}
+	if value != nil {
+		l.steps.setValue(l.steps.len()-1, value)
+	}
+	if len(l.frames) > 0 {
+		l.frames[len(l.frames)-1].last = l.Steps() - 1
+	}
+}
 
-	l.logs = append(l.logs, log)
+// memoryWrite returns the memory written by the opcode being executed in the
+// innermost frame, nil if none captured.
+func (l *VandalLogger) memoryWrite(op vm.OpCode) *vandalMemoryWrite {
+	if !l.cfg.EnableMemory || len(l.frames) == 0 || !writesVandalMemory(op) {
+		return nil
+	}
+	if frame := &l.frames[len(l.frames)-1]; frame.written {
+		return &frame.write
+	}
+	return nil
 }
 
 // CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
 func (l *VandalLogger) CaptureEnter(op vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	var transferred *big.Int
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.CREATE, vm.CREATE2, vm.SELFDESTRUCT:
+		if !l.cfg.DisableValueCapture && value != nil && value.Sign() > 0 {
+			transferred = new(big.Int).Set(value)
+		}
+	}
+	// Code called via DELEGATECALL or CALLCODE runs on the storage of its caller
+	owner := to
+	if op == vm.DELEGATECALL || op == vm.CALLCODE {
+		owner = from
+	}
+	l.pushFrame(to, owner, transferred)
+
+	// Self-destructs enter the beneficiary without executing its code
+	if op != vm.SELFDESTRUCT {
+		l.collectContract(to, op == vm.CREATE || op == vm.CREATE2, input)
+	}
 }
 
 // CaptureExit is called when EVM exits a scope, even if the scope didn't
 // execute any code.
 func (l *VandalLogger) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(l.frames) == 0 {
+		return
+	}
+	l.popFrame(output, err)
 }
 
 // CaptureFault implements the EVMLogger interface to trace an execution fault.
+// The faulting opcode is recorded for the frame, which fails once exited. An
+// opcode failing before its execution, e.g. out of gas, is not traced as a
+// step, but still recorded as the fault.
 func (l *VandalLogger) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	if len(l.frames) == 0 {
+		return
+	}
+	l.frames[len(l.frames)-1].fault = vandalFault{pc: pc, op: op, gas: gas, known: true}
 }
 
 // CaptureEnd is called after the call finishes to finalize the tracing.
-func (l *VandalLogger) CaptureEnd(output []byte, gasUsed uint64, err error) {}
+func (l *VandalLogger) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	if len(l.frames) > 0 {
+		l.popFrame(output, err)
+	}
+	if l.stream != nil {
+		l.flushBlock()
+	}
+}
 
+// flushBlock writes the steps held, forming a complete basic block, to the
+// stream and drops them.
+func (l *VandalLogger) flushBlock() {
+	n := l.steps.len()
+	if n == 0 || l.failure() != nil {
+		return
+	}
+	bb := vandalBasicBlock{Entry: 0, Exit: uint64(n - 1), Address: l.steps.codeAt(0)}
+	l.buf = append(appendVandalBlock(l.buf[:0], &l.steps, &bb, &l.cfg), '\n')
+	if _, err := l.stream.Write(l.buf); err != nil {
+		l.Stop(fmt.Errorf("%w: %w", errVandalStreamWrite, err))
+		return
+	}
+	l.blocked++
+	l.steps.drop()
+	l.memory = 0
+}
+
+// CaptureTxStart preallocates the trace of a transaction from its gas limit, to
+// avoid repeatedly growing it while tracing large transactions. No more steps
+// are preallocated than the step and memory limits allow to be traced.
 func (l *VandalLogger) CaptureTxStart(gasLimit uint64) {
+	n := estimateVandalSteps(gasLimit)
+	if limit := l.cfg.StepLimit; limit != 0 && n > limit {
+		n = limit
+	}
+	if limit := l.cfg.MemoryLimit; limit != 0 && uint64(n) > limit/vandalStepSize {
+		n = int(limit / vandalStepSize)
+	}
+	if l.stream == nil && l.steps.len() == 0 && cap(l.steps.pc) < n {
+		l.steps.presize(n, l.cfg.EnableReturnData, l.cfg.EnableOperands)
+	}
 }
 
-func (l *VandalLogger) CaptureTxEnd(restGas uint64) {}
+// CaptureTxEnd completes the trace of a transaction if transactions are traced
+// apart, readying the logger for the next one. The transaction is identified by
+// the hash set as the context of the state, if any.
+func (l *VandalLogger) CaptureTxEnd(restGas uint64) {
+	if !l.cfg.PerTransaction {
+		return
+	}
+	var tx VandalTxResult
+	if l.env != nil {
+		if statedb, ok := l.env.StateDB.(interface{ TxHash() common.Hash }); ok {
+			tx.TxHash = statedb.TxHash()
+		}
+	}
+	if res, err := l.result(); err != nil {
+		tx.Error = err.Error()
+	} else {
+		tx.Result = res
+	}
+	l.txs = append(l.txs, tx)
+	l.resetTx()
+}
+
+// TxResults returns the traces of the transactions completed so far, if traced
+// apart.
+func (l *VandalLogger) TxResults() []VandalTxResult {
+	return l.txs
+}
+
+// estimateVandalSteps estimates the number of opcodes executed by a transaction
+// with the given gas limit.
+func estimateVandalSteps(gasLimit uint64) int {
+	steps := gasLimit / vandalGasPerStep
+	if steps > vandalMaxPresize {
+		steps = vandalMaxPresize
+	}
+	return int(steps)
+}
+
+// Steps returns the number of opcodes traced so far, including those streamed.
+func (l *VandalLogger) Steps() int {
+	return l.steps.dropped + l.steps.len()
+}
+
+// Reset clears the trace, readying the logger for tracing another transaction
+// while keeping its buffers, unless they exceed vandalMaxRetained. The traces of
+// the transactions traced apart are dropped too.
+func (l *VandalLogger) Reset() {
+	l.resetTx()
+	clear(l.txs)
+	l.txs = l.txs[:0]
+}
+
+// resetTx clears the trace of the transaction being traced.
+func (l *VandalLogger) resetTx() {
+	l.steps.reset(vandalMaxRetained)
+
+	clear(l.bbs)
+	if uint64(cap(l.bbs))*uint64(unsafe.Sizeof(vandalBasicBlock{})) > vandalMaxRetained {
+		l.bbs = nil
+	} else {
+		l.bbs = l.bbs[:0]
+	}
+	for i, frames := 0, l.frames[:cap(l.frames)]; i < len(frames); i++ {
+		frames[i].value, frames[i].write.data = nil, nil // Keep the operand buffers, drop the values
+	}
+	clear(l.contracts) // Drop the references to the code
+	clear(l.executed)
+	l.env, l.frames, l.value, l.contracts = nil, l.frames[:0], nil, l.contracts[:0]
+	l.memory, l.truncated, l.reason, l.blocked = 0, false, nil, 0
+	l.interrupt.Store(false)
+}
 
 // GetResult returns the json-encoded nested list of call traces, and any
-// error arising from the encoding or forceful termination (via `Stop`).
+// error arising from the encoding or forceful termination (via `Stop`), unless
+// partial results are returned on termination. A trace truncated over the step
+// limit or terminated is wrapped in an object along with a truncated marker and
+// the number of steps traced, as are the blocks of a trace with edges or
+// contracts, along with them. A streaming logger writes out the
+// last block and returns {"blocks": <n>, "steps": <n>} instead, along with the
+// truncated marker and contracts if any. A logger tracing transactions apart
+// returns the list of their traces, see VandalTxResult.
 func (l *VandalLogger) GetResult() (json.RawMessage, error) {
-	if l.reason != nil {
-		return nil, l.reason
+	if l.cfg.PerTransaction {
+		if len(l.txs) == 0 {
+			return json.RawMessage("[]"), nil
+		}
+		return json.Marshal(l.txs)
 	}
+	return l.result()
+}
 
-	blocks := make([]vandalBasicBlock, 0)
-	entry := uint64(0)
-	exit := uint64(len(l.logs) - 1)
-	callIndex := uint64(0)
-	depth := 0
-	current := vandalBasicBlock{entry, exit, make([]*vandalLogMarshalling, 0), common.Address{}}
-	marshalLogs := make([]*vandalLogMarshalling, 0, len(l.logs))
+// result returns the trace of the transaction being traced.
+func (l *VandalLogger) result() (json.RawMessage, error) {
+	if l.stream != nil {
+		return l.finishStream()
+	}
+	switch l.cfg.Format {
+	case VandalFormatFacts:
+		return l.encodeVandalFacts()
+	case VandalFormatProtobuf:
+		return l.encodeVandalProtobuf()
+	}
+	if err := l.failure(); err != nil {
+		return nil, err
+	}
+	var (
+		blocks = l.blocks()
+		buf    = make([]byte, 0, l.steps.len()*vandalOpSizeHint)
+	)
+	if !l.Truncated() && !l.cfg.EnableEdges && !l.cfg.EnableContracts {
+		return appendVandalBlocks(buf, &l.steps, blocks, &l.cfg), nil
+	}
+	buf = append(buf, `{"blocks":`...)
+	buf = appendVandalBlocks(buf, &l.steps, blocks, &l.cfg)
+	if l.cfg.EnableEdges {
+		buf = append(buf, `,"edges":`...)
+		buf = appendVandalEdges(buf, &l.steps)
+	}
+	if l.cfg.EnableContracts {
+		buf = append(buf, `,"contracts":`...)
+		buf = appendVandalContracts(buf, l.contracts)
+	}
+	// Mark truncated traces, so they cannot be mistaken for complete ones
+	if l.Truncated() {
+		buf = append(buf, `,"truncated":true,"steps":`...)
+		buf = strconv.AppendInt(buf, int64(l.steps.len()), 10)
+	}
+	return append(buf, '}'), nil
+}
 
-	for i, log := range l.logs {
-		if log.Pc == 0 && i != 0 {
-			callIndex++
-		}
-		marshalLogs = append(marshalLogs, &vandalLogMarshalling{
-			Pc:        log.Pc,
-			Op:        log.Op,
-			Gas:       log.Gas,
-			Cost:      log.Cost,
-			Depth:     depth,
-			CallIndex: int(callIndex),
-			Ret:       log.Ret,
-			Value:     log.Value,
-		})
-	}
-
-	for i, log := range marshalLogs {
-		log.Block = &current
-		current.Ops = append(current.Ops, log)
-
-		if log.Pc == 0 && i == 0 {
-			depth = 1
-		} else if log.Pc == 0 {
-			depth--
-			new := current.Split(uint64(i))
-			blocks = append(blocks, current)
-			current = new
-		} else if GetKind(log.Op) == OpKindOne || GetKind(log.Op) == OpKindFive {
-			if !(marshalLogs[i-1].CallIndex == log.CallIndex &&
-				log.Pc-marshalLogs[i-1].Pc == uint64(pcGap(marshalLogs[i-1].Op)) &&
-				!possiblyHalts(marshalLogs[i-1].Op)) {
-
-				depth -= 1
-				new := current.Split(uint64(i))
-				blocks = append(blocks, current)
-				current = new
-			}
-		} else if i == len(marshalLogs)-1 {
-			blocks = append(blocks, current)
-		}
+// finishStream writes out the last block of a streaming logger, returning the
+// summary of the trace.
+func (l *VandalLogger) finishStream() (json.RawMessage, error) {
+	l.flushBlock()
+	if err := l.failure(); err != nil {
+		return nil, err
+	}
+	if err := l.stream.Flush(); err != nil {
+		return nil, fmt.Errorf("%w: %w", errVandalStreamWrite, err)
+	}
+	buf := append([]byte(`{"blocks":`), strconv.Itoa(l.blocked)...)
+	buf = append(buf, `,"steps":`...)
+	buf = strconv.AppendInt(buf, int64(l.Steps()), 10)
+	if l.Truncated() {
+		buf = append(buf, `,"truncated":true`...)
+	}
+	if l.cfg.EnableContracts {
+		buf = append(buf, `,"contracts":`...)
+		buf = appendVandalContracts(buf, l.contracts)
+	}
+	return append(buf, '}'), nil
+}
+
+// Truncated reports whether steps were dropped from the trace over the step
+// limit, or the trace was stopped and its steps so far are returned.
+func (l *VandalLogger) Truncated() bool {
+	return l.truncated || (l.reason != nil && l.failure() == nil)
+}
 
-		log.Depth = depth
-		log.CallIndex = int(callIndex)
+// failure returns the error the trace was stopped with, nil if it was not, or
+// if the steps traced so far are returned instead.
+func (l *VandalLogger) failure() error {
+	if l.cfg.PartialOnStop && !errors.Is(l.reason, errVandalStreamWrite) {
+		return nil
 	}
+	return l.reason
+}
 
-	return json.Marshal(blocks)
+// blocks splits the traced opcodes into basic blocks, each annotated with the
+// address of the code it belongs to. Blocks never span call frames: entering a
+// frame or returning to the calling one starts a new block.
+// Within a frame, a block is also ended before an opcode of kind one or five
+// not reached sequentially from the previous one.
+func (l *VandalLogger) blocks() []vandalBasicBlock {
+	var (
+		s      = &l.steps
+		blocks = l.bbs[:0]
+		entry  int // Index of the first opcode of the current block
+	)
+	for i := 1; i < len(s.pc); i++ {
+		if splitsVandalBlock(s.call[i-1], s.pc[i-1], s.op[i-1], s.call[i], s.pc[i], s.op[i]) {
+			blocks = append(blocks, vandalBasicBlock{Entry: uint64(entry), Exit: uint64(i - 1), Address: s.codeAt(entry)})
+			entry = i
+		}
+	}
+	if len(s.pc) > 0 {
+		blocks = append(blocks, vandalBasicBlock{Entry: uint64(entry), Exit: uint64(len(s.pc) - 1), Address: s.codeAt(entry)})
+	}
+	l.bbs = blocks
+	return blocks
+}
+
+// splitsVandalBlock reports whether an opcode, executed in the frame of the
+// given call index, starts a new basic block after the previous one.
+func splitsVandalBlock(prevCall int32, prevPc uint64, prev vm.OpCode, call int32, pc uint64, op vm.OpCode) bool {
+	if call != prevCall {
+		return true
+	}
+	if kind := GetKind(op); kind != OpKindOne && kind != OpKindFive {
+		return false
+	}
+	return pc-prevPc != uint64(pcGap(prev)) || possiblyHalts(prev)
 }
 
 // Stop terminates execution of the tracer at the first opportune moment.
@@ -181,6 +807,8 @@ func (l *VandalLogger) Stop(err error) {
 	l.interrupt.Store(true)
 }
 
+// OpKind is the class of an opcode in the Vandal decompiler, by the stack items
+// it reads and writes and how it accesses memory or storage.
 type OpKind int
 
 const (
@@ -194,88 +822,123 @@ const (
 	OpKindFive          OpKind = 7
 )
 
-func possiblyHalts(op vm.OpCode) bool {
-	switch op.String() {
-	case vm.STOP.String(),
-		vm.REVERT.String(),
-		vm.SELFDESTRUCT.String(),
-		vm.RETURN.String():
-		return true
+// vandalKinds is the kind of every opcode, OpKindUnknown for the unlisted ones.
+// The opcodes are classified by index rather than by name, as splitting the
+// steps of large traces into blocks looks up the kind of every step.
+var vandalKinds = [256]OpKind{
+	vm.ADDRESS:        OpKindOne,
+	vm.ORIGIN:         OpKindOne,
+	vm.CALLER:         OpKindOne,
+	vm.CALLVALUE:      OpKindOne,
+	vm.CALLDATASIZE:   OpKindOne,
+	vm.CODESIZE:       OpKindOne,
+	vm.GASPRICE:       OpKindOne,
+	vm.RETURNDATASIZE: OpKindOne,
+	vm.COINBASE:       OpKindOne,
+	vm.TIMESTAMP:      OpKindOne,
+	vm.NUMBER:         OpKindOne,
+	vm.DIFFICULTY:     OpKindOne,
+	vm.GASLIMIT:       OpKindOne,
+	vm.CHAINID:        OpKindOne,
+	vm.SELFBALANCE:    OpKindOne,
+	vm.BASEFEE:        OpKindOne,
+	vm.BLOBBASEFEE:    OpKindOne,
+	vm.PC:             OpKindOne,
+	vm.MSIZE:          OpKindOne,
+	vm.GAS:            OpKindOne,
+
+	vm.KECCAK256:    OpKindTwo,
+	vm.BALANCE:      OpKindTwo,
+	vm.CALLDATALOAD: OpKindTwo,
+	vm.EXTCODESIZE:  OpKindTwo,
+	vm.EXTCODEHASH:  OpKindTwo,
+	vm.BLOCKHASH:    OpKindTwo,
+	vm.BLOBHASH:     OpKindTwo,
+
+	vm.SLOAD: OpKindThreeLoad,
+	vm.TLOAD: OpKindThreeLoad,
+
+	vm.SSTORE:  OpKindThreeStoreOne,
+	vm.TSTORE:  OpKindThreeStoreOne,
+	vm.MSTORE:  OpKindThreeStoreOne,
+	vm.MSTORE8: OpKindThreeStoreOne,
+
+	vm.CALLDATACOPY:   OpKindThreeStoreTwo,
+	vm.CODECOPY:       OpKindThreeStoreTwo,
+	vm.EXTCODECOPY:    OpKindThreeStoreTwo,
+	vm.RETURNDATACOPY: OpKindThreeStoreTwo,
+	vm.MCOPY:          OpKindThreeStoreTwo,
+
+	vm.CALL:         OpKindFour,
+	vm.CALLCODE:     OpKindFour,
+	vm.DELEGATECALL: OpKindFour,
+	vm.STATICCALL:   OpKindFour,
+
+	vm.CREATE:  OpKindFive,
+	vm.CREATE2: OpKindFive,
+}
 
-	default:
-		return false
+// writesVandalMemory reports whether an opcode writes to memory, other than the
+// calls writing their return data.
+func writesVandalMemory(op vm.OpCode) bool {
+	switch op {
+	case vm.MSTORE, vm.MSTORE8, vm.CALLDATACOPY, vm.CODECOPY, vm.EXTCODECOPY, vm.RETURNDATACOPY, vm.MCOPY:
+		return true
 	}
+	return false
 }
 
-func pcGap(op vm.OpCode) int {
-	if op.IsPush() {
-		return int(op - vm.PUSH1 + 1)
-	} else {
-		return 1
+// vandalMemoryRange returns the offset and size of the memory written by an
+// opcode with the given operands, topmost last. The range only fits in 64 bits
+// if the opcode succeeds, and is empty for opcodes not writing to memory.
+func vandalMemoryRange(op vm.OpCode, operands []uint256.Int) (offset, size uint64) {
+	n := len(operands)
+	switch {
+	case op == vm.MSTORE && n >= 2:
+		return operands[n-1].Uint64(), 32
+	case op == vm.MSTORE8 && n >= 2:
+		return operands[n-1].Uint64(), 1
+	case (op == vm.CALLDATACOPY || op == vm.CODECOPY || op == vm.RETURNDATACOPY || op == vm.MCOPY) && n >= 3:
+		return operands[n-1].Uint64(), operands[n-3].Uint64()
+	case op == vm.EXTCODECOPY && n >= 4:
+		return operands[n-2].Uint64(), operands[n-4].Uint64()
 	}
+	return 0, 0
 }
 
-func GetKind(op vm.OpCode) OpKind {
-	switch op.String() {
-	case
-		vm.ADDRESS.String(),
-		vm.ORIGIN.String(),
-		vm.CALLER.String(),
-		vm.CALLVALUE.String(),
-		vm.CALLDATASIZE.String(),
-		vm.CODESIZE.String(),
-		vm.GASPRICE.String(),
-		vm.RETURNDATASIZE.String(),
-		vm.COINBASE.String(),
-		vm.TIMESTAMP.String(),
-		vm.NUMBER.String(),
-		vm.DIFFICULTY.String(),
-		vm.GASLIMIT.String(),
-		vm.PC.String(),
-		vm.MSIZE.String(),
-		vm.GAS.String():
-
-		return OpKindOne
-	case
-		vm.KECCAK256.String(),
-		vm.BALANCE.String(),
-		vm.CALLDATALOAD.String(),
-		vm.EXTCODESIZE.String(),
-		vm.BLOCKHASH.String():
-
-		return OpKindTwo
-	case
-		vm.SLOAD.String():
-
-		return OpKindThreeLoad
-	case
-		vm.SSTORE.String(),
-		vm.MSTORE.String(),
-		vm.MSTORE8.String():
-
-		return OpKindThreeStoreOne
-	case
-		vm.CALLDATACOPY.String(),
-		vm.CODECOPY.String(),
-		vm.EXTCODECOPY.String(),
-		vm.RETURNDATACOPY.String():
-
-		return OpKindThreeStoreTwo
-	case
-		vm.CALL.String(),
-		vm.CALLCODE.String(),
-		vm.DELEGATECALL.String(),
-		vm.STATICCALL.String():
-
-		return OpKindFour
-
-	case
-		vm.CREATE.String(),
-		vm.CREATE2.String():
-
-		return OpKindFive
+// vandalHalts marks the opcodes possibly ending the execution of their frame.
+var vandalHalts = [256]bool{
+	vm.STOP:         true,
+	vm.RETURN:       true,
+	vm.REVERT:       true,
+	vm.SELFDESTRUCT: true,
+}
 
-	default:
-		return OpKindUnknown
+// vandalGaps is the pc gap of every opcode, see pcGap.
+var vandalGaps = func() (gaps [256]uint8) {
+	for op := range gaps {
+		gaps[op] = 1
+		if vm.OpCode(op).IsPush() {
+			gaps[op] = uint8(op - int(vm.PUSH0)) // Number of immediate bytes
+		}
 	}
+	return gaps
+}()
+
+func possiblyHalts(op vm.OpCode) bool {
+	return vandalHalts[op]
+}
+
+// pcGap returns the pc distance after an opcode that splitsVandalBlock takes
+// as falling through to the next one. It is 1 for most opcodes, but for pushes
+// only their number of immediate bytes, n for PUSHn and 0 for PUSH0, one less
+// than the actual distance n+1 to the next opcode. An opcode of kind one or
+// five executed right after a push therefore always starts a new block.
+func pcGap(op vm.OpCode) int {
+	return int(vandalGaps[op])
+}
+
+// GetKind returns the Vandal kind of an opcode.
+func GetKind(op vm.OpCode) OpKind {
+	return vandalKinds[op]
 }
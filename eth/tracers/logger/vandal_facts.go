@@ -0,0 +1,224 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// VandalFormatJSON encodes Vandal traces as a JSON list of basic blocks.
+	VandalFormatJSON = "json"
+
+	// VandalFormatFacts encodes Vandal traces as the tab separated Datalog
+	// facts consumed by Souffle, see VandalLogger.Facts.
+	VandalFormatFacts = "facts"
+)
+
+// vandalFactsRelations are the names of the facts files of a Vandal trace, all
+// of which are produced, even if empty, as Souffle fails on missing inputs.
+var vandalFactsRelations = []string{
+	"block.facts",
+	"op.facts",
+	"edge.facts",
+	"jump.facts",
+	"storage.facts",
+	"memory.facts",
+	"fault.facts",
+	"contract.facts",
+	"value.facts",
+	"operand.facts",
+	"ret.facts",
+	"truncated.facts",
+}
+
+// Facts returns the trace as tab separated Datalog facts, by file name. Blocks
+// are identified by the index of their first step, and steps by their index in
+// the trace. The relations are:
+//
+//	block.facts:     block, exit step, code address
+//	op.facts:        step, block, pc, opcode name, depth, call index
+//	edge.facts:      block, next block executed
+//	jump.facts:      step, pc jumped from, pc jumped to, for the jumps taken
+//	storage.facts:   step, opcode name, storage address, slot, value
+//	memory.facts:    step, offset, size, hash of the bytes written, bytes in hex
+//	fault.facts:     call index, last step of the failed frame, error, revert reason
+//	                 and the pc, opcode name and gas of the faulting opcode if known
+//	contract.facts:  address, code hash, code in hex, "init" or "runtime" code
+//	value.facts:     step, ether transferred in wei
+//	operand.facts:   step, position (0 = topmost), stack item
+//	ret.facts:       step, output of the step in hex
+//	truncated.facts: number of steps traced, if truncated over the step limit or
+//	                 stopped with partial results
+//
+// Values, operands, outputs, memory writes and contracts are only present if
+// captured, as are the bytes written to memory. Failed frames that traced no
+// step have -1 as their last step, and unknown fields are empty.
+func (l *VandalLogger) Facts() (map[string][]byte, error) {
+	if err := l.failure(); err != nil {
+		return nil, err
+	}
+	var (
+		s         = &l.steps
+		blocks    = l.blocks()
+		block     = make([]byte, 0, len(blocks)*64)
+		op        = make([]byte, 0, s.len()*32)
+		edge      = make([]byte, 0, len(blocks)*16)
+		jump      []byte
+		storage   []byte
+		memory    []byte
+		fault     []byte
+		contract  []byte
+		value     []byte
+		operand   []byte
+		ret       []byte
+		truncated []byte
+	)
+	for b := range blocks {
+		bb := &blocks[b]
+		block = appendVandalFact(block, strconv.FormatUint(bb.Entry, 10), strconv.FormatUint(bb.Exit, 10), "0x"+hex.EncodeToString(bb.Address[:]))
+		if b > 0 {
+			edge = appendVandalFact(edge, strconv.FormatUint(blocks[b-1].Entry, 10), strconv.FormatUint(bb.Entry, 10))
+		}
+		for i := int(bb.Entry); i <= int(bb.Exit); i++ {
+			step := strconv.Itoa(i)
+			op = appendVandalFact(op, step, strconv.FormatUint(bb.Entry, 10), strconv.FormatUint(s.pc[i], 10),
+				s.op[i].String(), strconv.Itoa(int(s.depth[i])), strconv.Itoa(int(s.call[i])))
+
+			if to, ok := s.jumpAt(i); ok {
+				jump = appendVandalFact(jump, step, strconv.FormatUint(s.pc[i], 10), strconv.FormatUint(to, 10))
+			}
+			if v := s.valueAt(i); v != nil {
+				value = appendVandalFact(value, step, v.String())
+			}
+			if operands, ok := s.operandsAt(i); ok {
+				for j := range operands {
+					operand = appendVandalFact(operand, step, strconv.Itoa(j), operands[j].Hex())
+				}
+			}
+			if out := s.retAt(i); out != nil {
+				ret = appendVandalFact(ret, step, "0x"+hex.EncodeToString(out))
+			}
+		}
+	}
+	for _, access := range s.storage {
+		owner := s.ownerAt(access.step)
+		storage = appendVandalFact(storage, strconv.Itoa(access.step), s.op[access.step].String(),
+			"0x"+hex.EncodeToString(owner[:]), access.slot.Hex(), access.value.Hex())
+	}
+	for _, write := range s.memory {
+		var data string
+		if write.data != nil {
+			data = "0x" + hex.EncodeToString(write.data)
+		}
+		memory = appendVandalFact(memory, strconv.Itoa(write.step), strconv.FormatUint(write.offset, 10),
+			strconv.FormatUint(write.size, 10), write.hash.Hex(), data)
+	}
+	for call, f := range s.faults {
+		if f == nil {
+			continue
+		}
+		var pc, name, gas string
+		if f.known {
+			pc, name, gas = strconv.FormatUint(f.pc, 10), f.op.String(), strconv.FormatUint(f.gas, 10)
+		}
+		fault = appendVandalFact(fault, strconv.Itoa(call), strconv.Itoa(f.step), vandalFactField(f.err), vandalFactField(f.reason), pc, name, gas)
+	}
+	for _, c := range l.contracts {
+		kind := "runtime"
+		if c.init {
+			kind = "init"
+		}
+		contract = appendVandalFact(contract, "0x"+hex.EncodeToString(c.address[:]), c.hash.Hex(), "0x"+hex.EncodeToString(c.code), kind)
+	}
+	if l.Truncated() {
+		truncated = appendVandalFact(nil, strconv.Itoa(s.len()))
+	}
+	return map[string][]byte{
+		"block.facts":     block,
+		"op.facts":        op,
+		"edge.facts":      edge,
+		"jump.facts":      jump,
+		"storage.facts":   storage,
+		"memory.facts":    memory,
+		"fault.facts":     fault,
+		"contract.facts":  contract,
+		"value.facts":     value,
+		"operand.facts":   operand,
+		"ret.facts":       ret,
+		"truncated.facts": truncated,
+	}, nil
+}
+
+// WriteFacts writes the facts of the trace into the given directory, one file
+// per relation, creating the directory if needed.
+func (l *VandalLogger) WriteFacts(dir string) error {
+	facts, err := l.Facts()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range vandalFactsRelations {
+		if err := os.WriteFile(filepath.Join(dir, name), facts[name], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeVandalFacts returns the facts of the trace as a JSON object of the
+// contents of every relation, by file name.
+func (l *VandalLogger) encodeVandalFacts() (json.RawMessage, error) {
+	facts, err := l.Facts()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string, len(facts))
+	for name, content := range facts {
+		files[name] = string(content)
+	}
+	return json.Marshal(files)
+}
+
+// vandalFactField replaces the tabs and line breaks of free text, e.g. a revert
+// reason, which would otherwise break the rows of a facts file.
+func vandalFactField(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// appendVandalFact appends a tab separated row of fields to buf.
+func appendVandalFact(buf []byte, fields ...string) []byte {
+	for i, field := range fields {
+		if i > 0 {
+			buf = append(buf, '\t')
+		}
+		buf = append(buf, field...)
+	}
+	return append(buf, '\n')
+}
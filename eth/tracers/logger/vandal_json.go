@@ -0,0 +1,363 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// vandalOpSizeHint is the approximate size of an encoded opcode, used to size
+// the output buffer of a trace up front.
+const vandalOpSizeHint = 144
+
+// appendVandalBlocks appends the JSON encoding of the given basic blocks of
+// the steps to buf. The output is identical to json.Marshal of the blocks with
+// their opcodes as structs, but avoids reflection and the intermediate buffers
+// of encoding/json, which dominate the cost of large traces.
+func appendVandalBlocks(buf []byte, steps *vandalSteps, blocks []vandalBasicBlock, cfg *VandalConfig) []byte {
+	buf = append(buf, '[')
+	for i := range blocks {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendVandalBlock(buf, steps, &blocks[i], cfg)
+	}
+	return append(buf, ']')
+}
+
+// appendVandalBlock appends the JSON encoding of a basic block to buf. The
+// bounds of the block are offset by the steps already dropped from a stream.
+func appendVandalBlock(buf []byte, steps *vandalSteps, bb *vandalBasicBlock, cfg *VandalConfig) []byte {
+	buf = append(buf, `{"Entry":`...)
+	buf = strconv.AppendUint(buf, bb.Entry+uint64(steps.dropped), 10)
+	buf = append(buf, `,"Exit":`...)
+	buf = strconv.AppendUint(buf, bb.Exit+uint64(steps.dropped), 10)
+	buf = append(buf, `,"Ops":[`...)
+	for i := int(bb.Entry); i <= int(bb.Exit); i++ {
+		if i > int(bb.Entry) {
+			buf = append(buf, ',')
+		}
+		buf = appendVandalOp(buf, steps, i)
+	}
+	buf = append(buf, `],"Address":`...)
+	buf = appendVandalAddress(buf, bb.Address)
+	buf = append(buf, `,"Value":`...)
+	if value := steps.valueIn(int(bb.Entry), int(bb.Exit)); value == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = value.Append(buf, 10)
+	}
+	if cfg.EnableStorage {
+		buf = append(buf, `,"Storage":`...)
+		buf = appendVandalStorage(buf, steps, steps.storageIn(int(bb.Entry), int(bb.Exit)))
+	}
+	// Blocks of failed frames are marked, the last one along with the fault
+	if fault := steps.faultAt(int(bb.Entry)); fault != nil {
+		buf = append(buf, `,"Failed":true`...)
+		if fault.step == int(bb.Exit)+steps.dropped {
+			buf = append(buf, `,"Fault":`...)
+			buf = appendVandalFault(buf, fault)
+		}
+	}
+	return append(buf, '}')
+}
+
+// appendVandalFault appends the JSON encoding of the failure of a frame to buf.
+// The faulting opcode is omitted if unknown, and the reason if none decoded.
+func appendVandalFault(buf []byte, fault *vandalFault) []byte {
+	buf = append(buf, `{"Error":`...)
+	buf = appendVandalString(buf, fault.err)
+	if fault.reason != "" {
+		buf = append(buf, `,"Reason":`...)
+		buf = appendVandalString(buf, fault.reason)
+	}
+	if fault.known {
+		buf = append(buf, `,"Pc":`...)
+		buf = strconv.AppendUint(buf, fault.pc, 10)
+		buf = append(buf, `,"Op":`...)
+		buf = strconv.AppendUint(buf, uint64(fault.op), 10)
+		buf = append(buf, `,"Gas":`...)
+		buf = strconv.AppendUint(buf, fault.gas, 10)
+	}
+	return append(buf, '}')
+}
+
+// appendVandalString appends the JSON encoding of a string to buf.
+func appendVandalString(buf []byte, s string) []byte {
+	enc, _ := json.Marshal(s) // Strings always encode
+	return append(buf, enc...)
+}
+
+// appendVandalStorage appends the JSON encoding of storage accesses to buf.
+func appendVandalStorage(buf []byte, steps *vandalSteps, accesses []vandalStorageAccess) []byte {
+	buf = append(buf, '[')
+	for i := range accesses {
+		access := &accesses[i]
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, `{"Step":`...)
+		buf = strconv.AppendInt(buf, int64(access.step+steps.dropped), 10)
+		buf = append(buf, `,"Op":`...)
+		buf = strconv.AppendUint(buf, uint64(steps.op[access.step]), 10)
+		buf = append(buf, `,"Address":`...)
+		buf = appendVandalAddress(buf, steps.ownerAt(access.step))
+		buf = append(buf, `,"Slot":"0x`...)
+		buf = appendEncoded(buf, access.slot[:], hex.EncodedLen(len(access.slot)), func(dst, src []byte) { hex.Encode(dst, src) })
+		buf = append(buf, `","Value":"0x`...)
+		buf = appendEncoded(buf, access.value[:], hex.EncodedLen(len(access.value)), func(dst, src []byte) { hex.Encode(dst, src) })
+		buf = append(buf, `"}`...)
+	}
+	return append(buf, ']')
+}
+
+// appendVandalAddress appends the JSON encoding of an address to buf.
+func appendVandalAddress(buf []byte, addr common.Address) []byte {
+	buf = append(buf, `"0x`...)
+	buf = appendEncoded(buf, addr[:], hex.EncodedLen(len(addr)), func(dst, src []byte) { hex.Encode(dst, src) })
+	return append(buf, '"')
+}
+
+// appendVandalOp appends the JSON encoding of the i-th traced step to buf.
+func appendVandalOp(buf []byte, steps *vandalSteps, i int) []byte {
+	buf = append(buf, `{"Pc":`...)
+	buf = strconv.AppendUint(buf, steps.pc[i], 10)
+	buf = append(buf, `,"Op":`...)
+	buf = strconv.AppendUint(buf, uint64(steps.op[i]), 10)
+	buf = append(buf, `,"Gas":`...)
+	buf = strconv.AppendUint(buf, steps.gas[i], 10)
+	buf = append(buf, `,"Cost":`...)
+	buf = strconv.AppendUint(buf, steps.cost[i], 10)
+	buf = append(buf, `,"Depth":`...)
+	buf = strconv.AppendInt(buf, int64(steps.depth[i]), 10)
+	buf = append(buf, `,"CallIndex":`...)
+	buf = strconv.AppendInt(buf, int64(steps.call[i]), 10)
+	buf = append(buf, `,"Address":`...)
+	buf = appendVandalAddress(buf, steps.codeAt(i))
+	buf = append(buf, `,"Ret":`...)
+	if ret := steps.retAt(i); ret == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = append(buf, '"')
+		buf = appendEncoded(buf, ret, base64.StdEncoding.EncodedLen(len(ret)), base64.StdEncoding.Encode)
+		buf = append(buf, '"')
+	}
+	buf = append(buf, `,"Value":`...)
+	if value := steps.valueAt(i); value == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = value.Append(buf, 10)
+	}
+	if operands, ok := steps.operandsAt(i); ok {
+		buf = append(buf, `,"Operands":[`...)
+		for j := range operands {
+			if j > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, '"')
+			buf = append(buf, operands[j].Hex()...)
+			buf = append(buf, '"')
+		}
+		buf = append(buf, ']')
+	}
+	if write := steps.memoryAt(i); write != nil {
+		buf = append(buf, `,"Memory":`...)
+		buf = appendVandalMemoryWrite(buf, write)
+	}
+	return append(buf, '}')
+}
+
+// appendVandalMemoryWrite appends the JSON encoding of a memory write to buf,
+// the bytes written being omitted unless captured.
+func appendVandalMemoryWrite(buf []byte, write *vandalMemoryWrite) []byte {
+	buf = append(buf, `{"Offset":`...)
+	buf = strconv.AppendUint(buf, write.offset, 10)
+	buf = append(buf, `,"Size":`...)
+	buf = strconv.AppendUint(buf, write.size, 10)
+	buf = append(buf, `,"Hash":"0x`...)
+	buf = appendEncoded(buf, write.hash[:], hex.EncodedLen(len(write.hash)), func(dst, src []byte) { hex.Encode(dst, src) })
+	buf = append(buf, '"')
+	if write.data != nil {
+		buf = append(buf, `,"Data":"0x`...)
+		buf = appendEncoded(buf, write.data, hex.EncodedLen(len(write.data)), func(dst, src []byte) { hex.Encode(dst, src) })
+		buf = append(buf, '"')
+	}
+	return append(buf, '}')
+}
+
+// appendVandalEdges appends the JSON encoding of the jumps taken by the steps
+// to buf, in execution order.
+func appendVandalEdges(buf []byte, steps *vandalSteps) []byte {
+	buf = append(buf, '[')
+	first := true
+	for i := range steps.pc {
+		to, ok := steps.jumpAt(i)
+		if !ok {
+			continue
+		}
+		if !first {
+			buf = append(buf, ',')
+		}
+		first = false
+
+		buf = append(buf, `{"Step":`...)
+		buf = strconv.AppendInt(buf, int64(i+steps.dropped), 10)
+		buf = append(buf, `,"Address":`...)
+		buf = appendVandalAddress(buf, steps.codeAt(i))
+		buf = append(buf, `,"From":`...)
+		buf = strconv.AppendUint(buf, steps.pc[i], 10)
+		buf = append(buf, `,"To":`...)
+		buf = strconv.AppendUint(buf, to, 10)
+		buf = append(buf, '}')
+	}
+	return append(buf, ']')
+}
+
+// appendVandalContracts appends the JSON encoding of the code executed during
+// a trace to buf.
+func appendVandalContracts(buf []byte, contracts []vandalContract) []byte {
+	buf = append(buf, '[')
+	for i := range contracts {
+		contract := &contracts[i]
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, `{"Address":`...)
+		buf = appendVandalAddress(buf, contract.address)
+		buf = append(buf, `,"CodeHash":"0x`...)
+		buf = appendEncoded(buf, contract.hash[:], hex.EncodedLen(len(contract.hash)), func(dst, src []byte) { hex.Encode(dst, src) })
+		buf = append(buf, `","Code":"0x`...)
+		buf = appendEncoded(buf, contract.code, hex.EncodedLen(len(contract.code)), func(dst, src []byte) { hex.Encode(dst, src) })
+		buf = append(buf, `","Init":`...)
+		buf = strconv.AppendBool(buf, contract.init)
+		buf = append(buf, '}')
+	}
+	return append(buf, ']')
+}
+
+// appendEncoded appends the encoding of src of the given length to buf.
+func appendEncoded(buf []byte, src []byte, n int, encode func(dst, src []byte)) []byte {
+	if cap(buf)-len(buf) < n {
+		grown := make([]byte, len(buf), 2*cap(buf)+n)
+		copy(grown, buf)
+		buf = grown
+	}
+	encode(buf[len(buf):len(buf)+n], src)
+	return buf[:len(buf)+n]
+}
+
+// VandalEncoder encodes the traces of finished Vandal loggers on a background
+// goroutine, overlapping the encoding of a transaction's trace with the
+// execution of the following ones. A nil encoder encodes synchronously.
+//
+// Encoded loggers are reset and handed back through Recycle, so that tracing
+// a sequence of transactions reuses the same buffers.
+type VandalEncoder struct {
+	tasks chan *VandalEncoding
+	free  chan *VandalLogger // Encoded loggers available for reuse
+	done  chan struct{}
+}
+
+// VandalEncoding is the pending encoding of a Vandal logger's trace.
+type VandalEncoding struct {
+	logger *VandalLogger
+	free   chan *VandalLogger // Where to recycle the logger once encoded, if anywhere
+	done   chan struct{}
+	result json.RawMessage
+	err    error
+}
+
+// NewVandalEncoder starts a background encoder, accepting up to queue traces
+// waiting to be encoded before blocking new ones.
+func NewVandalEncoder(queue int) *VandalEncoder {
+	e := &VandalEncoder{
+		tasks: make(chan *VandalEncoding, queue),
+		free:  make(chan *VandalLogger, 1),
+		done:  make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+// loop encodes the queued traces until the encoder is closed.
+func (e *VandalEncoder) loop() {
+	defer close(e.done)
+	for task := range e.tasks {
+		task.encode()
+	}
+}
+
+// Encode queues the trace of the given logger for encoding. The logger must
+// not be used anymore afterwards, unless handed back by Recycle.
+func (e *VandalEncoder) Encode(l *VandalLogger) *VandalEncoding {
+	task := &VandalEncoding{logger: l, done: make(chan struct{})}
+	if e == nil {
+		task.encode()
+	} else {
+		task.free = e.free
+		e.tasks <- task
+	}
+	return task
+}
+
+// Recycle returns an encoded logger whose buffers may be reused for tracing
+// the next transaction, or nil if none is available yet. l must be the logger
+// last passed to Encode: a nil encoder has already encoded it and resets it for
+// reuse, a background one returns any logger it finished encoding instead.
+func (e *VandalEncoder) Recycle(l *VandalLogger) *VandalLogger {
+	if e == nil {
+		l.Reset()
+		return l
+	}
+	select {
+	case l := <-e.free:
+		return l
+	default:
+		return nil
+	}
+}
+
+// Close waits for the queued traces to be encoded and stops the encoder.
+func (e *VandalEncoder) Close() {
+	close(e.tasks)
+	<-e.done
+}
+
+// encode encodes the trace, releasing the logger.
+func (e *VandalEncoding) encode() {
+	e.result, e.err = e.logger.GetResult()
+	if e.free != nil {
+		e.logger.Reset()
+		select {
+		case e.free <- e.logger:
+		default:
+		}
+	}
+	e.logger = nil
+	close(e.done)
+}
+
+// Wait blocks until the trace is encoded, returning it.
+func (e *VandalEncoding) Wait() (json.RawMessage, error) {
+	<-e.done
+	return e.result, e.err
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// VandalFormatProtobuf encodes Vandal traces as the Trace message of the
+// protobuf schema in vandal.proto, see VandalLogger.Protobuf.
+const VandalFormatProtobuf = "protobuf"
+
+// vandalProtoOpSizeHint is the approximate size of a protobuf encoded opcode,
+// used to size the output buffer of a trace up front.
+const vandalProtoOpSizeHint = 16
+
+// Protobuf returns the trace encoded as the Trace message of vandal.proto. The
+// messages are written by hand rather than generated, as the steps are encoded
+// straight from the columns of the trace.
+func (l *VandalLogger) Protobuf() ([]byte, error) {
+	if err := l.failure(); err != nil {
+		return nil, err
+	}
+	var (
+		s      = &l.steps
+		blocks = l.blocks()
+		buf    = make([]byte, 0, s.len()*vandalProtoOpSizeHint)
+		msg    []byte // Block being encoded
+		sub    []byte // Message nested in the block being encoded
+	)
+	for i := range blocks {
+		msg = appendVandalProtoBlock(msg[:0], &sub, s, &blocks[i], &l.cfg)
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, msg)
+	}
+	if l.cfg.EnableEdges {
+		for i := range s.pc {
+			to, ok := s.jumpAt(i)
+			if !ok {
+				continue
+			}
+			code := s.codeAt(i)
+			msg = appendVandalProtoUint(msg[:0], 1, uint64(i+s.dropped))
+			msg = appendVandalProtoBytes(msg, 2, code[:])
+			msg = appendVandalProtoUint(msg, 3, s.pc[i])
+			msg = appendVandalProtoUint(msg, 4, to)
+			buf = appendVandalProtoBytes(buf, 2, msg)
+		}
+	}
+	if l.cfg.EnableContracts {
+		for i := range l.contracts {
+			c := &l.contracts[i]
+			msg = appendVandalProtoBytes(msg[:0], 1, c.address[:])
+			msg = appendVandalProtoBytes(msg, 2, c.hash[:])
+			msg = appendVandalProtoBytes(msg, 3, c.code)
+			if c.init {
+				msg = appendVandalProtoUint(msg, 4, 1)
+			}
+			buf = appendVandalProtoBytes(buf, 3, msg)
+		}
+	}
+	if l.Truncated() {
+		buf = appendVandalProtoUint(buf, 4, 1)
+		buf = appendVandalProtoUint(buf, 5, uint64(s.len()))
+	}
+	return buf, nil
+}
+
+// encodeVandalProtobuf returns the protobuf encoded trace as a JSON string, as
+// results are passed around as JSON, e.g. over the debug API. Base64 inflates
+// the encoding by a third, which remains a fraction of the JSON encoding.
+func (l *VandalLogger) encodeVandalProtobuf() (json.RawMessage, error) {
+	blob, err := l.Protobuf()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(blob)
+}
+
+// appendVandalProtoBlock appends the Block message of a basic block to buf,
+// using sub as the scratch space of its nested messages.
+func appendVandalProtoBlock(buf []byte, sub *[]byte, s *vandalSteps, bb *vandalBasicBlock, cfg *VandalConfig) []byte {
+	entry := int(bb.Entry)
+
+	buf = appendVandalProtoUint(buf, 1, bb.Entry+uint64(s.dropped))
+	buf = appendVandalProtoUint(buf, 2, bb.Exit+uint64(s.dropped))
+	for i := entry; i <= int(bb.Exit); i++ {
+		op := appendVandalProtoUint((*sub)[:0], 1, s.pc[i])
+		op = appendVandalProtoUint(op, 2, uint64(s.op[i]))
+		op = appendVandalProtoUint(op, 3, s.gas[i])
+		op = appendVandalProtoUint(op, 4, s.cost[i])
+		if ret := s.retAt(i); ret != nil {
+			op = protowire.AppendTag(op, 5, protowire.BytesType)
+			op = protowire.AppendBytes(op, ret)
+		}
+		if value := s.valueAt(i); value != nil {
+			op = appendVandalProtoBig(op, 6, value)
+		}
+		if operands, ok := s.operandsAt(i); ok {
+			for j := range operands {
+				word := operands[j].Bytes32()
+				op = protowire.AppendTag(op, 7, protowire.BytesType)
+				op = protowire.AppendBytes(op, trimVandalWord(word[:]))
+			}
+		}
+		if write := s.memoryAt(i); write != nil {
+			msg := appendVandalProtoUint(nil, 1, write.offset)
+			msg = appendVandalProtoUint(msg, 2, write.size)
+			msg = appendVandalProtoBytes(msg, 3, write.hash[:])
+			msg = appendVandalProtoBytes(msg, 4, write.data)
+			op = protowire.AppendTag(op, 8, protowire.BytesType)
+			op = protowire.AppendBytes(op, msg)
+		}
+		*sub = op
+		buf = protowire.AppendTag(buf, 3, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, op)
+	}
+	buf = appendVandalProtoBytes(buf, 4, bb.Address[:])
+	if value := s.valueIn(entry, int(bb.Exit)); value != nil {
+		buf = appendVandalProtoBig(buf, 5, value)
+	}
+	buf = appendVandalProtoUint(buf, 6, uint64(s.depth[entry]))
+	buf = appendVandalProtoUint(buf, 7, uint64(s.call[entry]))
+	if cfg.EnableStorage {
+		for _, access := range s.storageIn(entry, int(bb.Exit)) {
+			owner := s.ownerAt(access.step)
+			msg := appendVandalProtoUint((*sub)[:0], 1, uint64(access.step+s.dropped))
+			msg = appendVandalProtoUint(msg, 2, uint64(s.op[access.step]))
+			msg = appendVandalProtoBytes(msg, 3, owner[:])
+			msg = appendVandalProtoBytes(msg, 4, access.slot[:])
+			msg = appendVandalProtoBytes(msg, 5, access.value[:])
+			*sub = msg
+			buf = appendVandalProtoBytes(buf, 8, msg)
+		}
+	}
+	if fault := s.faultAt(entry); fault != nil {
+		buf = appendVandalProtoUint(buf, 9, 1)
+		if fault.step == int(bb.Exit)+s.dropped {
+			msg := appendVandalProtoBytes((*sub)[:0], 1, []byte(fault.err))
+			msg = appendVandalProtoBytes(msg, 2, []byte(fault.reason))
+			if fault.known {
+				msg = protowire.AppendTag(msg, 3, protowire.VarintType)
+				msg = protowire.AppendVarint(msg, fault.pc)
+				msg = protowire.AppendTag(msg, 4, protowire.VarintType)
+				msg = protowire.AppendVarint(msg, uint64(fault.op))
+				msg = protowire.AppendTag(msg, 5, protowire.VarintType)
+				msg = protowire.AppendVarint(msg, fault.gas)
+			}
+			*sub = msg
+			buf = protowire.AppendTag(buf, 10, protowire.BytesType)
+			buf = protowire.AppendBytes(buf, msg)
+		}
+	}
+	return buf
+}
+
+// appendVandalProtoUint appends a varint field to buf, unless zero, which is
+// the default of proto3 scalars.
+func appendVandalProtoUint(buf []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.VarintType)
+	return protowire.AppendVarint(buf, v)
+}
+
+// appendVandalProtoBytes appends a bytes field to buf, unless empty, which is
+// the default of proto3 scalars.
+func appendVandalProtoBytes(buf []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, v)
+}
+
+// appendVandalProtoBig appends an optional big-endian integer field to buf,
+// present even if zero.
+func appendVandalProtoBig(buf []byte, num protowire.Number, v *big.Int) []byte {
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, v.Bytes())
+}
+
+// trimVandalWord strips the leading zeroes of a big-endian word.
+func trimVandalWord(word []byte) []byte {
+	for len(word) > 0 && word[0] == 0 {
+		word = word[1:]
+	}
+	return word
+}
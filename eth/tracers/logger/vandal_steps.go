@@ -0,0 +1,259 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+// vandalSteps holds the traced steps of a transaction in a columnar layout,
+// one slice per field. Splitting a trace into basic blocks only scans the pc
+// and op columns, and optional fields cost nothing when not captured, rather
+// than every step carrying them in a row.
+type vandalSteps struct {
+	pc   []uint64
+	op   []vm.OpCode
+	gas  []uint64
+	cost []uint64
+
+	depth  []int32          // Call depth of the frame executing every step
+	call   []int32          // Call index of the frame executing every step
+	codes  []common.Address // Code address of every call frame, by call index
+	owners []common.Address // Storage address of every call frame, by call index
+	faults []*vandalFault   // Failure of every call frame, by call index, nil if none
+	ret    [][]byte         // Step outputs, empty unless return data is captured
+	value  []*big.Int       // Transferred values, empty unless any is recorded
+
+	storage []vandalStorageAccess // Storage accesses of the steps, empty unless captured
+	memory  []vandalMemoryWrite   // Memory written by the steps, empty unless captured
+
+	operands   []uint256.Int // Consumed stack items of all steps, topmost first
+	operandEnd []uint32      // End of every step's operands, empty unless captured
+
+	dropped int // Number of steps dropped before the first one held, once streamed
+}
+
+// vandalStorageAccess is a storage slot read by SLOAD or written by SSTORE.
+type vandalStorageAccess struct {
+	step  int         // Index of the accessing step
+	slot  common.Hash // Slot accessed
+	value common.Hash // Value read or written
+}
+
+// vandalMemoryWrite is a range of memory written by MSTORE, MSTORE8 or one of
+// the copying opcodes.
+type vandalMemoryWrite struct {
+	step   int         // Index of the writing step
+	offset uint64      // Offset of the range written
+	size   uint64      // Size of the range written, never zero
+	hash   common.Hash // Keccak-256 hash of the bytes written
+	data   []byte      // Bytes written, nil unless captured
+}
+
+// vandalFault is the failure of a call frame, along with the opcode it faulted
+// on if known. Frames failing without a faulting opcode, e.g. creations unable
+// to pay for their code, only hold the error.
+type vandalFault struct {
+	step   int    // Index of the last step traced in the frame, -1 if none
+	err    string // Error the frame failed with
+	reason string // Decoded revert reason, if any
+
+	known bool      // Whether the faulting opcode is known
+	pc    uint64    // Program counter of the faulting opcode
+	op    vm.OpCode // Faulting opcode
+	gas   uint64    // Gas remaining before the faulting opcode
+}
+
+// len returns the number of traced steps.
+func (s *vandalSteps) len() int {
+	return len(s.pc)
+}
+
+// presize allocates the columns for n steps, including the return data and
+// operand columns if captured.
+func (s *vandalSteps) presize(n int, ret bool, operands bool) {
+	s.pc = make([]uint64, 0, n)
+	s.op = make([]vm.OpCode, 0, n)
+	s.gas = make([]uint64, 0, n)
+	s.cost = make([]uint64, 0, n)
+	s.depth = make([]int32, 0, n)
+	s.call = make([]int32, 0, n)
+	if ret {
+		s.ret = make([][]byte, 0, n)
+	}
+	if operands {
+		s.operandEnd = make([]uint32, 0, n)
+	}
+}
+
+// appendOperands records the operands of the last step, given topmost last.
+func (s *vandalSteps) appendOperands(operands []uint256.Int) {
+	for i := len(operands) - 1; i >= 0; i-- {
+		s.operands = append(s.operands, operands[i])
+	}
+	s.operandEnd = append(s.operandEnd, uint32(len(s.operands)))
+}
+
+// operandsAt returns the operands of the i-th step, topmost first, and whether
+// they were captured.
+func (s *vandalSteps) operandsAt(i int) ([]uint256.Int, bool) {
+	if i >= len(s.operandEnd) {
+		return nil, false
+	}
+	var start uint32
+	if i > 0 {
+		start = s.operandEnd[i-1]
+	}
+	return s.operands[start:s.operandEnd[i]], true
+}
+
+// codeAt returns the address of the code executed by the i-th step, the zero
+// address if traced outside of any call frame.
+func (s *vandalSteps) codeAt(i int) common.Address {
+	if call := int(s.call[i]); call < len(s.codes) {
+		return s.codes[call]
+	}
+	return common.Address{}
+}
+
+// jumpAt returns the destination of the i-th step if it is a jump taken, which
+// is the step executed next in the same frame.
+func (s *vandalSteps) jumpAt(i int) (uint64, bool) {
+	if i+1 >= len(s.pc) || s.call[i+1] != s.call[i] {
+		return 0, false
+	}
+	switch s.op[i] {
+	case vm.JUMP:
+		return s.pc[i+1], true
+	case vm.JUMPI:
+		return s.pc[i+1], s.pc[i+1] != s.pc[i]+1
+	}
+	return 0, false
+}
+
+// ownerAt returns the address of the storage of the frame executing the i-th
+// step, the zero address if traced outside of any call frame.
+func (s *vandalSteps) ownerAt(i int) common.Address {
+	if call := int(s.call[i]); call < len(s.owners) {
+		return s.owners[call]
+	}
+	return common.Address{}
+}
+
+// faultAt returns the failure of the frame executing the i-th step, nil if the
+// frame succeeded.
+func (s *vandalSteps) faultAt(i int) *vandalFault {
+	if call := int(s.call[i]); call < len(s.faults) {
+		return s.faults[call]
+	}
+	return nil
+}
+
+// storageIn returns the storage accesses of the steps from first to last, both
+// included.
+func (s *vandalSteps) storageIn(first, last int) []vandalStorageAccess {
+	start := sort.Search(len(s.storage), func(i int) bool { return s.storage[i].step >= first })
+	end := sort.Search(len(s.storage), func(i int) bool { return s.storage[i].step > last })
+	return s.storage[start:end]
+}
+
+// memoryAt returns the memory written by the i-th step, nil if none recorded.
+func (s *vandalSteps) memoryAt(i int) *vandalMemoryWrite {
+	if len(s.memory) == 0 {
+		return nil
+	}
+	j := sort.Search(len(s.memory), func(j int) bool { return s.memory[j].step >= i })
+	if j < len(s.memory) && s.memory[j].step == i {
+		return &s.memory[j]
+	}
+	return nil
+}
+
+// retAt returns the return data of the i-th step, nil if not captured.
+func (s *vandalSteps) retAt(i int) []byte {
+	if i < len(s.ret) {
+		return s.ret[i]
+	}
+	return nil
+}
+
+// valueAt returns the value of the i-th step, nil if not recorded.
+func (s *vandalSteps) valueAt(i int) *big.Int {
+	if i < len(s.value) {
+		return s.value[i]
+	}
+	return nil
+}
+
+// valueIn returns the total value transferred by the steps from first to last,
+// both included, nil if none transferred any.
+func (s *vandalSteps) valueIn(first, last int) *big.Int {
+	var total *big.Int
+	for i := first; i <= last && i < len(s.value); i++ {
+		if s.value[i] == nil {
+			continue
+		}
+		if total == nil {
+			total = new(big.Int)
+		}
+		total.Add(total, s.value[i])
+	}
+	return total
+}
+
+// setValue records the value of the i-th step.
+func (s *vandalSteps) setValue(i int, value *big.Int) {
+	for len(s.value) <= i {
+		s.value = append(s.value, nil)
+	}
+	s.value[i] = value
+}
+
+// reset clears the steps, retaining the columns unless they use more than
+// limit bytes.
+func (s *vandalSteps) reset(limit uint64) {
+	size := uint64(cap(s.pc))*vandalStepSize + uint64(cap(s.ret))*vandalRetSize
+	size += uint64(cap(s.operands))*32 + uint64(cap(s.operandEnd))*vandalOperandsSize
+	size += uint64(cap(s.storage))*vandalStorageSize + uint64(cap(s.memory))*vandalMemoryWriteSize
+	if size > limit {
+		*s = vandalSteps{}
+		return
+	}
+	s.drop()
+	clear(s.faults)
+	s.codes, s.owners, s.faults, s.dropped = s.codes[:0], s.owners[:0], s.faults[:0], 0
+}
+
+// drop clears the steps held, keeping the columns and the addresses and faults
+// of the call frames, and counting the steps as dropped.
+func (s *vandalSteps) drop() {
+	clear(s.ret) // Drop the references to the return data and values
+	clear(s.value)
+	clear(s.memory)
+
+	s.dropped += len(s.pc)
+	s.pc, s.op, s.gas, s.cost = s.pc[:0], s.op[:0], s.gas[:0], s.cost[:0]
+	s.depth, s.call = s.depth[:0], s.call[:0]
+	s.ret, s.value = s.ret[:0], s.value[:0]
+	s.operands, s.operandEnd = s.operands[:0], s.operandEnd[:0]
+	s.storage, s.memory = s.storage[:0], s.memory[:0]
+}
@@ -0,0 +1,1408 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// newVandalTestLogger returns a Vandal logger with a synthetic trace of two
+// calls, covering block splits, return data and values.
+func newVandalTestLogger() *VandalLogger {
+	return traceVandalTestSteps(NewVandalTracer(&VandalConfig{EnableReturnData: true}))
+}
+
+// traceVandalTestSteps feeds the synthetic trace of newVandalTestLogger to l.
+func traceVandalTestSteps(l *VandalLogger) *VandalLogger {
+	l.CaptureStart(nil, common.Address{}, common.Address{}, false, nil, 0, nil)
+
+	// Calls are traced once executed, following the steps of their frame
+	steps := []struct {
+		pc    uint64
+		op    vm.OpCode
+		ret   []byte
+		enter bool // Whether the step is the first of a new frame
+		exit  bool // Whether the step is the last of its frame
+	}{
+		{0, vm.PUSH1, []byte{0x80}, false, false},
+		{2, vm.PUSH1, []byte{0x40}, false, false},
+		{4, vm.MSTORE, nil, false, false},
+		{5, vm.CALLVALUE, []byte{}, false, false},
+		{6, vm.DUP1, nil, false, false},
+		{7, vm.ISZERO, []byte{0x01}, false, false},
+		{8, vm.GAS, []byte{0xff, 0xff}, false, false},
+		{0, vm.PUSH1, []byte{0x00}, true, false},
+		{2, vm.CALLER, []byte{0x12, 0x34}, false, false},
+		{3, vm.STOP, nil, false, true},
+		{9, vm.CALL, nil, false, false},
+		{10, vm.ADDRESS, nil, false, false},
+		{11, vm.RETURN, nil, false, false},
+	}
+	for i, step := range steps {
+		if step.enter {
+			l.CaptureEnter(vm.CALL, common.Address{}, common.Address{1}, nil, 0, nil)
+		}
+		l.CaptureState(step.pc, step.op, uint64(1000-i), 3, step.ret)
+		if step.exit {
+			l.CaptureExit(nil, 0, nil)
+		}
+	}
+	l.steps.value = make([]*big.Int, l.Steps())
+	l.steps.value[3] = big.NewInt(1)
+	l.steps.value[9] = new(big.Int).Lsh(big.NewInt(1), 100)
+	return l
+}
+
+// vandalRow is a traced step in the row layout the Vandal logger originally
+// stored, whose encoding/json output the logger's encoding must match.
+type vandalRow struct {
+	Pc        uint64
+	Op        vm.OpCode
+	Gas       uint64
+	Cost      uint64
+	Depth     int
+	CallIndex int
+	Address   common.Address
+	Ret       []byte
+	Value     *big.Int
+}
+
+// vandalRowBlock is a basic block of vandalRows.
+type vandalRowBlock struct {
+	Entry   uint64
+	Exit    uint64
+	Ops     []vandalRow
+	Address common.Address
+	Value   *big.Int // Total value transferred by the ops, nil if none
+}
+
+// vandalTestRows returns the steps traced by l as rows.
+func vandalTestRows(l *VandalLogger) []vandalRow {
+	var rows []vandalRow
+	for i := 0; i < l.Steps(); i++ {
+		rows = append(rows, vandalRow{
+			Pc:        l.steps.pc[i],
+			Op:        l.steps.op[i],
+			Gas:       l.steps.gas[i],
+			Cost:      l.steps.cost[i],
+			Depth:     int(l.steps.depth[i]),
+			CallIndex: int(l.steps.call[i]),
+			Address:   l.steps.codeAt(i),
+			Ret:       l.steps.retAt(i),
+			Value:     l.steps.valueAt(i),
+		})
+	}
+	return rows
+}
+
+// referenceVandalBlocks splits the given steps into basic blocks with a row
+// based implementation of the Vandal rules.
+func referenceVandalBlocks(logs []vandalRow) []vandalRowBlock {
+	blocks := make([]vandalRowBlock, 0)
+	for i := range logs {
+		log := &logs[i]
+		if i > 0 {
+			prev := &logs[i-1]
+			if log.CallIndex == prev.CallIndex {
+				kind := GetKind(log.Op)
+				if (kind != OpKindOne && kind != OpKindFive) || (log.Pc-prev.Pc == uint64(pcGap(prev.Op)) && !possiblyHalts(prev.Op)) {
+					last := &blocks[len(blocks)-1]
+					last.Exit, last.Ops = uint64(i), logs[last.Entry:i+1]
+					continue
+				}
+			}
+		}
+		blocks = append(blocks, vandalRowBlock{Entry: uint64(i), Exit: uint64(i), Ops: logs[i : i+1], Address: log.Address})
+	}
+	for i := range blocks {
+		for _, op := range blocks[i].Ops {
+			if op.Value != nil {
+				if blocks[i].Value == nil {
+					blocks[i].Value = new(big.Int)
+				}
+				blocks[i].Value.Add(blocks[i].Value, op.Value)
+			}
+		}
+	}
+	return blocks
+}
+
+func TestVandalEncoding(t *testing.T) {
+	t.Parallel()
+
+	for _, l := range []*VandalLogger{newVandalTestLogger(), NewVandalTracer(nil)} {
+		want, err := json.Marshal(referenceVandalBlocks(vandalTestRows(l)))
+		if err != nil {
+			t.Fatalf("failed to marshal blocks: %v", err)
+		}
+		have, err := l.GetResult()
+		if err != nil {
+			t.Fatalf("failed to retrieve result: %v", err)
+		}
+		if string(have) != string(want) {
+			t.Fatalf("encoding mismatch:\nhave %s\nwant %s", have, want)
+		}
+	}
+}
+
+// traceVandalRandomSteps feeds a random trace of nested calls, jumps and
+// returned data to l.
+func traceVandalRandomSteps(l *VandalLogger, rng *rand.Rand) {
+	ops := []vm.OpCode{vm.PUSH1, vm.PUSH4, vm.ADD, vm.JUMPDEST, vm.GAS, vm.ADDRESS, vm.CREATE, vm.CALL, vm.STOP, vm.RETURN}
+
+	l.CaptureStart(nil, common.Address{}, common.Address{}, false, nil, 0, nil)
+
+	var (
+		pc     uint64   // Traces start at the entry of the transaction's call
+		frames []uint64 // Program counters to return to
+	)
+	for i := rng.Intn(200); i > 0; i-- {
+		op := ops[rng.Intn(len(ops))]
+		if l.Steps() > 0 {
+			switch rng.Intn(8) {
+			case 0:
+				l.CaptureEnter(vm.CALL, common.Address{}, common.Address{byte(rng.Intn(4))}, nil, 0, big.NewInt(int64(rng.Intn(2))))
+				frames, pc = append(frames, pc), 0
+			case 1:
+				if len(frames) > 0 {
+					l.CaptureExit(nil, 0, nil)
+					frames, pc = frames[:len(frames)-1], frames[len(frames)-1]
+				}
+			case 2:
+				pc = uint64(rng.Intn(64)) // Jump
+			}
+		}
+		var ret []byte
+		if rng.Intn(2) == 0 {
+			ret = []byte{byte(i)}
+		}
+		l.CaptureState(pc, op, uint64(rng.Intn(1000)), uint64(rng.Intn(10)), ret)
+		pc += uint64(pcGap(op))
+	}
+	l.CaptureEnd(nil, 0, nil)
+}
+
+// Tests that random traces are split into the same basic blocks as by the
+// row based implementation.
+func TestVandalBlocksReference(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 100; n++ {
+		l := NewVandalTracer(&VandalConfig{EnableReturnData: true})
+		traceVandalRandomSteps(l, rng)
+
+		want, _ := json.Marshal(referenceVandalBlocks(vandalTestRows(l)))
+		have, err := l.GetResult()
+		if err != nil {
+			t.Fatalf("trace %d: failed to retrieve result: %v", n, err)
+		}
+		if string(have) != string(want) {
+			t.Fatalf("trace %d: result mismatch:\nhave %s\nwant %s", n, have, want)
+		}
+	}
+}
+
+// Tests that streamed blocks are those of the trace held in memory, and that
+// only the steps of the current block are held.
+func TestVandalStream(t *testing.T) {
+	t.Parallel()
+
+	for n := int64(0); n < 100; n++ {
+		var (
+			out    = new(bytes.Buffer)
+			stream = NewVandalStreamLogger(&VandalConfig{EnableReturnData: true}, out)
+			whole  = NewVandalTracer(&VandalConfig{EnableReturnData: true})
+		)
+		traceVandalRandomSteps(stream, rand.New(rand.NewSource(n)))
+		traceVandalRandomSteps(whole, rand.New(rand.NewSource(n)))
+
+		if held := stream.steps.len(); held != 0 {
+			t.Fatalf("trace %d: %d steps held after the trace ended", n, held)
+		}
+		res, err := stream.GetResult()
+		if err != nil {
+			t.Fatalf("trace %d: failed to finish stream: %v", n, err)
+		}
+		want, _ := whole.GetResult()
+		lines := bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n"))
+		if out.Len() == 0 {
+			lines = nil
+		}
+		if have := "[" + string(bytes.Join(lines, []byte(","))) + "]"; have != string(want) {
+			t.Fatalf("trace %d: streamed blocks mismatch:\nhave %s\nwant %s", n, have, want)
+		}
+		if summary := fmt.Sprintf(`{"blocks":%d,"steps":%d}`, len(lines), whole.Steps()); string(res) != summary {
+			t.Fatalf("trace %d: summary mismatch: have %s, want %s", n, res, summary)
+		}
+	}
+}
+
+// Tests that blocks and opcodes are annotated with the address of the code
+// executed, which differs from the storage address under DELEGATECALL.
+func TestVandalCodeAddress(t *testing.T) {
+	t.Parallel()
+
+	var (
+		proxy = common.Address{1}
+		impl  = common.Address{2}
+		lib   = common.Address{3}
+	)
+	l := NewVandalTracer(nil)
+	l.CaptureStart(nil, common.Address{}, proxy, false, nil, 0, nil)
+	l.CaptureState(0, vm.PUSH1, 100, 3, nil)
+	l.CaptureEnter(vm.DELEGATECALL, proxy, impl, nil, 50, nil)
+	l.CaptureState(0, vm.PUSH1, 50, 3, nil)
+	l.CaptureEnter(vm.CALLCODE, proxy, lib, nil, 20, nil)
+	l.CaptureState(0, vm.STOP, 20, 0, nil)
+	l.CaptureExit(nil, 0, nil)
+	l.CaptureState(2, vm.CALLCODE, 47, 20, nil)
+	l.CaptureState(3, vm.STOP, 27, 0, nil)
+	l.CaptureExit(nil, 23, nil)
+	l.CaptureState(2, vm.DELEGATECALL, 97, 50, nil)
+	l.CaptureEnd(nil, 0, nil)
+
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var blocks []struct {
+		Ops     []struct{ Address common.Address }
+		Address common.Address
+	}
+	if err := json.Unmarshal(res, &blocks); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	want := []common.Address{proxy, impl, lib, impl, proxy}
+	if len(blocks) != len(want) {
+		t.Fatalf("block count mismatch: have %d, want %d: %s", len(blocks), len(want), res)
+	}
+	for i, block := range blocks {
+		if block.Address != want[i] {
+			t.Errorf("block %d: address mismatch: have %x, want %x", i, block.Address, want[i])
+		}
+		for j, op := range block.Ops {
+			if op.Address != want[i] {
+				t.Errorf("block %d, op %d: address mismatch: have %x, want %x", i, j, op.Address, want[i])
+			}
+		}
+	}
+}
+
+func TestVandalFacts(t *testing.T) {
+	t.Parallel()
+
+	l := newVandalTestLogger()
+	facts, err := l.Facts()
+	if err != nil {
+		t.Fatalf("failed to retrieve facts: %v", err)
+	}
+	rows := func(name string) [][]string {
+		var rows [][]string
+		for _, line := range strings.Split(strings.TrimSuffix(string(facts[name]), "\n"), "\n") {
+			if line != "" {
+				rows = append(rows, strings.Split(line, "\t"))
+			}
+		}
+		return rows
+	}
+	blocks := l.blocks()
+	if have := rows("block.facts"); len(have) != len(blocks) || have[1][0] != "7" || have[1][2] != "0x0100000000000000000000000000000000000000" {
+		t.Errorf("unexpected blocks: %q", have)
+	}
+	if have := rows("edge.facts"); len(have) != len(blocks)-1 || !slices.Equal(have[0], []string{"0", "7"}) {
+		t.Errorf("unexpected edges: %q", have)
+	}
+	ops := rows("op.facts")
+	if len(ops) != l.Steps() || !slices.Equal(ops[8], []string{"8", "8", "2", "CALLER", "2", "1"}) {
+		t.Errorf("unexpected ops: %q", ops)
+	}
+	want := [][]string{{"3", "1"}, {"9", "1267650600228229401496703205376"}}
+	if have := rows("value.facts"); !slices.EqualFunc(have, want, slices.Equal[[]string]) {
+		t.Errorf("values mismatch: have %q, want %q", have, want)
+	}
+	if have := rows("ret.facts"); len(have) != 7 || !slices.Equal(have[0], []string{"0", "0x80"}) {
+		t.Errorf("unexpected outputs: %q", have)
+	}
+	if len(facts["operand.facts"]) != 0 || len(facts["truncated.facts"]) != 0 {
+		t.Errorf("unexpected facts not captured: %q", facts)
+	}
+	// The facts are returned as the result in the facts format
+	l.cfg.Format = VandalFormatFacts
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var files map[string]string
+	if err := json.Unmarshal(res, &files); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if len(files) != len(vandalFactsRelations) || files["op.facts"] != string(facts["op.facts"]) {
+		t.Errorf("unexpected result: %s", res)
+	}
+	// All relations are written, even if empty
+	dir := t.TempDir()
+	if err := l.WriteFacts(dir); err != nil {
+		t.Fatalf("failed to write facts: %v", err)
+	}
+	for _, name := range vandalFactsRelations {
+		if blob, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(blob) != files[name] {
+			t.Errorf("facts file %s mismatch: %v", name, err)
+		}
+	}
+}
+
+func TestVandalEdges(t *testing.T) {
+	t.Parallel()
+
+	l := NewVandalTracer(&VandalConfig{EnableEdges: true})
+	l.CaptureStart(nil, common.Address{}, common.Address{1}, false, nil, 0, nil)
+	l.CaptureState(0, vm.PUSH1, 100, 3, nil)
+	l.CaptureState(2, vm.JUMP, 97, 8, nil) // Taken to 10
+	l.CaptureState(10, vm.JUMPDEST, 89, 1, nil)
+	l.CaptureState(11, vm.JUMPI, 88, 10, nil) // Not taken
+	l.CaptureState(12, vm.JUMPI, 78, 10, nil) // Taken to 4
+	l.CaptureState(4, vm.JUMPDEST, 68, 1, nil)
+	l.CaptureEnter(vm.CALL, common.Address{1}, common.Address{2}, nil, 50, nil)
+	l.CaptureState(0, vm.JUMP, 50, 8, nil) // Fails at its destination
+	l.CaptureExit(nil, 50, errors.New("out of gas"))
+	l.CaptureState(5, vm.CALL, 67, 100, nil)
+	l.CaptureEnd(nil, 0, nil)
+
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var trace struct {
+		Blocks []json.RawMessage
+		Edges  []struct {
+			Step     int
+			Address  common.Address
+			From, To uint64
+		}
+	}
+	if err := json.Unmarshal(res, &trace); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if len(trace.Blocks) == 0 || len(trace.Edges) != 2 {
+		t.Fatalf("unexpected result: %s", res)
+	}
+	if edge := trace.Edges[0]; edge.Step != 1 || edge.Address != (common.Address{1}) || edge.From != 2 || edge.To != 10 {
+		t.Errorf("unexpected jump edge: %+v", edge)
+	}
+	if edge := trace.Edges[1]; edge.Step != 4 || edge.From != 12 || edge.To != 4 {
+		t.Errorf("unexpected conditional jump edge: %+v", edge)
+	}
+	facts, _ := l.Facts()
+	if want := "1\t2\t10\n4\t12\t4\n"; string(facts["jump.facts"]) != want {
+		t.Errorf("jump facts mismatch: have %q, want %q", facts["jump.facts"], want)
+	}
+}
+
+// Tests that storage accesses are attached to their blocks, along with the
+// address of the storage, which is the caller's under DELEGATECALL.
+func TestVandalStorage(t *testing.T) {
+	t.Parallel()
+
+	var (
+		proxy = common.Address{1}
+		impl  = common.Address{2}
+		token = common.Address{3}
+		words = func(vals ...uint64) []uint256.Int {
+			stack := make([]uint256.Int, len(vals))
+			for i, val := range vals {
+				stack[i].SetUint64(val)
+			}
+			return stack
+		}
+	)
+	l := NewVandalTracer(&VandalConfig{EnableStorage: true})
+	l.CaptureStart(nil, common.Address{}, proxy, false, nil, 0, nil)
+	l.CaptureOperands(vm.SLOAD, words(1))
+	l.CaptureState(0, vm.SLOAD, 100, 2100, []byte{0x2a})
+
+	l.CaptureEnter(vm.DELEGATECALL, proxy, impl, nil, 50, nil)
+	l.CaptureOperands(vm.SSTORE, words(7, 2)) // Stores 7 at slot 2
+	l.CaptureState(0, vm.SSTORE, 50, 20000, nil)
+	l.CaptureEnter(vm.CALL, proxy, token, nil, 20, nil)
+	l.CaptureOperands(vm.SLOAD, words(3))
+	l.CaptureState(0, vm.SLOAD, 20, 2100, []byte{})
+	l.CaptureExit(nil, 0, nil)
+	l.CaptureState(1, vm.CALL, 30, 100, nil)
+	l.CaptureExit(nil, 0, nil)
+
+	l.CaptureOperands(vm.DELEGATECALL, words(0, 0, 0, 0, 2, 50))
+	l.CaptureState(1, vm.DELEGATECALL, 90, 100, nil)
+	l.CaptureEnd(nil, 0, nil)
+
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	type access struct {
+		Step    int
+		Op      vm.OpCode
+		Address common.Address
+		Slot    common.Hash
+		Value   common.Hash
+	}
+	var blocks []struct{ Storage []access }
+	if err := json.Unmarshal(res, &blocks); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	hash := func(v uint64) common.Hash { return common.BigToHash(new(big.Int).SetUint64(v)) }
+	want := [][]access{
+		{{0, vm.SLOAD, proxy, hash(1), hash(42)}},
+		{{1, vm.SSTORE, proxy, hash(2), hash(7)}},
+		{{2, vm.SLOAD, token, hash(3), hash(0)}},
+		{},
+		{},
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("block count mismatch: have %d, want %d: %s", len(blocks), len(want), res)
+	}
+	for i, block := range blocks {
+		if !slices.Equal(block.Storage, want[i]) {
+			t.Errorf("block %d: storage mismatch: have %+v, want %+v", i, block.Storage, want[i])
+		}
+	}
+	facts, _ := l.Facts()
+	if rows := strings.Count(string(facts["storage.facts"]), "\n"); rows != 3 {
+		t.Errorf("storage facts count mismatch: have %d, want %d", rows, 3)
+	}
+	// Storage is omitted unless recorded
+	l = NewVandalTracer(nil)
+	l.CaptureOperands(vm.SLOAD, words(1))
+	l.CaptureState(0, vm.SLOAD, 100, 2100, []byte{0x2a})
+	if res, _ := l.GetResult(); bytes.Contains(res, []byte("Storage")) {
+		t.Errorf("storage encoded while disabled: %s", res)
+	}
+}
+
+// Tests that the memory written by opcodes is recorded as the range written and
+// its hash, along with the bytes written if enabled, but only for writes that
+// succeeded and wrote any bytes.
+func TestVandalMemory(t *testing.T) {
+	t.Parallel()
+
+	var (
+		memory = make([]byte, 128)
+		words  = func(vals ...uint64) []uint256.Int {
+			stack := make([]uint256.Int, len(vals))
+			for i, val := range vals {
+				stack[i].SetUint64(val)
+			}
+			return stack
+		}
+	)
+	for i := range memory {
+		memory[i] = byte(i)
+	}
+	type write struct {
+		Offset uint64
+		Size   uint64
+		Hash   common.Hash
+		Data   hexutil.Bytes
+	}
+	for _, data := range []bool{false, true} {
+		l := NewVandalTracer(&VandalConfig{EnableMemory: true, EnableMemoryData: data})
+		l.CaptureStart(nil, common.Address{}, common.Address{1}, false, nil, 0, nil)
+		l.CaptureOperands(vm.MSTORE, words(0xff, 64)) // Stores 0xff at 64
+		l.CaptureMemory(vm.MSTORE, memory)
+		l.CaptureState(0, vm.MSTORE, 100, 6, nil)
+		l.CaptureOperands(vm.CALLDATACOPY, words(0, 0, 32)) // Copies nothing
+		l.CaptureMemory(vm.CALLDATACOPY, memory)
+		l.CaptureState(1, vm.CALLDATACOPY, 90, 3, nil)
+		l.CaptureOperands(vm.EXTCODECOPY, words(16, 0, 8, 0xaa)) // Copies 16 bytes to 8
+		l.CaptureMemory(vm.EXTCODECOPY, memory)
+		l.CaptureState(2, vm.EXTCODECOPY, 80, 100, nil)
+		l.CaptureOperands(vm.RETURNDATACOPY, words(64, 0, 0)) // Fails out of bounds
+		l.CaptureState(3, vm.RETURNDATACOPY, 70, 3, nil)
+		l.CaptureEnd(nil, 0, nil)
+
+		res, err := l.GetResult()
+		if err != nil {
+			t.Fatalf("failed to retrieve result: %v", err)
+		}
+		var blocks []struct{ Ops []struct{ Memory *write } }
+		if err := json.Unmarshal(res, &blocks); err != nil || len(blocks) != 1 {
+			t.Fatalf("failed to decode result: %v: %s", err, res)
+		}
+		want := []*write{
+			{Offset: 64, Size: 32, Hash: crypto.Keccak256Hash(memory[64:96])},
+			nil,
+			{Offset: 8, Size: 16, Hash: crypto.Keccak256Hash(memory[8:24])},
+			nil,
+		}
+		if data {
+			want[0].Data, want[2].Data = memory[64:96], memory[8:24]
+		}
+		for i, op := range blocks[0].Ops {
+			if (op.Memory == nil) != (want[i] == nil) || (op.Memory != nil && !reflect.DeepEqual(*op.Memory, *want[i])) {
+				t.Errorf("data %v, op %d: memory mismatch: have %+v, want %+v", data, i, op.Memory, want[i])
+			}
+		}
+		facts, _ := l.Facts()
+		if rows := strings.Count(string(facts["memory.facts"]), "\n"); rows != 2 {
+			t.Errorf("data %v: memory facts count mismatch: have %d, want %d", data, rows, 2)
+		}
+	}
+	// Memory is omitted unless recorded
+	l := NewVandalTracer(nil)
+	l.CaptureOperands(vm.MSTORE, words(0xff, 64))
+	l.CaptureMemory(vm.MSTORE, memory)
+	l.CaptureState(0, vm.MSTORE, 100, 6, nil)
+	if res, _ := l.GetResult(); bytes.Contains(res, []byte("Memory")) {
+		t.Errorf("memory encoded while disabled: %s", res)
+	}
+}
+
+// Tests that failed frames are marked in the blocks and facts, along with the
+// opcodes they faulted on and the reasons of reverts.
+func TestVandalFaults(t *testing.T) {
+	t.Parallel()
+
+	// Error("boom") as encoded by Solidity
+	output := common.FromHex("0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"626f6f6d00000000000000000000000000000000000000000000000000000000")
+
+	trace := func(l *VandalLogger) {
+		l.CaptureStart(nil, common.Address{}, common.Address{1}, false, nil, 0, nil)
+		l.CaptureState(0, vm.PUSH1, 100, 3, nil)
+
+		// The callee runs out of gas ahead of its second opcode
+		l.CaptureEnter(vm.CALL, common.Address{1}, common.Address{2}, nil, 10, nil)
+		l.CaptureState(0, vm.PUSH1, 10, 3, nil)
+		l.CaptureFault(2, vm.SSTORE, 7, 20000, nil, 2, vm.ErrOutOfGas)
+		l.CaptureExit(nil, 10, vm.ErrOutOfGas)
+		l.CaptureState(2, vm.CALL, 97, 10, nil)
+
+		l.CaptureState(3, vm.REVERT, 80, 0, output)
+		l.CaptureFault(3, vm.REVERT, 80, 0, nil, 1, vm.ErrExecutionReverted)
+		l.CaptureEnd(output, 20, vm.ErrExecutionReverted)
+	}
+	l := NewVandalTracer(nil)
+	trace(l)
+
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var blocks []struct {
+		Failed bool
+		Fault  json.RawMessage
+	}
+	if err := json.Unmarshal(res, &blocks); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	want := []struct {
+		failed bool
+		fault  string
+	}{
+		{true, ""},
+		{true, `{"Error":"out of gas","Pc":2,"Op":85,"Gas":7}`},
+		{true, `{"Error":"execution reverted","Reason":"boom","Pc":3,"Op":253,"Gas":80}`},
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("block count mismatch: have %d, want %d: %s", len(blocks), len(want), res)
+	}
+	for i, block := range blocks {
+		if block.Failed != want[i].failed || string(block.Fault) != want[i].fault {
+			t.Errorf("block %d: failure mismatch: have %v %s, want %v %s", i, block.Failed, block.Fault, want[i].failed, want[i].fault)
+		}
+	}
+	facts, _ := l.Facts()
+	if have, want := string(facts["fault.facts"]), "0\t3\texecution reverted\tboom\t3\tREVERT\t80\n1\t1\tout of gas\t\t2\tSSTORE\t7\n"; have != want {
+		t.Errorf("fault facts mismatch:\nhave: %q\nwant: %q", have, want)
+	}
+	// The last streamed block of a failed frame holds its fault
+	var out bytes.Buffer
+	l = NewVandalStreamLogger(nil, &out)
+	trace(l)
+	if _, err := l.GetResult(); err != nil {
+		t.Fatalf("failed to finish stream: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], want[1].fault) || !strings.Contains(lines[2], want[2].fault) {
+		t.Errorf("streamed faults mismatch: %s", out.String())
+	}
+}
+
+// vandalCodeStatedb is a state holding the code of contracts.
+type vandalCodeStatedb struct {
+	dummyStatedb
+	code map[common.Address][]byte
+}
+
+func (db *vandalCodeStatedb) GetCode(addr common.Address) []byte { return db.code[addr] }
+func (db *vandalCodeStatedb) GetCodeHash(addr common.Address) common.Hash {
+	return crypto.Keccak256Hash(db.code[addr])
+}
+
+// Tests that the code executed at every address entered is collected once,
+// creations executing their init code rather than the code of the address.
+func TestVandalContracts(t *testing.T) {
+	t.Parallel()
+
+	var (
+		root, callee, created, beneficiary = common.Address{1}, common.Address{2}, common.Address{3}, common.Address{4}
+
+		statedb = &vandalCodeStatedb{code: map[common.Address][]byte{
+			root:        {byte(vm.PUSH1), 0, byte(vm.STOP)},
+			callee:      {byte(vm.CALLER), byte(vm.STOP)},
+			beneficiary: {byte(vm.INVALID)},
+		}}
+		initcode = []byte{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.RETURN)}
+		env      = vm.NewEVM(vm.BlockContext{}, vm.TxContext{}, statedb, params.TestChainConfig, vm.Config{})
+	)
+	l := NewVandalTracer(&VandalConfig{EnableContracts: true})
+	l.CaptureStart(env, common.Address{}, root, false, nil, 0, nil)
+	for _, call := range []struct {
+		op    vm.OpCode
+		to    common.Address
+		input []byte
+	}{
+		{vm.CALL, callee, nil},
+		{vm.STATICCALL, callee, nil},
+		{vm.STATICCALL, common.BytesToAddress([]byte{1}), nil}, // Precompile, no code
+		{vm.CREATE, created, initcode},
+		{vm.SELFDESTRUCT, beneficiary, nil},
+	} {
+		l.CaptureEnter(call.op, root, call.to, call.input, 0, nil)
+		l.CaptureExit(nil, 0, nil)
+	}
+	l.CaptureEnd(nil, 0, nil)
+
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var result struct {
+		Contracts []struct {
+			Address  common.Address
+			CodeHash common.Hash
+			Code     hexutil.Bytes
+			Init     bool
+		}
+	}
+	if err := json.Unmarshal(res, &result); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	want := []struct {
+		addr common.Address
+		code []byte
+		init bool
+	}{
+		{root, statedb.code[root], false},
+		{callee, statedb.code[callee], false},
+		{created, initcode, true},
+	}
+	if len(result.Contracts) != len(want) {
+		t.Fatalf("contract count mismatch: have %d, want %d: %s", len(result.Contracts), len(want), res)
+	}
+	for i, contract := range result.Contracts {
+		if contract.Address != want[i].addr || !bytes.Equal(contract.Code, want[i].code) || contract.CodeHash != crypto.Keccak256Hash(want[i].code) || contract.Init != want[i].init {
+			t.Errorf("contract %d mismatch: have %+v, want %+v", i, contract, want[i])
+		}
+	}
+	facts, _ := l.Facts()
+	if rows := strings.Count(string(facts["contract.facts"]), "\n"); rows != len(want) {
+		t.Errorf("contract facts count mismatch: have %d, want %d", rows, len(want))
+	}
+	// Contracts are dropped on reset
+	l.Reset()
+	if res, _ := l.GetResult(); string(res) != `{"blocks":[],"contracts":[]}` {
+		t.Errorf("unexpected result after reset: %s", res)
+	}
+}
+
+// vandalTxStatedb is a state whose transaction context is set.
+type vandalTxStatedb struct {
+	dummyStatedb
+	hash common.Hash
+}
+
+func (db *vandalTxStatedb) TxHash() common.Hash { return db.hash }
+
+// Tests that a logger tracing transactions apart completes the trace of each,
+// keyed by its hash, without one transaction's steps or failure bleeding into
+// the next one.
+func TestVandalPerTransaction(t *testing.T) {
+	t.Parallel()
+
+	var (
+		statedb = new(vandalTxStatedb)
+		env     = vm.NewEVM(vm.BlockContext{}, vm.TxContext{}, statedb, params.TestChainConfig, vm.Config{})
+		l       = NewVandalTracer(&VandalConfig{PerTransaction: true, MemoryLimit: 4 * vandalStepSize})
+	)
+	if res, _ := l.GetResult(); string(res) != "[]" {
+		t.Fatalf("unexpected result before any transaction: %s", res)
+	}
+	for i, steps := range []int{2, 8, 3} { // The second transaction exceeds the memory limit
+		statedb.hash = common.Hash{byte(i + 1)}
+		l.CaptureTxStart(100000)
+		l.CaptureStart(env, common.Address{}, common.Address{1}, false, nil, 0, nil)
+		for pc := 0; pc < steps; pc++ {
+			l.CaptureState(uint64(pc), vm.ADD, 100, 3, nil)
+		}
+		l.CaptureEnd(nil, 0, nil)
+		l.CaptureTxEnd(0)
+	}
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var txs []struct {
+		TxHash common.Hash
+		Result []struct{ Entry, Exit int }
+		Error  string
+	}
+	if err := json.Unmarshal(res, &txs); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if len(txs) != 3 {
+		t.Fatalf("transaction count mismatch: have %d, want %d: %s", len(txs), 3, res)
+	}
+	for i, want := range []struct {
+		exit   int
+		failed bool
+	}{{1, false}, {0, true}, {2, false}} {
+		tx := txs[i]
+		if tx.TxHash != (common.Hash{byte(i + 1)}) {
+			t.Errorf("tx %d: hash mismatch: have %x", i, tx.TxHash)
+		}
+		if want.failed {
+			if !strings.Contains(tx.Error, ErrVandalMemoryLimit.Error()) || tx.Result != nil {
+				t.Errorf("tx %d: expected memory limit failure, have %s", i, res)
+			}
+			continue
+		}
+		if tx.Error != "" || len(tx.Result) != 1 || tx.Result[0].Entry != 0 || tx.Result[0].Exit != want.exit {
+			t.Errorf("tx %d: trace mismatch: %s", i, res)
+		}
+	}
+	if results := l.TxResults(); len(results) != 3 {
+		t.Errorf("tx results count mismatch: have %d, want %d", len(results), 3)
+	}
+	l.Reset()
+	if res, _ := l.GetResult(); string(res) != "[]" {
+		t.Errorf("unexpected result after reset: %s", res)
+	}
+}
+
+func TestVandalEncoder(t *testing.T) {
+	t.Parallel()
+
+	want, _ := newVandalTestLogger().GetResult()
+
+	// Traces are encoded identically in the background and synchronously
+	enc := NewVandalEncoder(1)
+	var encodings []*VandalEncoding
+	for i := 0; i < 4; i++ {
+		encodings = append(encodings, enc.Encode(newVandalTestLogger()))
+	}
+	encodings = append(encodings, (*VandalEncoder)(nil).Encode(newVandalTestLogger()))
+	enc.Close()
+
+	for i, encoding := range encodings {
+		have, err := encoding.Wait()
+		if err != nil {
+			t.Fatalf("encoding %d failed: %v", i, err)
+		}
+		if string(have) != string(want) {
+			t.Fatalf("encoding %d mismatch:\nhave %s\nwant %s", i, have, want)
+		}
+	}
+}
+
+func TestVandalReturnData(t *testing.T) {
+	t.Parallel()
+
+	// Return data is only captured if enabled
+	for _, enabled := range []bool{false, true} {
+		l := NewVandalTracer(&VandalConfig{EnableReturnData: enabled})
+		l.CaptureState(0, vm.PUSH1, 100, 3, []byte{0x80})
+		if have := l.steps.retAt(0) != nil; have != enabled {
+			t.Errorf("enabled %v: return data captured: %v", enabled, have)
+		}
+	}
+}
+
+func TestVandalReset(t *testing.T) {
+	t.Parallel()
+
+	want, _ := newVandalTestLogger().GetResult()
+
+	// A reset logger traces again into the same buffers
+	l := newVandalTestLogger()
+	l.GetResult()
+	pcs, bbs := &l.steps.pc[0], &l.bbs[0]
+
+	l.Reset()
+	if l.Steps() != 0 || len(l.bbs) != 0 {
+		t.Fatalf("trace not cleared: %d steps, %d blocks", l.Steps(), len(l.bbs))
+	}
+	have, err := traceVandalTestSteps(l).GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	if string(have) != string(want) {
+		t.Fatalf("result mismatch:\nhave %s\nwant %s", have, want)
+	}
+	if &l.steps.pc[0] != pcs || &l.bbs[0] != bbs {
+		t.Errorf("buffers not reused")
+	}
+	// Failures are cleared too
+	l.Stop(errors.New("stopped"))
+	l.Reset()
+	if _, err := traceVandalTestSteps(l).GetResult(); err != nil {
+		t.Errorf("failure not cleared: %v", err)
+	}
+	// Oversized buffers are released
+	l.steps.pc = make([]uint64, 0, vandalMaxRetained/vandalStepSize+1)
+	l.Reset()
+	if l.steps.pc != nil {
+		t.Errorf("oversized buffer retained, capacity %d", cap(l.steps.pc))
+	}
+}
+
+func TestVandalEncoderRecycle(t *testing.T) {
+	t.Parallel()
+
+	// Synchronously encoded loggers are reused right away
+	l := newVandalTestLogger()
+	(*VandalEncoder)(nil).Encode(l)
+	if have := (*VandalEncoder)(nil).Recycle(l); have != l || have.Steps() != 0 {
+		t.Errorf("synchronously encoded logger not recycled")
+	}
+	// Background encoded loggers are handed back once encoded
+	enc := NewVandalEncoder(1)
+	l = newVandalTestLogger()
+	encoding := enc.Encode(l)
+	if _, err := encoding.Wait(); err != nil {
+		t.Fatalf("encoding failed: %v", err)
+	}
+	if have := enc.Recycle(nil); have != l || have.Steps() != 0 {
+		t.Errorf("background encoded logger not recycled")
+	}
+	if have := enc.Recycle(nil); have != nil {
+		t.Errorf("logger recycled twice")
+	}
+	enc.Close()
+}
+
+func TestVandalMemoryLimit(t *testing.T) {
+	t.Parallel()
+
+	l := NewVandalTracer(&VandalConfig{MemoryLimit: 3*(vandalStepSize+vandalRetSize+1) + 1, EnableReturnData: true})
+	for i := 0; i < 10; i++ {
+		l.CaptureState(uint64(i), vm.PUSH1, 100, 3, []byte{byte(i)})
+	}
+	if l.Steps() != 3 {
+		t.Errorf("steps mismatch: have %d, want %d", l.Steps(), 3)
+	}
+	if _, err := l.GetResult(); !errors.Is(err, ErrVandalMemoryLimit) {
+		t.Errorf("want %v, have %v", ErrVandalMemoryLimit, err)
+	}
+}
+
+func TestVandalStepLimit(t *testing.T) {
+	t.Parallel()
+
+	l := NewVandalTracer(&VandalConfig{StepLimit: 3})
+	for i := 0; i < 10; i++ {
+		l.CaptureState(uint64(i), vm.ADD, 100, 3, nil)
+	}
+	if l.Steps() != 3 || !l.Truncated() {
+		t.Fatalf("unexpected trace: %d steps, truncated %v", l.Steps(), l.Truncated())
+	}
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var trace struct {
+		Blocks    []json.RawMessage
+		Truncated bool
+		Steps     int
+	}
+	if err := json.Unmarshal(res, &trace); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if len(trace.Blocks) != 1 || !trace.Truncated || trace.Steps != 3 {
+		t.Errorf("unexpected truncated result: %s", res)
+	}
+	// A reset logger traces in full again
+	l.Reset()
+	l.CaptureState(0, vm.STOP, 100, 0, nil)
+	if res, _ := l.GetResult(); res[0] != '[' || l.Truncated() {
+		t.Errorf("unexpected result after reset: %s", res)
+	}
+}
+
+// Tests that a stopped trace returns the steps traced so far, marked as
+// truncated, if partial results are enabled, and the error it was stopped with
+// otherwise.
+func TestVandalPartialOnStop(t *testing.T) {
+	t.Parallel()
+
+	timeout := errors.New("execution timeout")
+	trace := func(l *VandalLogger) *VandalLogger {
+		l.CaptureState(0, vm.PUSH1, 100, 3, nil)
+		l.CaptureState(2, vm.JUMP, 97, 8, nil)
+		l.Stop(timeout)
+		l.CaptureState(5, vm.JUMPDEST, 89, 1, nil)
+		return l
+	}
+	if _, err := trace(NewVandalTracer(nil)).GetResult(); !errors.Is(err, timeout) {
+		t.Errorf("want %v, have %v", timeout, err)
+	}
+	l := trace(NewVandalTracer(&VandalConfig{PartialOnStop: true}))
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var partial struct {
+		Blocks    []json.RawMessage
+		Truncated bool
+		Steps     int
+	}
+	if err := json.Unmarshal(res, &partial); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if len(partial.Blocks) != 1 || !partial.Truncated || partial.Steps != 2 || !l.Truncated() {
+		t.Errorf("unexpected partial result: %s", res)
+	}
+	if facts, err := l.Facts(); err != nil || string(facts["truncated.facts"]) != "2\n" {
+		t.Errorf("unexpected truncated facts: %q, %v", facts["truncated.facts"], err)
+	}
+	// Streamed traces write out the block held when stopped
+	out := new(bytes.Buffer)
+	l = trace(NewVandalStreamLogger(&VandalConfig{PartialOnStop: true}, out))
+	if res, err := l.GetResult(); err != nil || string(res) != `{"blocks":1,"steps":2,"truncated":true}` {
+		t.Errorf("unexpected stream summary: %s, %v", res, err)
+	}
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != 1 {
+		t.Errorf("streamed block count mismatch: have %d, want %d", lines, 1)
+	}
+}
+
+func TestVandalOnlyAddresses(t *testing.T) {
+	t.Parallel()
+
+	var (
+		outer = common.Address{1}
+		lib   = common.Address{2}
+	)
+	trace := func(l *VandalLogger) []vm.OpCode {
+		l.CaptureStart(nil, common.Address{}, outer, false, nil, 0, nil)
+		l.CaptureState(0, vm.PUSH1, 100, 3, nil)
+		l.CaptureEnter(vm.DELEGATECALL, outer, lib, nil, 50, nil)
+		l.CaptureState(0, vm.CALLER, 50, 2, nil)
+		l.CaptureState(1, vm.STOP, 48, 0, nil)
+		l.CaptureExit(nil, 2, nil)
+		l.CaptureState(2, vm.DELEGATECALL, 97, 40, nil)
+		l.CaptureState(3, vm.STOP, 57, 0, nil)
+		l.CaptureEnd(nil, 43, nil)
+		return l.steps.op
+	}
+	for _, tt := range []struct {
+		only []common.Address
+		want []vm.OpCode
+	}{
+		{nil, []vm.OpCode{vm.PUSH1, vm.CALLER, vm.STOP, vm.DELEGATECALL, vm.STOP}},
+		{[]common.Address{outer}, []vm.OpCode{vm.PUSH1, vm.DELEGATECALL, vm.STOP}},
+		{[]common.Address{lib}, []vm.OpCode{vm.CALLER, vm.STOP}},
+		{[]common.Address{{3}}, nil},
+	} {
+		if have := trace(NewVandalTracer(&VandalConfig{OnlyAddresses: tt.only})); !slices.Equal(have, tt.want) {
+			t.Errorf("addresses %v: steps mismatch: have %v, want %v", tt.only, have, tt.want)
+		}
+	}
+}
+
+func TestVandalValueCapture(t *testing.T) {
+	t.Parallel()
+
+	for _, disabled := range []bool{false, true} {
+		l := NewVandalTracer(&VandalConfig{DisableValueCapture: disabled})
+		l.CaptureStart(nil, common.Address{}, common.Address{1}, false, nil, 0, big.NewInt(5))
+		l.CaptureEnter(vm.CALL, common.Address{1}, common.Address{2}, nil, 50, big.NewInt(7))
+		l.CaptureExit(nil, 0, nil)
+		l.CaptureState(0, vm.CALL, 100, 9000, nil)
+		l.CaptureEnter(vm.CALL, common.Address{1}, common.Address{2}, nil, 50, new(big.Int))
+		l.CaptureExit(nil, 0, nil)
+		l.CaptureState(1, vm.CALL, 100, 100, nil)
+		l.CaptureEnter(vm.CREATE, common.Address{1}, common.Address{3}, nil, 50, big.NewInt(3))
+		l.CaptureExit(nil, 0, nil)
+		l.CaptureState(2, vm.CREATE, 100, 32000, nil)
+		l.CaptureState(3, vm.STOP, 0, 0, nil)
+		l.CaptureEnd(nil, 0, nil)
+
+		want := []*big.Int{big.NewInt(7), nil, big.NewInt(3), nil}
+		total := "10"
+		if disabled {
+			want[0], want[2], total = nil, nil, "null"
+		}
+		for i := range want {
+			if have := l.steps.valueAt(i); (have == nil) != (want[i] == nil) || (have != nil && have.Cmp(want[i]) != 0) {
+				t.Errorf("disabled %v: step %d value mismatch: have %v, want %v", disabled, i, have, want[i])
+			}
+		}
+		// The values transferred by the steps of a block are totalled
+		res, err := l.GetResult()
+		if err != nil {
+			t.Fatalf("failed to retrieve result: %v", err)
+		}
+		var blocks []struct{ Value json.RawMessage }
+		if err := json.Unmarshal(res, &blocks); err != nil {
+			t.Fatalf("failed to decode result: %v", err)
+		}
+		if len(blocks) != 1 || string(blocks[0].Value) != total {
+			t.Errorf("disabled %v: block value mismatch: have %s, want %s", disabled, res, total)
+		}
+	}
+}
+
+func TestVandalOperands(t *testing.T) {
+	t.Parallel()
+
+	words := func(vals ...uint64) []uint256.Int {
+		stack := make([]uint256.Int, len(vals))
+		for i, val := range vals {
+			stack[i].SetUint64(val)
+		}
+		return stack
+	}
+	l := NewVandalTracer(&VandalConfig{EnableOperands: true})
+	l.CaptureStart(nil, common.Address{}, common.Address{1}, false, nil, 0, nil)
+	l.CaptureOperands(vm.PUSH1, nil)
+	l.CaptureState(0, vm.PUSH1, 100, 3, nil)
+
+	// The operands of a call outlive the frame it spawns
+	l.CaptureOperands(vm.CALL, words(7, 6, 5, 4, 3, 2, 1))
+	l.CaptureEnter(vm.CALL, common.Address{1}, common.Address{2}, nil, 50, nil)
+	l.CaptureOperands(vm.ADD, words(9, 8))
+	l.CaptureState(0, vm.ADD, 50, 3, nil)
+	l.CaptureExit(nil, 3, nil)
+	l.CaptureState(2, vm.CALL, 97, 100, nil)
+	l.CaptureEnd(nil, 0, nil)
+
+	for i, want := range [][]uint256.Int{{}, words(8, 9), words(1, 2, 3, 4, 5, 6, 7)} {
+		have, ok := l.steps.operandsAt(i)
+		if !ok || !slices.Equal(have, want) {
+			t.Errorf("step %d: operands mismatch: have %v, want %v", i, have, want)
+		}
+	}
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	if want := `"Operands":["0x1","0x2","0x3","0x4","0x5","0x6","0x7"]`; !bytes.Contains(res, []byte(want)) {
+		t.Errorf("call operands not encoded topmost first: %s", res)
+	}
+	// Operands are omitted unless captured
+	l = NewVandalTracer(nil)
+	l.CaptureOperands(vm.POP, words(1))
+	l.CaptureState(0, vm.POP, 100, 2, nil)
+	if res, _ := l.GetResult(); bytes.Contains(res, []byte("Operands")) {
+		t.Errorf("operands encoded while disabled: %s", res)
+	}
+}
+
+func TestVandalPresize(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		gas  uint64
+		want int
+	}{
+		{21000, 21000 / vandalGasPerStep},
+		{30_000_000, vandalMaxPresize},
+	} {
+		l := NewVandalTracer(nil)
+		l.CaptureTxStart(tt.gas)
+		if have := cap(l.steps.pc); have != tt.want {
+			t.Errorf("gas %d: capacity mismatch: have %d, want %d", tt.gas, have, tt.want)
+		}
+	}
+	// Already traced steps are retained
+	l := NewVandalTracer(nil)
+	l.CaptureState(0, vm.STOP, 0, 0, nil)
+	l.CaptureTxStart(21000)
+	if l.Steps() != 1 {
+		t.Errorf("steps mismatch: have %d, want %d", l.Steps(), 1)
+	}
+}
+
+func BenchmarkVandalGetResult(b *testing.B) {
+	l := NewVandalTracer(nil)
+	for i := 0; i < 100_000; i++ {
+		l.CaptureState(uint64(i%1000), vm.ADD, uint64(i), 3, []byte{byte(i)})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := l.GetResult(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Tests the classification of opcodes, including those introduced after the
+// kinds were first defined.
+func TestVandalKinds(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		op    vm.OpCode
+		kind  OpKind
+		halts bool
+		gap   int
+	}{
+		{vm.STOP, OpKindUnknown, true, 1},
+		{vm.ADD, OpKindUnknown, false, 1},
+		{vm.CALLER, OpKindOne, false, 1},
+		{vm.CHAINID, OpKindOne, false, 1},
+		{vm.SELFBALANCE, OpKindOne, false, 1},
+		{vm.BASEFEE, OpKindOne, false, 1},
+		{vm.KECCAK256, OpKindTwo, false, 1},
+		{vm.SLOAD, OpKindThreeLoad, false, 1},
+		{vm.TLOAD, OpKindThreeLoad, false, 1},
+		{vm.TSTORE, OpKindThreeStoreOne, false, 1},
+		{vm.MCOPY, OpKindThreeStoreTwo, false, 1},
+		{vm.PUSH0, OpKindUnknown, false, 0},
+		{vm.PUSH1, OpKindUnknown, false, 1},
+		{vm.PUSH32, OpKindUnknown, false, 32},
+		{vm.DELEGATECALL, OpKindFour, false, 1},
+		{vm.CREATE2, OpKindFive, false, 1},
+		{vm.RETURN, OpKindUnknown, true, 1},
+		{vm.REVERT, OpKindUnknown, true, 1},
+		{vm.INVALID, OpKindUnknown, false, 1},
+		{vm.SELFDESTRUCT, OpKindUnknown, true, 1},
+	} {
+		if kind := GetKind(tt.op); kind != tt.kind {
+			t.Errorf("%v: kind mismatch: have %d, want %d", tt.op, kind, tt.kind)
+		}
+		if halts := possiblyHalts(tt.op); halts != tt.halts {
+			t.Errorf("%v: halt mismatch: have %t, want %t", tt.op, halts, tt.halts)
+		}
+		if gap := pcGap(tt.op); gap != tt.gap {
+			t.Errorf("%v: gap mismatch: have %d, want %d", tt.op, gap, tt.gap)
+		}
+	}
+}
+
+// vandalProtoMessage holds the fields of a protobuf message by number, varints
+// and bytes apart.
+type vandalProtoMessage struct {
+	uints map[protowire.Number][]uint64
+	bytes map[protowire.Number][][]byte
+}
+
+// decodeVandalProto splits a protobuf message into its fields.
+func decodeVandalProto(t *testing.T, b []byte) vandalProtoMessage {
+	t.Helper()
+
+	msg := vandalProtoMessage{uints: make(map[protowire.Number][]uint64), bytes: make(map[protowire.Number][][]byte)}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("invalid varint: %v", protowire.ParseError(n))
+			}
+			msg.uints[num], b = append(msg.uints[num], v), b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatalf("invalid bytes: %v", protowire.ParseError(n))
+			}
+			msg.bytes[num], b = append(msg.bytes[num], v), b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+	return msg
+}
+
+// uint returns the varint field of the given number, 0 if absent.
+func (m vandalProtoMessage) uint(num protowire.Number) uint64 {
+	if v := m.uints[num]; len(v) > 0 {
+		return v[len(v)-1]
+	}
+	return 0
+}
+
+// Tests that the protobuf encoding of a trace holds the same blocks as its
+// JSON encoding.
+func TestVandalProtobuf(t *testing.T) {
+	t.Parallel()
+
+	l := traceVandalTestSteps(NewVandalTracer(&VandalConfig{EnableReturnData: true, EnableEdges: true}))
+	blob, err := l.Protobuf()
+	if err != nil {
+		t.Fatalf("failed to encode protobuf: %v", err)
+	}
+	res, err := l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var want struct {
+		Blocks []struct {
+			Entry, Exit uint64
+			Address     common.Address
+			Value       *big.Int
+			Ops         []struct {
+				Pc, Gas, Cost    uint64
+				Op               vm.OpCode
+				Depth, CallIndex uint64
+				Ret              []byte
+				Value            *big.Int
+			}
+		}
+		Edges []json.RawMessage
+	}
+	if err := json.Unmarshal(res, &want); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	trace := decodeVandalProto(t, blob)
+	if len(trace.bytes[1]) != len(want.Blocks) || len(trace.bytes[2]) != len(want.Edges) {
+		t.Fatalf("trace mismatch: have %d blocks, %d edges, want %d blocks, %d edges", len(trace.bytes[1]), len(trace.bytes[2]), len(want.Blocks), len(want.Edges))
+	}
+	for i, b := range trace.bytes[1] {
+		var (
+			block = decodeVandalProto(t, b)
+			wb    = want.Blocks[i]
+		)
+		if block.uint(1) != wb.Entry || block.uint(2) != wb.Exit || common.BytesToAddress(block.bytes[4][0]) != wb.Address {
+			t.Fatalf("block %d mismatch: have %+v, want %+v", i, block, wb)
+		}
+		if value := block.bytes[5]; (len(value) > 0) != (wb.Value != nil) || (wb.Value != nil && new(big.Int).SetBytes(value[0]).Cmp(wb.Value) != 0) {
+			t.Errorf("block %d: value mismatch: have %x, want %v", i, value, wb.Value)
+		}
+		if len(block.bytes[3]) != len(wb.Ops) {
+			t.Fatalf("block %d: op count mismatch: have %d, want %d", i, len(block.bytes[3]), len(wb.Ops))
+		}
+		for j, o := range block.bytes[3] {
+			var (
+				op = decodeVandalProto(t, o)
+				wo = wb.Ops[j]
+			)
+			if op.uint(1) != wo.Pc || vm.OpCode(op.uint(2)) != wo.Op || op.uint(3) != wo.Gas || op.uint(4) != wo.Cost || block.uint(6) != wo.Depth || block.uint(7) != wo.CallIndex {
+				t.Errorf("block %d op %d mismatch: have %+v, want %+v", i, j, op, wo)
+			}
+			if ret := op.bytes[5]; (len(ret) > 0) != (wo.Ret != nil) || (wo.Ret != nil && !bytes.Equal(ret[0], wo.Ret)) {
+				t.Errorf("block %d op %d: ret mismatch: have %x, want %x", i, j, ret, wo.Ret)
+			}
+			if value := op.bytes[6]; (len(value) > 0) != (wo.Value != nil) || (wo.Value != nil && new(big.Int).SetBytes(value[0]).Cmp(wo.Value) != 0) {
+				t.Errorf("block %d op %d: value mismatch: have %x, want %v", i, j, value, wo.Value)
+			}
+		}
+	}
+	// The result is the encoding as a JSON string in the protobuf format
+	l.cfg.Format = VandalFormatProtobuf
+	res, err = l.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve result: %v", err)
+	}
+	var have []byte
+	if err := json.Unmarshal(res, &have); err != nil || !bytes.Equal(have, blob) {
+		t.Errorf("protobuf result mismatch: %s", res)
+	}
+}
+
+func BenchmarkVandalProtobuf(b *testing.B) {
+	l := NewVandalTracer(nil)
+	for i := 0; i < 100_000; i++ {
+		l.CaptureState(uint64(i%1000), vm.ADD, uint64(i), 3, []byte{byte(i)})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := l.Protobuf(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newVandalBenchLogger returns a Vandal logger with a trace of a million steps
+// of every opcode, sequential but for the jumps taken.
+func newVandalBenchLogger() *VandalLogger {
+	var (
+		l   = NewVandalTracer(nil)
+		rng = rand.New(rand.NewSource(1))
+		pc  uint64
+	)
+	for i := 0; i < 1_000_000; i++ {
+		op := vm.OpCode(rng.Intn(256))
+		l.CaptureState(pc, op, 0, 0, nil)
+		if pc += uint64(pcGap(op)); op == vm.JUMP || op == vm.JUMPI {
+			pc = uint64(rng.Intn(1024))
+		}
+	}
+	return l
+}
+
+func BenchmarkVandalBlocks(b *testing.B) {
+	l := newVandalBenchLogger()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.blocks()
+	}
+}
+
+func BenchmarkVandalGetKind(b *testing.B) {
+	var kinds [OpKindFive + 1]int
+	for i := 0; i < b.N; i++ {
+		kinds[GetKind(vm.OpCode(i))]++
+	}
+}
+
+func BenchmarkVandalPossiblyHalts(b *testing.B) {
+	var halts int
+	for i := 0; i < b.N; i++ {
+		if possiblyHalts(vm.OpCode(i)) {
+			halts++
+		}
+	}
+}
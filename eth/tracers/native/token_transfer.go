@@ -0,0 +1,583 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package native
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func init() {
+	tracers.DefaultDirectory.Register("tokenTransferTracer", newTokenTransferTracer, false)
+}
+
+// Topics of the token events decoded by the token transfer tracer.
+var (
+	transferTopic       = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	approvalTopic       = crypto.Keccak256Hash([]byte("Approval(address,address,uint256)"))
+	approvalForAllTopic = crypto.Keccak256Hash([]byte("ApprovalForAll(address,address,bool)"))
+	transferSingleTopic = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	transferBatchTopic  = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+	depositTopic        = crypto.Keccak256Hash([]byte("Deposit(address,uint256)"))
+	withdrawalTopic     = crypto.Keccak256Hash([]byte("Withdrawal(address,uint256)"))
+)
+
+// Token standards of the decoded events. ERC20 and ERC721 share their event
+// signatures, and are told apart by whether the amount or token id is indexed.
+const (
+	tokenERC20   = "erc20"
+	tokenERC721  = "erc721"
+	tokenERC1155 = "erc1155"
+)
+
+// ethTransferType is the type of the transfers of ether.
+const ethTransferType = "NATIVE"
+
+// Wrapping events of wrapped native tokens, e.g. WETH, which do not emit a
+// Transfer event when minting or burning.
+const (
+	wrapDeposit    = "deposit"
+	wrapWithdrawal = "withdrawal"
+)
+
+// knownWrappedNative are the canonical wrapped native tokens by chain id, whose
+// deposits and withdrawals are decoded unless others are configured. Other
+// contracts emit Deposit and Withdrawal events with the same signatures, which
+// are not wrapping of the native token.
+var knownWrappedNative = map[uint64]common.Address{
+	1:        common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"), // Mainnet WETH9
+	11155111: common.HexToAddress("0xfFf9976782d46CC05630D1f6eBAb18b2324d6B14"), // Sepolia WETH9
+}
+
+// tokenTransfer is a transfer of tokens, decoded from a Transfer event of an
+// ERC20 or ERC721 token, or a TransferSingle or TransferBatch event of an
+// ERC1155 token, the latter yielding a transfer per token id.
+//
+// The token is the address the event was logged by, i.e. the storage context
+// holding the balances. If the event was emitted by code running on behalf of
+// it through a DELEGATECALL or CALLCODE, e.g. the implementation of a proxy,
+// the address of that code is recorded as the emitter.
+//
+// Deposits into and withdrawals from a wrapped native token are recorded as
+// ERC20 transfers from the token to the depositor, and from the withdrawer to
+// the token respectively, marked by their event.
+type tokenTransfer struct {
+	Standard string          `json:"standard"`
+	Event    string          `json:"event,omitempty"` // Wrapped native tokens only
+	Token    common.Address  `json:"token"`
+	Emitter  *common.Address `json:"emitter,omitempty"`
+	Operator *common.Address `json:"operator,omitempty"` // ERC1155 only
+	From     common.Address  `json:"from"`
+	To       common.Address  `json:"to"`
+	TokenID  *hexutil.Big    `json:"tokenId,omitempty"` // ERC721 and ERC1155 only
+	Value    *hexutil.Big    `json:"value,omitempty"`   // ERC20 and ERC1155 only
+}
+
+// tokenApproval is an allowance granted by an Approval event of an ERC20 or
+// ERC721 token, or an operator approved by an ApprovalForAll event, which both
+// ERC721 and ERC1155 tokens emit, so its standard is left empty. The token
+// and emitter are attributed as for transfers.
+type tokenApproval struct {
+	Standard string          `json:"standard,omitempty"`
+	Token    common.Address  `json:"token"`
+	Emitter  *common.Address `json:"emitter,omitempty"`
+	Owner    common.Address  `json:"owner"`
+	Spender  common.Address  `json:"spender"`
+	TokenID  *hexutil.Big    `json:"tokenId,omitempty"`  // ERC721 only
+	Value    *hexutil.Big    `json:"value,omitempty"`    // ERC20 only
+	Approved *bool           `json:"approved,omitempty"` // ApprovalForAll only
+}
+
+// ethTransfer is ether transferred by the transaction itself, or by a call,
+// creation or self-destruct made during it. Its type is always NATIVE, telling
+// it apart from token transfers, the opcode moving the ether being its op.
+//
+// The call frame entered by the transfer is located by its trace address, the
+// indices of the subcalls leading to it from the top call, as in the flat call
+// tracer. The value of the transaction is thus at depth 0 with an empty trace
+// address. The value of a CALLCODE stays with the caller, running the callee's
+// code in its own context, so it is not recorded.
+type ethTransfer struct {
+	Type         string         `json:"type"`
+	Op           string         `json:"op"`
+	From         common.Address `json:"from"`
+	To           common.Address `json:"to"`
+	Value        *hexutil.Big   `json:"value"`
+	Depth        int            `json:"depth"`
+	TraceAddress []int          `json:"traceAddress"`
+}
+
+// tokenFlow is the net amount of a token, or of ether if the token is unset,
+// received by an address over a transaction, negative if it was sent. ERC721
+// tokens are counted, and ERC1155 tokens are aggregated per token id.
+type tokenFlow struct {
+	Address  common.Address  `json:"address"`
+	Standard string          `json:"standard,omitempty"`
+	Token    *common.Address `json:"token,omitempty"`
+	TokenID  *hexutil.Big    `json:"tokenId,omitempty"` // ERC1155 only
+	Net      *hexutil.Big    `json:"net"`
+}
+
+// tokenTransferSummary aggregates the transfers of a transaction: the nonzero
+// net flows of the addresses involved, the number of transfers, token and
+// ether alike, and the tokens transferred, in order of appearance.
+type tokenTransferSummary struct {
+	Flows  []tokenFlow      `json:"flows"`
+	Hops   int              `json:"hops"`
+	Tokens []common.Address `json:"tokens"`
+}
+
+// tokenTransferResult is the result of the token transfer tracer.
+type tokenTransferResult struct {
+	Transfers    []tokenTransfer       `json:"transfers"`
+	Approvals    []tokenApproval       `json:"approvals"`
+	EthTransfers []ethTransfer         `json:"ethTransfers"`
+	Summary      *tokenTransferSummary `json:"summary,omitempty"`
+}
+
+// tokenTransferFrame is the position in the results at which a call frame was
+// entered, the results past it being reverted along with the frame.
+type tokenTransferFrame struct {
+	transfers    int
+	approvals    int
+	ethTransfers int
+	calls        int // Number of subcalls entered so far
+}
+
+// tokenTransferTracer decodes the token transfers and approvals of a
+// transaction from the events of ERC20, ERC721 and ERC1155 tokens, along with
+// the ether transferred by the transaction and its internal calls, e.g.
+//
+//	> debug.traceTransaction("0x214e...", {tracer: "tokenTransferTracer"})
+//	{"transfers": [{"standard": "erc20", "token": "0x...", "from": "0x...", "to": "0x...", "value": "0x..."}],
+//	 "approvals": [], "ethTransfers": [{"type": "NATIVE", "op": "CALL", "from": "0x...", "to": "0x...", "value": "0x...",
+//	                  "depth": 1, "traceAddress": [0]}]}
+//
+// Events and transfers of call frames which failed are dropped, as they are
+// reverted. Events not following the token standards, e.g. with a missing
+// amount, are skipped. Deposit and Withdrawal events are decoded as wrapping of
+// the native token if emitted by one of the wrappedNative addresses, or by the
+// known wrapped native token of the chain if none are configured. With
+// withSummary set, the net flows of the transfers are aggregated into a summary
+// of the transaction.
+type tokenTransferTracer struct {
+	noopTracer
+	config    tokenTransferTracerConfig
+	wrapped   map[common.Address]bool // Wrapped native tokens whose deposits and withdrawals are decoded
+	result    tokenTransferResult
+	frames    []tokenTransferFrame
+	path      []int       // Trace address of the innermost call frame
+	interrupt atomic.Bool // Atomic flag to signal execution interruption
+	reason    error       // Textual reason for the interruption
+}
+
+type tokenTransferTracerConfig struct {
+	WithSummary   bool             `json:"withSummary"`   // If true, the net flows of the transaction are aggregated
+	WrappedNative []common.Address `json:"wrappedNative"` // Contracts whose deposits and withdrawals are decoded, the chain's known one if empty
+}
+
+// newTokenTransferTracer returns a native go tracer which decodes the token
+// transfers of a transaction, and implements vm.EVMLogger.
+func newTokenTransferTracer(ctx *tracers.Context, cfg json.RawMessage) (tracers.Tracer, error) {
+	var config tokenTransferTracerConfig
+	if cfg != nil {
+		if err := json.Unmarshal(cfg, &config); err != nil {
+			return nil, err
+		}
+	}
+	wrapped := make(map[common.Address]bool, len(config.WrappedNative))
+	for _, addr := range config.WrappedNative {
+		wrapped[addr] = true
+	}
+	return &tokenTransferTracer{
+		config:  config,
+		wrapped: wrapped,
+		result: tokenTransferResult{
+			Transfers:    []tokenTransfer{},
+			Approvals:    []tokenApproval{},
+			EthTransfers: []ethTransfer{},
+		},
+	}, nil
+}
+
+// CaptureState implements the EVMLogger interface to trace a single step of VM execution.
+func (t *tokenTransferTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	// skip if the previous op caused an error
+	if err != nil || t.interrupt.Load() {
+		return
+	}
+	if op < vm.LOG1 || op > vm.LOG4 {
+		return
+	}
+	var (
+		stack  = scope.Stack.Data()
+		size   = int(op - vm.LOG0)
+		topics = make([]common.Hash, size)
+	)
+	if len(stack) < size+2 {
+		return
+	}
+	for i := 0; i < size; i++ {
+		topics[i] = stack[len(stack)-3-i].Bytes32()
+	}
+	switch topics[0] {
+	case transferTopic, approvalTopic, approvalForAllTopic, transferSingleTopic, transferBatchTopic, depositTopic, withdrawalTopic:
+	default:
+		return
+	}
+	mStart, mSize := stack[len(stack)-1], stack[len(stack)-2]
+	data, err := tracers.GetMemoryCopyPadded(scope.Memory, int64(mStart.Uint64()), int64(mSize.Uint64()))
+	if err != nil {
+		// mSize was unrealistically large
+		log.Warn("failed to copy log data", "err", err, "tracer", "tokenTransferTracer", "offset", mStart, "size", mSize)
+		return
+	}
+	// Attribute the event to the storage context, noting the delegated code
+	token := scope.Contract.Address()
+	var emitter *common.Address
+	if code := scope.Contract.CodeAddr; code != nil && *code != token {
+		emitter = new(common.Address)
+		*emitter = *code
+	}
+	t.decodeEvent(token, emitter, topics, data)
+}
+
+// decodeEvent decodes a token event logged by the given contract, if it follows
+// one of the token standards. The emitter is the address of the code logging
+// the event, if not the contract's own.
+func (t *tokenTransferTracer) decodeEvent(token common.Address, emitter *common.Address, topics []common.Hash, data []byte) {
+	switch {
+	case topics[0] == transferTopic && len(topics) == 3 && len(data) == 32:
+		t.result.Transfers = append(t.result.Transfers, tokenTransfer{
+			Standard: tokenERC20,
+			Token:    token,
+			Emitter:  emitter,
+			From:     common.BytesToAddress(topics[1][:]),
+			To:       common.BytesToAddress(topics[2][:]),
+			Value:    tokenWord(data),
+		})
+	case topics[0] == transferTopic && len(topics) == 4 && len(data) == 0:
+		t.result.Transfers = append(t.result.Transfers, tokenTransfer{
+			Standard: tokenERC721,
+			Token:    token,
+			Emitter:  emitter,
+			From:     common.BytesToAddress(topics[1][:]),
+			To:       common.BytesToAddress(topics[2][:]),
+			TokenID:  tokenWord(topics[3][:]),
+		})
+	case topics[0] == approvalTopic && len(topics) == 3 && len(data) == 32:
+		t.result.Approvals = append(t.result.Approvals, tokenApproval{
+			Standard: tokenERC20,
+			Token:    token,
+			Emitter:  emitter,
+			Owner:    common.BytesToAddress(topics[1][:]),
+			Spender:  common.BytesToAddress(topics[2][:]),
+			Value:    tokenWord(data),
+		})
+	case topics[0] == approvalTopic && len(topics) == 4 && len(data) == 0:
+		t.result.Approvals = append(t.result.Approvals, tokenApproval{
+			Standard: tokenERC721,
+			Token:    token,
+			Emitter:  emitter,
+			Owner:    common.BytesToAddress(topics[1][:]),
+			Spender:  common.BytesToAddress(topics[2][:]),
+			TokenID:  tokenWord(topics[3][:]),
+		})
+	case topics[0] == approvalForAllTopic && len(topics) == 3 && len(data) == 32:
+		approved := new(big.Int).SetBytes(data).Sign() != 0
+		t.result.Approvals = append(t.result.Approvals, tokenApproval{
+			Token:    token,
+			Emitter:  emitter,
+			Owner:    common.BytesToAddress(topics[1][:]),
+			Spender:  common.BytesToAddress(topics[2][:]),
+			Approved: &approved,
+		})
+	case topics[0] == transferSingleTopic && len(topics) == 4 && len(data) == 64:
+		operator := common.BytesToAddress(topics[1][:])
+		t.result.Transfers = append(t.result.Transfers, tokenTransfer{
+			Standard: tokenERC1155,
+			Token:    token,
+			Emitter:  emitter,
+			Operator: &operator,
+			From:     common.BytesToAddress(topics[2][:]),
+			To:       common.BytesToAddress(topics[3][:]),
+			TokenID:  tokenWord(data[:32]),
+			Value:    tokenWord(data[32:]),
+		})
+	case topics[0] == transferBatchTopic && len(topics) == 4:
+		ids, ok := tokenWords(data, 0)
+		if !ok {
+			return
+		}
+		values, ok := tokenWords(data, 1)
+		if !ok || len(values) != len(ids) {
+			return
+		}
+		operator := common.BytesToAddress(topics[1][:])
+		for i := range ids {
+			t.result.Transfers = append(t.result.Transfers, tokenTransfer{
+				Standard: tokenERC1155,
+				Token:    token,
+				Emitter:  emitter,
+				Operator: &operator,
+				From:     common.BytesToAddress(topics[2][:]),
+				To:       common.BytesToAddress(topics[3][:]),
+				TokenID:  ids[i],
+				Value:    values[i],
+			})
+		}
+	case topics[0] == depositTopic && len(topics) == 2 && len(data) == 32 && t.wrapsNative(token):
+		t.result.Transfers = append(t.result.Transfers, tokenTransfer{
+			Standard: tokenERC20,
+			Event:    wrapDeposit,
+			Token:    token,
+			Emitter:  emitter,
+			From:     token,
+			To:       common.BytesToAddress(topics[1][:]),
+			Value:    tokenWord(data),
+		})
+	case topics[0] == withdrawalTopic && len(topics) == 2 && len(data) == 32 && t.wrapsNative(token):
+		t.result.Transfers = append(t.result.Transfers, tokenTransfer{
+			Standard: tokenERC20,
+			Event:    wrapWithdrawal,
+			Token:    token,
+			Emitter:  emitter,
+			From:     common.BytesToAddress(topics[1][:]),
+			To:       token,
+			Value:    tokenWord(data),
+		})
+	}
+}
+
+// wrapsNative reports whether the deposits and withdrawals of the given token
+// are decoded as wrapping of the native token.
+func (t *tokenTransferTracer) wrapsNative(token common.Address) bool {
+	return t.wrapped[token]
+}
+
+// tokenWord decodes a 32 byte word as an integer.
+func tokenWord(word []byte) *hexutil.Big {
+	return (*hexutil.Big)(new(big.Int).SetBytes(word))
+}
+
+// tokenWords decodes the ABI encoded uint256[] at the given argument position
+// of data, reporting whether it is well formed.
+func tokenWords(data []byte, arg int) ([]*hexutil.Big, bool) {
+	if len(data) < 32*(arg+1) {
+		return nil, false
+	}
+	offset := new(big.Int).SetBytes(data[32*arg : 32*(arg+1)])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(data))-32 {
+		return nil, false
+	}
+	start := offset.Uint64()
+	count := new(big.Int).SetBytes(data[start : start+32])
+	if !count.IsUint64() || count.Uint64() > (uint64(len(data))-start-32)/32 {
+		return nil, false
+	}
+	words := make([]*hexutil.Big, count.Uint64())
+	for i := range words {
+		pos := start + 32 + 32*uint64(i)
+		words[i] = tokenWord(data[pos : pos+32])
+	}
+	return words, true
+}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (t *tokenTransferTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	// Decode the wrapping of the chain's known wrapped native token by default
+	if len(t.config.WrappedNative) == 0 {
+		if id := env.ChainConfig().ChainID; id != nil && id.IsUint64() {
+			if token, ok := knownWrappedNative[id.Uint64()]; ok {
+				t.wrapped[token] = true
+			}
+		}
+	}
+	// The value of the transaction is reverted along with its top call
+	t.enter()
+	if value == nil || value.Sign() == 0 {
+		return
+	}
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	t.result.EthTransfers = append(t.result.EthTransfers, ethTransfer{
+		Type:         ethTransferType,
+		Op:           typ.String(),
+		From:         from,
+		To:           to,
+		Value:        (*hexutil.Big)(new(big.Int).Set(value)),
+		TraceAddress: []int{},
+	})
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *tokenTransferTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	t.exit(err)
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *tokenTransferTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	// The transfer of the frame is reverted along with it
+	t.enter()
+	if t.interrupt.Load() || value == nil || value.Sign() == 0 {
+		return
+	}
+	switch typ {
+	case vm.CALL, vm.CREATE, vm.CREATE2, vm.SELFDESTRUCT:
+		t.result.EthTransfers = append(t.result.EthTransfers, ethTransfer{
+			Type:         ethTransferType,
+			Op:           typ.String(),
+			From:         from,
+			To:           to,
+			Value:        (*hexutil.Big)(new(big.Int).Set(value)),
+			Depth:        len(t.path),
+			TraceAddress: append([]int{}, t.path...),
+		})
+	}
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *tokenTransferTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	t.exit(err)
+}
+
+// enter starts a call frame at the current position of the results.
+func (t *tokenTransferTracer) enter() {
+	if n := len(t.frames); n > 0 {
+		t.path = append(t.path, t.frames[n-1].calls)
+		t.frames[n-1].calls++
+	}
+	t.frames = append(t.frames, tokenTransferFrame{
+		transfers:    len(t.result.Transfers),
+		approvals:    len(t.result.Approvals),
+		ethTransfers: len(t.result.EthTransfers),
+	})
+}
+
+// exit ends the innermost call frame, dropping its results if it failed.
+func (t *tokenTransferTracer) exit(err error) {
+	if len(t.frames) == 0 {
+		return
+	}
+	frame := t.frames[len(t.frames)-1]
+	t.frames = t.frames[:len(t.frames)-1]
+	if len(t.path) > 0 {
+		t.path = t.path[:len(t.path)-1]
+	}
+	if err == nil {
+		return
+	}
+	t.result.Transfers = t.result.Transfers[:frame.transfers]
+	t.result.Approvals = t.result.Approvals[:frame.approvals]
+	t.result.EthTransfers = t.result.EthTransfers[:frame.ethTransfers]
+}
+
+// summary aggregates the net flows of the transfers of the transaction.
+func (t *tokenTransferTracer) summary() *tokenTransferSummary {
+	type flowKey struct {
+		address  common.Address
+		standard string
+		token    common.Address
+		id       common.Hash
+	}
+	var (
+		summary = &tokenTransferSummary{Tokens: []common.Address{}}
+		flows   []tokenFlow
+		index   = make(map[flowKey]int)
+		tokens  = make(map[common.Address]bool)
+	)
+	add := func(address common.Address, standard string, token *common.Address, id *hexutil.Big, amount *big.Int) {
+		key := flowKey{address: address, standard: standard}
+		if token != nil {
+			key.token = *token
+		}
+		if id != nil {
+			key.id = common.BigToHash(id.ToInt())
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(flows)
+			index[key] = i
+			flows = append(flows, tokenFlow{Address: address, Standard: standard, Token: token, TokenID: id, Net: new(hexutil.Big)})
+		}
+		net := flows[i].Net.ToInt()
+		net.Add(net, amount)
+	}
+	for _, tr := range t.result.Transfers {
+		var (
+			token  = tr.Token
+			id     *hexutil.Big
+			amount = big.NewInt(1) // ERC721 tokens are counted
+		)
+		if tr.Standard == tokenERC1155 {
+			id = tr.TokenID
+		}
+		if tr.Value != nil {
+			amount = tr.Value.ToInt()
+		}
+		add(tr.From, tr.Standard, &token, id, new(big.Int).Neg(amount))
+		add(tr.To, tr.Standard, &token, id, amount)
+		if !tokens[token] {
+			tokens[token] = true
+			summary.Tokens = append(summary.Tokens, token)
+		}
+		summary.Hops++
+	}
+	for _, tr := range t.result.EthTransfers {
+		add(tr.From, "", nil, nil, new(big.Int).Neg(tr.Value.ToInt()))
+		add(tr.To, "", nil, nil, tr.Value.ToInt())
+		summary.Hops++
+	}
+	summary.Flows = make([]tokenFlow, 0, len(flows))
+	for _, flow := range flows {
+		if flow.Net.ToInt().Sign() != 0 {
+			summary.Flows = append(summary.Flows, flow)
+		}
+	}
+	return summary
+}
+
+// GetResult returns the json-encoded token transfers, approvals and ether
+// transfers of the transaction, and any error arising from the encoding or
+// forceful termination (via `Stop`).
+func (t *tokenTransferTracer) GetResult() (json.RawMessage, error) {
+	if t.config.WithSummary {
+		t.result.Summary = t.summary()
+	}
+	res, err := json.Marshal(t.result)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(res), t.reason
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *tokenTransferTracer) Stop(err error) {
+	t.reason = err
+	t.interrupt.Store(true)
+}
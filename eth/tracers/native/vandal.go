@@ -0,0 +1,254 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package native
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/holiman/uint256"
+)
+
+func init() {
+	tracers.DefaultDirectory.Register("vandalTracer", newVandalTracer, false)
+}
+
+// vandalTracer exposes the Vandal logger through the standard tracer interface,
+// so that the basic blocks of a transaction can be retrieved over the debug
+// API, e.g.
+//
+//	> debug.traceTransaction("0x214e...", {tracer: "vandalTracer"})
+//	[{"Entry":0,"Exit":12,"Ops":[{"Pc":0,"Op":96,...}],"Address":"0x..."}, ...]
+//
+// The blocks of failed call frames are marked as "Failed", the last block of
+// such a frame also holding its "Fault": the error, the decoded revert reason
+// if any, and the pc, opcode and remaining gas of the faulting opcode. Opcodes
+// writing to memory hold the range written as their "Memory" if recorded, e.g.
+// {"Offset":64,"Size":32,"Hash":"0x..."}.
+//
+// The tracer accepts the following configuration, all fields being optional,
+// which the vandal APIs accept as their tracerConfig too:
+//
+//	{
+//	  "opLimit": 100000,              // Maximum number of steps traced, 0 = unlimited
+//	  "memoryLimit": 67108864,        // Maximum memory in bytes of the trace, 0 = unlimited
+//	  "onlyAddresses": ["0x..."],     // Contracts whose code is traced, all if empty
+//	  "includeReturnData": true,      // Capture the output of every step as its Ret
+//	  "disableValueCapture": true,    // Leave the Value of calls null
+//	  "includeOperands": true,        // Capture the stack items consumed by every step
+//	  "includeEdges": true,           // Return the jumps taken along with the blocks
+//	  "includeStorage": true,         // Record the slots read and written by every block
+//	  "includeContracts": true,       // Return the code executed along with the blocks
+//	  "includeMemory": true,          // Record the memory written by every step
+//	  "includeMemoryData": true,      // Include the bytes written, not only their hash
+//	  "partialOnStop": true,          // Return the steps traced so far on timeout
+//	  "format": "facts"               // Return Souffle facts or protobuf rather than blocks
+//	}
+//
+// A trace exceeding the step limit is not discarded: the steps traced so far
+// are returned as {"blocks": [...], "truncated": true, "steps": <n>}, as are
+// those of a trace timing out with partialOnStop. With edges,
+// the result is {"blocks": [...], "edges": [{"Step":..,"Address":..,"From":..,"To":..}]}
+// likewise, and with contracts {"blocks": [...], "contracts": [{"Address":..,
+// "CodeHash":..,"Code":..,"Init":..}]}, listing the code executed at every
+// address once, creations listing their init code. In the
+// facts format, the result is an object of the tab separated contents of the
+// Datalog facts files of the trace by file name, e.g. {"op.facts": "0\t0\t0\tPUSH1..."}.
+// In the protobuf format, the result is the base64 string of the Trace message
+// of eth/tracers/logger/vandal.proto.
+//
+// The Vandal logger attached to the EVM records every opcode once executed, so
+// calls and creations follow the opcodes of the frames they spawn. Steps are
+// reported to tracers before being executed instead, so the tracer holds back
+// the last step of every frame until the frame moves on, preserving the order.
+// The output of an opcode is not known to tracers either: the debug API thus
+// attaches the tracer's logger to the EVM directly, and elsewhere the Ret
+// fields are null, except for SLOAD whose value is read from the state when
+// storage is recorded.
+type vandalTracer struct {
+	noopTracer
+	env      *vm.EVM
+	logger   *logger.VandalLogger
+	pending  []vandalStep // Last step of every active frame, by depth
+	frames   int          // Number of active frames
+	operands bool         // Whether the operands of steps are captured
+	storage  bool         // Whether storage accesses are recorded
+	memory   bool         // Whether memory writes are recorded
+}
+
+// vandalStep is a step of execution not yet fed to the Vandal logger.
+type vandalStep struct {
+	pc       uint64
+	op       vm.OpCode
+	gas      uint64
+	cost     uint64
+	operands []uint256.Int // Consumed stack items, topmost last, if captured
+	ret      []byte        // Output of the step, if known
+	memory   *vm.Memory    // Memory of the frame, if the step writes to it
+}
+
+// newVandalTracer returns a native go tracer which splits the executed opcodes
+// of a transaction into basic blocks, and implements vm.EVMLogger.
+func newVandalTracer(ctx *tracers.Context, cfg json.RawMessage) (tracers.Tracer, error) {
+	config, err := logger.ParseVandalConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &vandalTracer{logger: logger.NewVandalTracer(config), operands: config.EnableOperands, storage: config.EnableStorage, memory: config.EnableMemory}, nil
+}
+
+// VandalLogger returns the logger producing the trace, for attaching it to the
+// EVM directly rather than through the tracer.
+func (t *vandalTracer) VandalLogger() vm.VandalLogger {
+	return t.logger
+}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (t *vandalTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env, t.frames = env, 1
+	t.logger.CaptureStart(env, from, to, create, input, gas, value)
+}
+
+// CaptureState implements the EVMLogger interface to trace a single step of VM execution.
+func (t *vandalTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	// Opcodes failing before execution, e.g. on a stack underflow or out of
+	// gas, are not part of a Vandal trace, but fault their frame
+	if err != nil {
+		t.logger.CaptureFault(pc, op, gas, cost, scope, depth, err)
+		return
+	}
+	// Steps of this frame and deeper ones preceding this one have been executed
+	t.flush(depth - 1)
+
+	step := vandalStep{pc: pc, op: op, gas: gas, cost: cost}
+	storage := t.storage && (op == vm.SLOAD || op == vm.SSTORE)
+	if kind := logger.GetKind(op); t.memory && (kind == logger.OpKindThreeStoreOne || kind == logger.OpKindThreeStoreTwo) {
+		step.memory = scope.Memory
+	}
+	if t.operands || storage || step.memory != nil {
+		stack := scope.Stack.Data()
+		if n := vandalStackInputs(op); n <= len(stack) {
+			step.operands = append([]uint256.Int(nil), stack[len(stack)-n:]...)
+		}
+	}
+	// SLOAD loads the slot's current value, read it ahead of the opcode
+	if storage && op == vm.SLOAD && len(step.operands) == 1 {
+		value := t.env.StateDB.GetState(scope.Contract.Address(), step.operands[0].Bytes32())
+		step.ret = new(uint256.Int).SetBytes32(value[:]).Bytes()
+	}
+	t.pending = append(t.pending, step)
+}
+
+// CaptureFault implements the EVMLogger interface to trace an execution fault.
+func (t *vandalTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	// The opcode failed during its execution, so it wrote nothing to memory
+	if depth <= len(t.pending) {
+		t.pending[depth-1].memory = nil
+	}
+	t.logger.CaptureFault(pc, op, gas, cost, scope, depth, err)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *vandalTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	t.flush(0)
+	t.logger.CaptureEnd(output, gasUsed, err)
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *vandalTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	t.frames++
+	t.logger.CaptureEnter(typ, from, to, input, gas, value)
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *vandalTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	// The steps of the exited frame precede its exit
+	t.flush(t.frames - 1)
+	t.frames--
+	t.logger.CaptureExit(output, gasUsed, err)
+}
+
+// flush feeds the held back steps of the frames deeper than the given depth to
+// the logger, deepest first.
+func (t *vandalTracer) flush(depth int) {
+	for len(t.pending) > depth {
+		step := t.pending[len(t.pending)-1]
+		if t.operands || step.operands != nil {
+			t.logger.CaptureOperands(step.op, step.operands)
+		}
+		// The memory of the frame holds the step's writes until it moves on
+		if step.memory != nil {
+			t.logger.CaptureMemory(step.op, step.memory.Data())
+		}
+		t.logger.CaptureState(step.pc, step.op, step.gas, step.cost, step.ret)
+		t.pending = t.pending[:len(t.pending)-1]
+	}
+}
+
+// CaptureTxStart implements the EVMLogger interface to prepare the trace of a
+// transaction.
+func (t *vandalTracer) CaptureTxStart(gasLimit uint64) {
+	t.logger.CaptureTxStart(gasLimit)
+}
+
+// GetResult returns the basic blocks of the transaction.
+func (t *vandalTracer) GetResult() (json.RawMessage, error) {
+	return t.logger.GetResult()
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *vandalTracer) Stop(err error) {
+	t.logger.Stop(err)
+}
+
+// vandalStackInputs returns the number of stack items consumed by an opcode.
+func vandalStackInputs(op vm.OpCode) int {
+	switch {
+	case op >= vm.PUSH0 && op <= vm.PUSH32:
+		return 0
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		return int(op-vm.DUP1) + 1
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		return int(op-vm.SWAP1) + 2
+	case op >= vm.LOG0 && op <= vm.LOG4:
+		return int(op-vm.LOG0) + 2
+	}
+	switch op {
+	case vm.ISZERO, vm.NOT, vm.BALANCE, vm.CALLDATALOAD, vm.EXTCODESIZE, vm.EXTCODEHASH,
+		vm.BLOCKHASH, vm.BLOBHASH, vm.POP, vm.MLOAD, vm.SLOAD, vm.JUMP, vm.TLOAD, vm.SELFDESTRUCT:
+		return 1
+	case vm.ADD, vm.MUL, vm.SUB, vm.DIV, vm.SDIV, vm.MOD, vm.SMOD, vm.EXP, vm.SIGNEXTEND,
+		vm.LT, vm.GT, vm.SLT, vm.SGT, vm.EQ, vm.AND, vm.OR, vm.XOR, vm.BYTE, vm.SHL, vm.SHR, vm.SAR,
+		vm.KECCAK256, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.JUMPI, vm.TSTORE, vm.RETURN, vm.REVERT:
+		return 2
+	case vm.ADDMOD, vm.MULMOD, vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY, vm.MCOPY, vm.CREATE:
+		return 3
+	case vm.EXTCODECOPY, vm.CREATE2:
+		return 4
+	case vm.DELEGATECALL, vm.STATICCALL:
+		return 6
+	case vm.CALL, vm.CALLCODE:
+		return 7
+	default:
+		return 0
+	}
+}
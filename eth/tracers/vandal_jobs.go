@@ -0,0 +1,642 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Vandal trace job states.
+const (
+	VandalJobQueued    = "queued"
+	VandalJobRunning   = "running"
+	VandalJobDone      = "done"
+	VandalJobFailed    = "failed"
+	VandalJobCancelled = "cancelled"
+)
+
+var (
+	errJobNotFound = errors.New("trace job not found")
+	errJobsStopped = errors.New("trace job scheduler stopped")
+	errExportFile  = errors.New("export file must be a plain file name")
+)
+
+// VandalJobStatus is the progress report of a trace job.
+type VandalJobStatus struct {
+	ID           rpc.ID         `json:"id"`
+	State        string         `json:"state"`
+	From         hexutil.Uint64 `json:"from"`
+	To           hexutil.Uint64 `json:"to"`
+	Current      hexutil.Uint64 `json:"current"`        // Next block to trace
+	Blocks       hexutil.Uint64 `json:"blocks"`         // Number of blocks traced
+	Transactions hexutil.Uint64 `json:"transactions"`   // Number of transactions traced
+	Dir          string         `json:"dir,omitempty"`  // Output directory of a trace job
+	File         string         `json:"file,omitempty"` // Output file of an export job
+	Submitted    time.Time      `json:"submitted"`
+	Started      *time.Time     `json:"started,omitempty"`
+	Finished     *time.Time     `json:"finished,omitempty"`
+	ETA          string         `json:"eta,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// vandalJobsRetained is the number of finished jobs whose status is kept, older
+// ones being forgotten. The output of forgotten jobs is kept on disk.
+const vandalJobsRetained = 256
+
+// vandalJob is a block range traced asynchronously by the job scheduler.
+type vandalJob struct {
+	id       rpc.ID
+	from, to uint64
+	config   *TraceConfig
+	dir      string // Output directory, one file per block
+	file     string // Output file, one line per transaction, instead of dir if set
+	cancel   context.CancelFunc
+
+	lock      sync.Mutex
+	state     string
+	current   uint64
+	txs       uint64
+	submitted time.Time
+	started   time.Time
+	finished  time.Time
+	err       error
+}
+
+// status returns a snapshot of the job's progress.
+func (j *vandalJob) status() *VandalJobStatus {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	status := &VandalJobStatus{
+		ID:           j.id,
+		State:        j.state,
+		From:         hexutil.Uint64(j.from),
+		To:           hexutil.Uint64(j.to),
+		Current:      hexutil.Uint64(j.current),
+		Blocks:       hexutil.Uint64(j.current - j.from),
+		Transactions: hexutil.Uint64(j.txs),
+		Dir:          j.dir,
+		File:         j.file,
+		Submitted:    j.submitted,
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		status.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		status.Finished = &finished
+	}
+	if j.state == VandalJobRunning && j.current > j.from {
+		var (
+			done    = j.current - j.from
+			left    = j.to - j.current + 1
+			elapsed = time.Since(j.started)
+		)
+		status.ETA = (elapsed / time.Duration(done) * time.Duration(left)).Round(time.Second).String()
+	}
+	if j.err != nil {
+		status.Error = j.err.Error()
+	}
+	return status
+}
+
+// VandalJobs is a node service running block range trace requests as
+// background jobs. At most a configured number of jobs run concurrently,
+// the rest wait in submission order. Each job writes its results into its
+// own subdirectory, one file per block named <number>_<hash>.json, or for
+// export jobs into a single file, one JSON line per transaction.
+//
+// Remote clients are subject to the per-client limits of the Vandal tracing
+// APIs, every job holding a trace slot of its client until finished.
+type VandalJobs struct {
+	api      *VandalAPI
+	dir      string
+	slots    chan struct{}
+	workers  int // Blocks of a job traced concurrently
+	retained int // Finished jobs whose status is kept
+
+	lock     sync.Mutex
+	jobs     map[rpc.ID]*vandalJob
+	finished []rpc.ID // Finished jobs still kept, oldest first
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewVandalJobs creates a trace job scheduler writing into the given directory
+// and running at most concurrency jobs at the same time.
+func NewVandalJobs(backend Backend, config *VandalConfig, dir string, concurrency int) *VandalJobs {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	api := newVandalAPI(backend, config)
+
+	// Trace blocks concurrently if requested, but at most one per CPU
+	workers := 1
+	if config != nil && config.JobWorkers > 1 {
+		workers = config.JobWorkers
+	}
+	if workers > runtime.NumCPU() {
+		workers = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &VandalJobs{
+		api:      api,
+		dir:      dir,
+		slots:    make(chan struct{}, concurrency),
+		workers:  workers,
+		retained: vandalJobsRetained,
+		jobs:     make(map[rpc.ID]*vandalJob),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start implements node.Lifecycle.
+func (s *VandalJobs) Start() error {
+	return os.MkdirAll(s.dir, 0755)
+}
+
+// Stop implements node.Lifecycle, cancelling all queued and running jobs.
+func (s *VandalJobs) Stop() error {
+	s.lock.Lock()
+	s.cancel()
+	s.lock.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// APIs returns the RPC services to manage trace jobs.
+func (s *VandalJobs) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace:     "vandal",
+			Service:       &VandalJobsAPI{jobs: s},
+			Authenticated: s.api.authenticated(),
+		},
+	}
+}
+
+// submit schedules tracing the blocks in the range [from, to], writing them
+// into the job's own directory, or exporting them into the given file of the
+// scheduler's directory if set. The steps traced are charged to the meter of
+// the given request context, if any, and release is called once the job is
+// finished.
+func (s *VandalJobs) submit(req context.Context, from, to uint64, file string, config *TraceConfig, release func()) (*vandalJob, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ctx.Err() != nil {
+		return nil, errJobsStopped
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	if meter, ok := req.Value(vandalMeterKey{}).(*vandalMeter); ok {
+		ctx = context.WithValue(ctx, vandalMeterKey{}, meter)
+	}
+	job := &vandalJob{
+		id:        rpc.NewID(),
+		from:      from,
+		to:        to,
+		config:    config,
+		cancel:    cancel,
+		state:     VandalJobQueued,
+		current:   from,
+		submitted: time.Now(),
+	}
+	if file != "" {
+		job.file = filepath.Join(s.dir, file)
+	} else {
+		job.dir = filepath.Join(s.dir, string(job.id))
+	}
+	s.jobs[job.id] = job
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer release()
+		defer cancel()
+		s.run(ctx, job)
+	}()
+	log.Info("Submitted Vandal trace job", "id", job.id, "from", from, "to", to)
+	return job, nil
+}
+
+// run waits for a free slot and traces the job's block range.
+func (s *VandalJobs) run(ctx context.Context, job *vandalJob) {
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		s.finish(job, ctx.Err())
+		return
+	}
+	job.lock.Lock()
+	job.state, job.started = VandalJobRunning, time.Now()
+	job.lock.Unlock()
+
+	s.finish(job, s.trace(ctx, job))
+}
+
+// trace traces the job's block range, writing out the results block by block.
+func (s *VandalJobs) trace(ctx context.Context, job *vandalJob) (err error) {
+	var export *bufio.Writer
+	if job.file != "" {
+		// Refuse to overwrite existing files, e.g. from a previous export
+		f, err := os.OpenFile(job.file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		export = bufio.NewWriter(f)
+		defer func() {
+			if ferr := export.Flush(); err == nil {
+				err = ferr
+			}
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	} else if err := os.MkdirAll(job.dir, 0755); err != nil {
+		return err
+	}
+	// Encode the traces in the background if requested
+	var enc *logger.VandalEncoder
+	if s.api.defaults != nil && s.api.defaults.Pipeline {
+		enc = logger.NewVandalEncoder(vandalPipelineDepth)
+		defer enc.Close()
+	}
+	return s.traceBlocks(ctx, job, enc, func(trace *vandalBlockTrace) error {
+		return s.write(ctx, job, export, trace)
+	})
+}
+
+// vandalPipelineDepth is the number of transaction traces queued for encoding
+// in pipelined trace jobs before execution waits for the encoder.
+const vandalPipelineDepth = 16
+
+// vandalBlockBuffer is the number of transaction traces of a block buffered
+// before its tracing waits for them to be written out.
+const vandalBlockBuffer = 4
+
+// vandalBlockTrace is a block being traced, whose transaction traces are
+// streamed as they are produced.
+type vandalBlockTrace struct {
+	block *types.Block
+	txs   chan *vandalTxTrace // Transaction traces in order, closed once done
+	err   error               // Tracing failure, set before txs is closed
+}
+
+// vandalBlockTask is a block of a trace job assigned to a worker.
+type vandalBlockTask struct {
+	number uint64
+	trace  *vandalBlockTrace
+	err    error
+	done   chan struct{} // Closed when the block starts being traced
+}
+
+// traceBlocks traces the job's block range with the scheduler's number of
+// workers tracing blocks concurrently, handing the traced blocks over to
+// handle in order. Workers stall once they buffered a few transaction traces
+// of a block that is not yet being handled, bounding the memory used.
+func (s *VandalJobs) traceBlocks(ctx context.Context, job *vandalJob, enc *logger.VandalEncoder, handle func(*vandalBlockTrace) error) error {
+	var (
+		tasks = make(chan *vandalBlockTask)
+		order = make(chan *vandalBlockTask, s.workers)
+		wg    sync.WaitGroup
+	)
+	workCtx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				block, err := s.api.api.blockByNumber(workCtx, rpc.BlockNumber(task.number))
+				if err != nil {
+					task.err = err
+					close(task.done)
+					continue
+				}
+				trace := &vandalBlockTrace{block: block, txs: make(chan *vandalTxTrace, vandalBlockBuffer)}
+				task.trace = trace
+				close(task.done)
+
+				trace.err = s.api.traceBlockTxs(workCtx, block, job.config, enc, func(tx *vandalTxTrace) error {
+					select {
+					case trace.txs <- tx:
+						return nil
+					case <-workCtx.Done():
+						return workCtx.Err()
+					}
+				})
+				close(trace.txs)
+			}
+		}()
+	}
+	// Feed the blocks to the workers, recording the order they must be handled in
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(tasks)
+		defer close(order)
+
+		for number := job.from; number <= job.to; number++ {
+			task := &vandalBlockTask{number: number, done: make(chan struct{})}
+			select {
+			case order <- task:
+			case <-workCtx.Done():
+				return
+			}
+			select {
+			case tasks <- task:
+			case <-workCtx.Done():
+				return
+			}
+		}
+	}()
+	for task := range order {
+		select {
+		case <-task.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if task.err != nil {
+			// Report cancellation rather than the failure it caused
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("block #%d: %w", task.number, task.err)
+		}
+		if err := handle(task.trace); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// write writes out the transaction traces of a block as they are produced,
+// recording the job's progress once the block is complete. Transactions which
+// failed to trace are written out with their error, only failures to write
+// and cancellation failing the job.
+func (s *VandalJobs) write(ctx context.Context, job *vandalJob, export *bufio.Writer, trace *vandalBlockTrace) error {
+	var (
+		block = trace.block
+		file  *vandalBlockFile
+		txs   int
+		err   error
+	)
+	cfg, err := job.config.vandalLoggerConfig()
+	if err != nil {
+		return err
+	}
+	meta := NewVandalTraceMeta(s.api.api.backend.ChainConfig(), block.Header(), cfg)
+	if export == nil {
+		if file, err = createVandalBlockFile(job.dir, block, meta); err != nil {
+			return err
+		}
+	}
+	put := func(result *txTraceResult) error {
+		if export != nil {
+			return exportVandalTx(export, block, result, meta)
+		}
+		return file.write(result)
+	}
+	// Abandoning the traces on failure is fine, the worker is stopped along
+	// with the job
+	for tx := range trace.txs {
+		if err = put(tx.result()); err != nil {
+			break
+		}
+		txs++
+	}
+	if err == nil && trace.err != nil {
+		switch {
+		case ctx.Err() != nil:
+			// Report cancellation rather than the tracing error it caused
+			err = ctx.Err()
+		case vandalRequestFailed(ctx, trace.err):
+			err = fmt.Errorf("block #%d: %w", block.NumberU64(), trace.err)
+		default:
+			// The rest of the block couldn't be traced, e.g. for lack of state
+			for _, tx := range block.Transactions()[txs:] {
+				if err = put(&txTraceResult{TxHash: tx.Hash(), Error: trace.err.Error()}); err != nil {
+					break
+				}
+				txs++
+			}
+		}
+	}
+	if file != nil {
+		if err != nil {
+			file.abort()
+		} else {
+			err = file.close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	job.lock.Lock()
+	job.current, job.txs = block.NumberU64()+1, job.txs+uint64(txs)
+	job.lock.Unlock()
+	return nil
+}
+
+// exportVandalTx writes the trace of a transaction as a JSON line, tagged with
+// the block it was included in and stamped with the given metadata.
+func exportVandalTx(w io.Writer, block *types.Block, res *txTraceResult, meta *VandalTraceMeta) error {
+	return json.NewEncoder(w).Encode(&vandalTxTraceResult{
+		Block:  hexutil.Uint64(block.NumberU64()),
+		Hash:   block.Hash(),
+		TxHash: res.TxHash,
+		Result: res.Result,
+		Error:  res.Error,
+		Meta:   meta,
+	})
+}
+
+// finish records the outcome of a job.
+func (s *VandalJobs) finish(job *vandalJob, err error) {
+	s.retire(job.id)
+
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	job.finished = time.Now()
+	switch {
+	case err == nil:
+		job.state = VandalJobDone
+		log.Info("Finished Vandal trace job", "id", job.id, "transactions", job.txs, "elapsed", common.PrettyDuration(job.finished.Sub(job.started)))
+	case errors.Is(err, context.Canceled):
+		job.state = VandalJobCancelled
+		log.Info("Cancelled Vandal trace job", "id", job.id, "current", job.current)
+	default:
+		job.state, job.err = VandalJobFailed, err
+		log.Warn("Vandal trace job failed", "id", job.id, "current", job.current, "err", err)
+	}
+}
+
+// retire records a finished job, forgetting the oldest finished ones beyond
+// the number retained.
+func (s *VandalJobs) retire(id rpc.ID) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.finished = append(s.finished, id)
+	for len(s.finished) > s.retained {
+		delete(s.jobs, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+}
+
+// job retrieves a submitted job by id.
+func (s *VandalJobs) job(id rpc.ID) *vandalJob {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.jobs[id]
+}
+
+// VandalJobsAPI offers the RPC methods to submit, monitor and cancel Vandal
+// trace jobs.
+type VandalJobsAPI struct {
+	jobs *VandalJobs
+}
+
+// SubmitTraceJob schedules tracing all transactions of the blocks between
+// start and end (both included) in the background, returning the id of the
+// job to query its progress with.
+func (api *VandalJobsAPI) SubmitTraceJob(ctx context.Context, start, end rpc.BlockNumber, config *TraceConfig) (rpc.ID, error) {
+	return api.submit(ctx, start, end, "", config)
+}
+
+// ExportTraceRange schedules tracing all transactions of the blocks between
+// start and end (both included) in the background like SubmitTraceJob, but
+// writes the results into a single file of the node's job directory as JSON
+// lines, one transaction per line. The file must not exist yet.
+func (api *VandalJobsAPI) ExportTraceRange(ctx context.Context, start, end rpc.BlockNumber, file string, config *TraceConfig) (rpc.ID, error) {
+	if file == "" || file != filepath.Base(file) || file == "." || file == ".." {
+		return "", errExportFile
+	}
+	if _, err := os.Stat(filepath.Join(api.jobs.dir, file)); err == nil {
+		return "", fmt.Errorf("export file %s already exists", file)
+	}
+	return api.submit(ctx, start, end, file, config)
+}
+
+// submit validates the requested block range and schedules the job.
+func (api *VandalJobsAPI) submit(ctx context.Context, start, end rpc.BlockNumber, file string, config *TraceConfig) (rpc.ID, error) {
+	from, err := api.jobs.api.api.blockByNumber(ctx, start)
+	if err != nil {
+		return "", err
+	}
+	to, err := api.jobs.api.api.blockByNumber(ctx, end)
+	if err != nil {
+		return "", err
+	}
+	if from.NumberU64() == 0 {
+		return "", errors.New("genesis is not traceable")
+	}
+	if from.NumberU64() > to.NumberU64() {
+		return "", fmt.Errorf("end block (#%d) needs to come after start block (#%d)", end, start)
+	}
+	// Reject invalid trace options upfront rather than failing the job
+	config = api.jobs.api.traceConfig(config)
+	if _, err := config.vandalLoggerConfig(); err != nil {
+		return "", err
+	}
+	ctx, release, err := api.jobs.api.limit(ctx)
+	if err != nil {
+		return "", err
+	}
+	job, err := api.jobs.submit(ctx, from.NumberU64(), to.NumberU64(), file, config, release)
+	if err != nil {
+		release()
+		return "", err
+	}
+	return job.id, nil
+}
+
+// TraceJobStatus returns the progress of the given trace job.
+func (api *VandalJobsAPI) TraceJobStatus(id rpc.ID) (*VandalJobStatus, error) {
+	job := api.jobs.job(id)
+	if job == nil {
+		return nil, errJobNotFound
+	}
+	return job.status(), nil
+}
+
+// TraceJobs returns the progress of the trace jobs submitted since the node
+// was started, in submission order. Only the most recently finished jobs are
+// kept, along with all queued and running ones.
+func (api *VandalJobsAPI) TraceJobs() []*VandalJobStatus {
+	api.jobs.lock.Lock()
+	jobs := make([]*vandalJob, 0, len(api.jobs.jobs))
+	for _, job := range api.jobs.jobs {
+		jobs = append(jobs, job)
+	}
+	api.jobs.lock.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].submitted.Before(jobs[j].submitted)
+	})
+	statuses := make([]*VandalJobStatus, len(jobs))
+	for i, job := range jobs {
+		statuses[i] = job.status()
+	}
+	return statuses
+}
+
+// CancelTraceJob aborts the given trace job. Blocks already traced are kept
+// on disk. It returns false if the job had already finished.
+func (api *VandalJobsAPI) CancelTraceJob(id rpc.ID) (bool, error) {
+	job := api.jobs.job(id)
+	if job == nil {
+		return false, errJobNotFound
+	}
+	job.lock.Lock()
+	active := job.state == VandalJobQueued || job.state == VandalJobRunning
+	job.lock.Unlock()
+
+	if active {
+		job.cancel()
+	}
+	return active, nil
+}
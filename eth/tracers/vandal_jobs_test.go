@@ -0,0 +1,354 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestVandalJobs(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, _ := newVandalTestBackend(t, 5)
+	defer backend.teardown()
+
+	jobs := NewVandalJobs(backend, nil, t.TempDir(), 1)
+	if err := jobs.Start(); err != nil {
+		t.Fatalf("failed to start job scheduler: %v", err)
+	}
+	defer jobs.Stop()
+	api := &VandalJobsAPI{jobs: jobs}
+
+	id, err := api.SubmitTraceJob(context.Background(), rpc.BlockNumber(2), rpc.BlockNumber(4), nil)
+	if err != nil {
+		t.Fatalf("failed to submit job: %v", err)
+	}
+	status := waitVandalJob(t, api, id)
+	if status.State != VandalJobDone || status.Error != "" {
+		t.Fatalf("job failed: %+v", status)
+	}
+	if status.Blocks != 3 || status.Transactions != 3 || status.Current != 5 {
+		t.Fatalf("unexpected job progress: %+v", status)
+	}
+	files, err := os.ReadDir(status.Dir)
+	if err != nil {
+		t.Fatalf("failed to read job output: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("unexpected number of block files: have %d, want 3", len(files))
+	}
+	// Finished jobs can't be cancelled but remain listed
+	if cancelled, err := api.CancelTraceJob(id); err != nil || cancelled {
+		t.Fatalf("unexpected cancellation of finished job: %v %v", cancelled, err)
+	}
+	if all := api.TraceJobs(); len(all) != 1 || all[0].ID != id {
+		t.Fatalf("unexpected job list: %v", all)
+	}
+	// Unknown jobs and invalid ranges are rejected
+	if _, err := api.TraceJobStatus(rpc.ID("0x00")); !errors.Is(err, errJobNotFound) {
+		t.Fatalf("want %v, have %v", errJobNotFound, err)
+	}
+	if _, err := api.SubmitTraceJob(context.Background(), rpc.BlockNumber(0), rpc.BlockNumber(2), nil); err == nil {
+		t.Fatal("expected error tracing genesis")
+	}
+	if _, err := api.SubmitTraceJob(context.Background(), rpc.BlockNumber(3), rpc.BlockNumber(2), nil); err == nil {
+		t.Fatal("expected error for inverted range")
+	}
+}
+
+func TestVandalExportRange(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, hashes := newVandalTestBackend(t, 4)
+	defer backend.teardown()
+
+	jobs := NewVandalJobs(backend, nil, t.TempDir(), 1)
+	if err := jobs.Start(); err != nil {
+		t.Fatalf("failed to start job scheduler: %v", err)
+	}
+	defer jobs.Stop()
+	api := &VandalJobsAPI{jobs: jobs}
+
+	for _, file := range []string{"", ".", "../out.jsonl", "dir/out.jsonl"} {
+		if _, err := api.ExportTraceRange(context.Background(), rpc.BlockNumber(1), rpc.BlockNumber(4), file, nil); !errors.Is(err, errExportFile) {
+			t.Fatalf("file %q: want %v, have %v", file, errExportFile, err)
+		}
+	}
+	id, err := api.ExportTraceRange(context.Background(), rpc.BlockNumber(2), rpc.BlockNumber(4), "out.jsonl", nil)
+	if err != nil {
+		t.Fatalf("failed to submit export: %v", err)
+	}
+	status := waitVandalJob(t, api, id)
+	if status.State != VandalJobDone || status.File == "" || status.Dir != "" {
+		t.Fatalf("export failed: %+v", status)
+	}
+	blob, err := os.ReadFile(status.File)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(blob)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected number of exported transactions: have %d, want 3", len(lines))
+	}
+	for i, line := range lines {
+		var res vandalTxTraceResult
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			t.Fatalf("failed to decode line %d: %v", i, err)
+		}
+		if uint64(res.Block) != uint64(i+2) || res.TxHash != hashes[i+1] || res.Result == nil {
+			t.Fatalf("unexpected export line %d: %s", i, line)
+		}
+		if res.Meta == nil || res.Meta.Client == "" || res.Meta.Time.IsZero() {
+			t.Fatalf("export line %d not stamped: %s", i, line)
+		}
+	}
+	// Existing files are not overwritten
+	if _, err := api.ExportTraceRange(context.Background(), rpc.BlockNumber(2), rpc.BlockNumber(4), "out.jsonl", nil); err == nil {
+		t.Fatal("expected error exporting into existing file")
+	}
+}
+
+// Tests that a transaction failing to trace is written out with its error,
+// rather than failing the whole job.
+func TestVandalJobsTxFailure(t *testing.T) {
+	t.Parallel()
+
+	backend, hashes := newVandalLoopBackend(t)
+	defer backend.teardown()
+
+	jobs := NewVandalJobs(backend, &VandalConfig{MaxTraceMemory: 1 << 16}, t.TempDir(), 1)
+	if err := jobs.Start(); err != nil {
+		t.Fatalf("failed to start job scheduler: %v", err)
+	}
+	defer jobs.Stop()
+	api := &VandalJobsAPI{jobs: jobs}
+
+	for _, file := range []string{"", "out.jsonl"} {
+		var (
+			id  rpc.ID
+			err error
+		)
+		if file == "" {
+			id, err = api.SubmitTraceJob(context.Background(), rpc.BlockNumber(1), rpc.BlockNumber(1), nil)
+		} else {
+			id, err = api.ExportTraceRange(context.Background(), rpc.BlockNumber(1), rpc.BlockNumber(1), file, nil)
+		}
+		if err != nil {
+			t.Fatalf("file %q: failed to submit job: %v", file, err)
+		}
+		status := waitVandalJob(t, api, id)
+		if status.State != VandalJobDone || status.Transactions != 3 {
+			t.Fatalf("file %q: job failed: %+v", file, status)
+		}
+		var results []*vandalTxTraceResult
+		if file == "" {
+			files, err := os.ReadDir(status.Dir)
+			if err != nil || len(files) != 1 {
+				t.Fatalf("unexpected job output: %v %v", files, err)
+			}
+			blob, err := os.ReadFile(filepath.Join(status.Dir, files[0].Name()))
+			if err != nil {
+				t.Fatalf("failed to read block file: %v", err)
+			}
+			if err := json.Unmarshal(blob, &results); err != nil {
+				t.Fatalf("failed to decode block file: %v", err)
+			}
+		} else {
+			blob, err := os.ReadFile(status.File)
+			if err != nil {
+				t.Fatalf("failed to read export: %v", err)
+			}
+			for _, line := range strings.Split(strings.TrimSpace(string(blob)), "\n") {
+				var res vandalTxTraceResult
+				if err := json.Unmarshal([]byte(line), &res); err != nil {
+					t.Fatalf("failed to decode export line: %v", err)
+				}
+				results = append(results, &res)
+			}
+		}
+		if len(results) != len(hashes) {
+			t.Fatalf("file %q: result count mismatch: have %d, want %d", file, len(results), len(hashes))
+		}
+		for i, res := range results {
+			if res.TxHash != hashes[i] {
+				t.Fatalf("file %q: result %d: transaction mismatch: have %x, want %x", file, i, res.TxHash, hashes[i])
+			}
+			if failed := i == 1; failed != (res.Error != "") || failed == (res.Result != nil) {
+				t.Fatalf("file %q: result %d: unexpected outcome: result %v, error %q", file, i, res.Result != nil, res.Error)
+			}
+		}
+	}
+}
+
+// Tests that jobs submitted by remote clients are subject to the per-client
+// limits, the steps they trace being charged to their client.
+func TestVandalJobsLimit(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, _ := newVandalTestBackend(t, 2)
+	defer backend.teardown()
+
+	jobs := NewVandalJobs(backend, &VandalConfig{MaxStepsPerMinute: 1}, t.TempDir(), 1)
+	if err := jobs.Start(); err != nil {
+		t.Fatalf("failed to start job scheduler: %v", err)
+	}
+	defer jobs.Stop()
+	api := &VandalJobsAPI{jobs: jobs}
+
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("vandal", api); err != nil {
+		t.Fatalf("failed to register API: %v", err)
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := rpc.DialHTTP(httpServer.URL)
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer client.Close()
+
+	// The first job exceeds the budget, which rejects further jobs
+	var id rpc.ID
+	if err := client.Call(&id, "vandal_submitTraceJob", rpc.BlockNumber(1), rpc.BlockNumber(2)); err != nil {
+		t.Fatalf("failed to submit job: %v", err)
+	}
+	status := waitVandalJob(t, api, id)
+	if status.State != VandalJobFailed || !strings.Contains(status.Error, errStepBudgetSpent.Error()) {
+		t.Fatalf("unexpected job outcome: %+v", status)
+	}
+	err = client.Call(&id, "vandal_submitTraceJob", rpc.BlockNumber(1), rpc.BlockNumber(2))
+	if err == nil || err.Error() != errStepBudgetSpent.Error() {
+		t.Fatalf("want %v, have %v", errStepBudgetSpent, err)
+	}
+	// Local submissions are exempt
+	if _, err := api.SubmitTraceJob(context.Background(), rpc.BlockNumber(1), rpc.BlockNumber(2), nil); err != nil {
+		t.Fatalf("failed to submit local job: %v", err)
+	}
+}
+
+// Tests that only a bounded number of finished jobs are kept.
+func TestVandalJobsRetained(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, _ := newVandalTestBackend(t, 1)
+	defer backend.teardown()
+
+	jobs := NewVandalJobs(backend, nil, t.TempDir(), 1)
+	jobs.retained = 2
+	if err := jobs.Start(); err != nil {
+		t.Fatalf("failed to start job scheduler: %v", err)
+	}
+	defer jobs.Stop()
+	api := &VandalJobsAPI{jobs: jobs}
+
+	var ids []rpc.ID
+	for i := 0; i < 3; i++ {
+		id, err := api.SubmitTraceJob(context.Background(), rpc.BlockNumber(1), rpc.BlockNumber(1), nil)
+		if err != nil {
+			t.Fatalf("failed to submit job %d: %v", i, err)
+		}
+		waitVandalJob(t, api, id)
+		ids = append(ids, id)
+	}
+	if _, err := api.TraceJobStatus(ids[0]); !errors.Is(err, errJobNotFound) {
+		t.Fatalf("want %v, have %v", errJobNotFound, err)
+	}
+	if all := api.TraceJobs(); len(all) != 2 || all[0].ID != ids[1] || all[1].ID != ids[2] {
+		t.Fatalf("unexpected job list: %v", all)
+	}
+}
+
+// waitVandalJob waits for the given job to finish and returns its status.
+func waitVandalJob(t *testing.T, api *VandalJobsAPI, id rpc.ID) *VandalJobStatus {
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		status, err := api.TraceJobStatus(id)
+		if err != nil {
+			t.Fatalf("failed to retrieve job status: %v", err)
+		}
+		if status.State != VandalJobQueued && status.State != VandalJobRunning {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish in time: %+v", status)
+		}
+	}
+}
+
+// vandalMetaTime matches the capture time of stored traces.
+var vandalMetaTime = regexp.MustCompile(`,"time":"[^"]*"`)
+
+func TestVandalJobsParallel(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, _ := newVandalTestBackend(t, 6)
+	defer backend.teardown()
+
+	// Export the same range serially, pipelined and with concurrent workers
+	var (
+		configs = []*VandalConfig{
+			{},
+			{Pipeline: true},
+			{JobWorkers: 3},
+			{JobWorkers: 3, Pipeline: true},
+		}
+		exports [][]byte
+	)
+	for _, config := range configs {
+		jobs := NewVandalJobs(backend, config, t.TempDir(), 1)
+		if config.JobWorkers > 0 {
+			jobs.workers = config.JobWorkers // Bypass the CPU cap
+		}
+		if err := jobs.Start(); err != nil {
+			t.Fatalf("failed to start job scheduler: %v", err)
+		}
+		defer jobs.Stop()
+		api := &VandalJobsAPI{jobs: jobs}
+
+		id, err := api.ExportTraceRange(context.Background(), rpc.BlockNumber(1), rpc.BlockNumber(6), "out.jsonl", nil)
+		if err != nil {
+			t.Fatalf("failed to submit export: %v", err)
+		}
+		status := waitVandalJob(t, api, id)
+		if status.State != VandalJobDone || status.Blocks != 6 || status.Transactions != 6 {
+			t.Fatalf("config %+v: export failed: %+v", config, status)
+		}
+		blob, err := os.ReadFile(status.File)
+		if err != nil {
+			t.Fatalf("failed to read export: %v", err)
+		}
+		// Capture times naturally differ between the exports
+		exports = append(exports, vandalMetaTime.ReplaceAll(blob, nil))
+	}
+	for i := 1; i < len(exports); i++ {
+		if !bytes.Equal(exports[i], exports[0]) {
+			t.Fatalf("config %+v: export mismatch:\nhave %s\nwant %s", configs[i], exports[i], exports[0])
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// vandalLimitWindow is the period over which the traced steps of a client are
+// accounted against its budget.
+const vandalLimitWindow = time.Minute
+
+var (
+	errTooManyTraces   = errors.New("too many concurrent vandal traces")
+	errStepBudgetSpent = errors.New("vandal tracing step budget exhausted, retry later")
+)
+
+// vandalLimiter enforces per-client limits on the Vandal tracing APIs: the
+// number of traces running at the same time and the number of steps (executed
+// opcodes) traced per minute.
+type vandalLimiter struct {
+	maxConcurrent int    // Maximum number of traces running at once, 0 = unlimited
+	maxSteps      uint64 // Maximum number of steps traced per window, 0 = unlimited
+	clock         mclock.Clock
+
+	lock    sync.Mutex
+	clients map[string]*vandalClient
+	swept   mclock.AbsTime
+}
+
+// vandalClient is the resource usage of a single client.
+type vandalClient struct {
+	active int            // Number of traces currently running
+	window mclock.AbsTime // Start of the current accounting window
+	steps  uint64         // Steps traced within the current window
+}
+
+func newVandalLimiter(maxConcurrent int, maxSteps uint64, clock mclock.Clock) *vandalLimiter {
+	return &vandalLimiter{
+		maxConcurrent: maxConcurrent,
+		maxSteps:      maxSteps,
+		clock:         clock,
+		clients:       make(map[string]*vandalClient),
+	}
+}
+
+// acquire reserves a trace slot for the given client, failing if it has too
+// many traces running already or has spent its step budget.
+func (l *vandalLimiter) acquire(client string) (*vandalMeter, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.clock.Now()
+	if time.Duration(now-l.swept) >= vandalLimitWindow {
+		l.sweep(now)
+	}
+	c := l.clients[client]
+	if c == nil {
+		c = &vandalClient{window: now}
+		l.clients[client] = c
+	}
+	if time.Duration(now-c.window) >= vandalLimitWindow {
+		c.window, c.steps = now, 0
+	}
+	if l.maxConcurrent > 0 && c.active >= l.maxConcurrent {
+		return nil, errTooManyTraces
+	}
+	if l.maxSteps > 0 && c.steps >= l.maxSteps {
+		return nil, errStepBudgetSpent
+	}
+	c.active++
+	return &vandalMeter{limiter: l, client: client}, nil
+}
+
+// sweep drops the idle clients whose accounting window has expired.
+func (l *vandalLimiter) sweep(now mclock.AbsTime) {
+	for id, c := range l.clients {
+		if c.active == 0 && time.Duration(now-c.window) >= vandalLimitWindow {
+			delete(l.clients, id)
+		}
+	}
+	l.swept = now
+}
+
+// vandalMeter tracks the resources used by a single trace request.
+type vandalMeter struct {
+	limiter *vandalLimiter
+	client  string
+	once    sync.Once
+}
+
+// charge accounts the given number of traced steps to the client, failing
+// once its budget is spent so that multi-transaction requests are cut short.
+func (m *vandalMeter) charge(steps uint64) error {
+	l := m.limiter
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	c := l.clients[m.client]
+	if now := l.clock.Now(); time.Duration(now-c.window) >= vandalLimitWindow {
+		c.window, c.steps = now, 0
+	}
+	c.steps += steps
+	if l.maxSteps > 0 && c.steps > l.maxSteps {
+		return errStepBudgetSpent
+	}
+	return nil
+}
+
+// release frees the trace slot held by the request.
+func (m *vandalMeter) release() {
+	m.once.Do(func() {
+		m.limiter.lock.Lock()
+		m.limiter.clients[m.client].active--
+		m.limiter.lock.Unlock()
+	})
+}
+
+// vandalMeterKey is the context key of the meter of a trace request.
+type vandalMeterKey struct{}
+
+// limit reserves the tracing resources of the client issuing the request. The
+// returned context carries the meter that traced steps are charged to and the
+// returned function must be called once the request is done. Requests not
+// arriving over HTTP or WebSocket, i.e. local ones, are not limited.
+func (api *VandalAPI) limit(ctx context.Context) (context.Context, func(), error) {
+	if api.limiter == nil {
+		return ctx, func() {}, nil
+	}
+	info := rpc.PeerInfoFromContext(ctx)
+	if info.Transport != "http" && info.Transport != "ws" {
+		return ctx, func() {}, nil
+	}
+	client := info.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	meter, err := api.limiter.acquire(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	return context.WithValue(ctx, vandalMeterKey{}, meter), meter.release, nil
+}
+
+// chargeVandalSteps accounts the steps traced on behalf of a request to the
+// meter carried in its context, if any.
+func chargeVandalSteps(ctx context.Context, steps int) error {
+	meter, ok := ctx.Value(vandalMeterKey{}).(*vandalMeter)
+	if !ok {
+		return nil
+	}
+	return meter.charge(uint64(steps))
+}
+
+// meteredVandalTx wraps a transaction tracer to charge the steps it traces to
+// the meter of the given request context. It is needed where tracing runs on
+// a context detached from the request, e.g. for subscriptions.
+func meteredVandalTx(req context.Context, trace txTraceFn) txTraceFn {
+	meter, ok := req.Value(vandalMeterKey{}).(*vandalMeter)
+	if !ok {
+		return trace
+	}
+	return func(ctx context.Context, message *core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, config *TraceConfig) (interface{}, error) {
+		return trace(context.WithValue(ctx, vandalMeterKey{}, meter), message, txctx, vmctx, statedb, config)
+	}
+}
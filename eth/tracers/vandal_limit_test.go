@@ -0,0 +1,110 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+)
+
+func TestVandalLimiterConcurrency(t *testing.T) {
+	t.Parallel()
+
+	limiter := newVandalLimiter(2, 0, new(mclock.Simulated))
+
+	first, err := limiter.acquire("a")
+	if err != nil {
+		t.Fatalf("failed to acquire first slot: %v", err)
+	}
+	if _, err := limiter.acquire("a"); err != nil {
+		t.Fatalf("failed to acquire second slot: %v", err)
+	}
+	if _, err := limiter.acquire("a"); !errors.Is(err, errTooManyTraces) {
+		t.Fatalf("want %v, have %v", errTooManyTraces, err)
+	}
+	// Other clients are not affected
+	if _, err := limiter.acquire("b"); err != nil {
+		t.Fatalf("failed to acquire slot of other client: %v", err)
+	}
+	// Releasing frees up a slot, releasing twice doesn't
+	first.release()
+	first.release()
+	if _, err := limiter.acquire("a"); err != nil {
+		t.Fatalf("failed to acquire released slot: %v", err)
+	}
+	if _, err := limiter.acquire("a"); !errors.Is(err, errTooManyTraces) {
+		t.Fatalf("want %v, have %v", errTooManyTraces, err)
+	}
+}
+
+func TestVandalLimiterSteps(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock   = new(mclock.Simulated)
+		limiter = newVandalLimiter(0, 100, clock)
+	)
+	meter, err := limiter.acquire("a")
+	if err != nil {
+		t.Fatalf("failed to acquire slot: %v", err)
+	}
+	if err := meter.charge(60); err != nil {
+		t.Fatalf("charge within budget failed: %v", err)
+	}
+	if err := meter.charge(60); !errors.Is(err, errStepBudgetSpent) {
+		t.Fatalf("want %v, have %v", errStepBudgetSpent, err)
+	}
+	meter.release()
+
+	// Spent budgets reject new requests until the window expires
+	if _, err := limiter.acquire("a"); !errors.Is(err, errStepBudgetSpent) {
+		t.Fatalf("want %v, have %v", errStepBudgetSpent, err)
+	}
+	clock.Run(time.Minute)
+	if _, err := limiter.acquire("a"); err != nil {
+		t.Fatalf("failed to acquire slot in new window: %v", err)
+	}
+}
+
+func TestVandalLimitLocal(t *testing.T) {
+	t.Parallel()
+
+	backend, _, _, hashes := newVandalTestBackend(t, 1)
+	defer backend.teardown()
+
+	api := VandalAPIs(backend, &VandalConfig{MaxConcurrentTraces: 1, MaxStepsPerMinute: 1})[0].Service.(*VandalAPI)
+
+	// Local requests are exempt from the limits
+	for i := 0; i < 3; i++ {
+		if _, err := api.TraceTransaction(context.Background(), hashes[0], nil); err != nil {
+			t.Fatalf("local trace %d failed: %v", i, err)
+		}
+	}
+	// Steps traced on a metered request are charged
+	meter, err := api.limiter.acquire("a")
+	if err != nil {
+		t.Fatalf("failed to acquire slot: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), vandalMeterKey{}, meter)
+	if _, err := api.api.TraceVandalTransaction(ctx, hashes[0], nil); !errors.Is(err, errStepBudgetSpent) {
+		t.Fatalf("want %v, have %v", errStepBudgetSpent, err)
+	}
+}
@@ -0,0 +1,416 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// chainEventChanSize is the size of channel listening to ChainEvent.
+const chainEventChanSize = 10
+
+// errTraceNotStored is returned when looking up the trace of a transaction
+// whose block was not written out by the live tracer.
+var errTraceNotStored = errors.New("transaction trace not stored")
+
+// LiveBackend extends the tracing Backend with the chain event subscription
+// needed to follow the canonical chain.
+type LiveBackend interface {
+	Backend
+	SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription
+}
+
+// VandalLive is a node service that traces every block added to the canonical
+// chain with the Vandal logger and writes the results into a directory, one
+// file per block named <number>_<hash>.json. The per-transaction results are
+// also published to subscribers of vandal_subscribe("traces") as they are
+// written out, before the file of their block is complete.
+//
+// Blocks are traced in import order on a single goroutine. If tracing falls
+// behind, block import is throttled rather than blocks being skipped, so the
+// output stays complete. Blocks that are later reorged out are not removed;
+// consumers should key on the block hash in the file name.
+//
+// Progress is reported by vandal_liveStats, and by the vandal/live metrics
+// when metrics collection is enabled.
+type VandalLive struct {
+	backend LiveBackend
+	api     *VandalAPI
+	dir     string // Base output directory
+	stats   *vandalLiveStats
+	events  chan core.ChainEvent // Blocks imported but not yet traced
+
+	outLock sync.Mutex
+	out     string // Current output directory, a subdirectory of dir once rotated
+
+	feed  event.Feed
+	scope event.SubscriptionScope
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewVandalLive creates a live Vandal tracer writing into the given directory.
+func NewVandalLive(backend LiveBackend, config *VandalConfig, dir string) *VandalLive {
+	api := NewVandalAPI(backend)
+	api.defaults = config
+	return &VandalLive{
+		backend: backend,
+		api:     api,
+		dir:     dir,
+		out:     dir,
+		stats:   newVandalLiveStats(mclock.System{}),
+		events:  make(chan core.ChainEvent, chainEventChanSize),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Start implements node.Lifecycle, starting to follow the chain head.
+func (l *VandalLive) Start() error {
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return err
+	}
+	sub := l.backend.SubscribeChainEvent(l.events)
+	l.wg.Add(1)
+	go l.loop(sub)
+
+	log.Info("Started live Vandal tracing", "dir", l.dir)
+	return nil
+}
+
+// Stop implements node.Lifecycle, terminating the tracer after the block
+// currently being traced has been written out.
+func (l *VandalLive) Stop() error {
+	close(l.quit)
+	l.wg.Wait()
+	l.scope.Close()
+
+	log.Info("Stopped live Vandal tracing")
+	return nil
+}
+
+// APIs returns the RPC services offered on top of live tracing.
+func (l *VandalLive) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace:     "vandal",
+			Service:       &VandalLiveAPI{live: l},
+			Authenticated: l.api.authenticated(),
+		},
+	}
+}
+
+// subscribeTraces registers a subscription for the results of every
+// transaction traced by the live tracer.
+func (l *VandalLive) subscribeTraces(ch chan<- *vandalTxTraceResult) event.Subscription {
+	return l.scope.Track(l.feed.Subscribe(ch))
+}
+
+// rotate switches the output to a new subdirectory of the base directory,
+// named after the current time, returning its path. Blocks being traced
+// while rotating are written into the previous directory.
+func (l *VandalLive) rotate() (string, error) {
+	l.outLock.Lock()
+	defer l.outLock.Unlock()
+
+	out := filepath.Join(l.dir, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.Mkdir(out, 0755); err != nil {
+		return "", err
+	}
+	log.Info("Rotated live Vandal trace directory", "dir", out, "previous", l.out)
+	l.out = out
+	return out, nil
+}
+
+// storedTrace reads the trace of the transaction at the given index of a block
+// back from the output, searching the base directory and the subdirectories
+// created by rotating it.
+func (l *VandalLive) storedTrace(number uint64, hash common.Hash, index uint64) (json.RawMessage, error) {
+	name := fmt.Sprintf("%d_%#x.json", number, hash)
+	rotated, err := filepath.Glob(filepath.Join(l.dir, "*", name))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range append([]string{filepath.Join(l.dir, name)}, rotated...) {
+		blob, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var traces []json.RawMessage
+		if err := json.Unmarshal(blob, &traces); err != nil {
+			return nil, err
+		}
+		if index >= uint64(len(traces)) {
+			return nil, fmt.Errorf("stored traces of block %d miss transaction %d", number, index)
+		}
+		return traces[index], nil
+	}
+	return nil, errTraceNotStored
+}
+
+// loop traces the blocks announced by the chain until the service is stopped.
+func (l *VandalLive) loop(sub event.Subscription) {
+	defer l.wg.Done()
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-l.events:
+			vandalLiveQueueGauge.Update(int64(len(l.events)))
+			if err := l.traceBlock(ev.Block); err != nil {
+				l.stats.failed(err)
+				log.Warn("Live Vandal tracing failed", "number", ev.Block.NumberU64(), "hash", ev.Block.Hash(), "err", err)
+			}
+		case err := <-sub.Err():
+			if err != nil {
+				log.Error("Live Vandal tracing subscription failed", "err", err)
+			}
+			return
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// traceBlock traces all transactions of the given block, writing the results
+// into the output directory and publishing them one at a time as they are
+// produced, so the traces of a block need not all be held in memory.
+func (l *VandalLive) traceBlock(block *types.Block) error {
+	if block.NumberU64() == 0 {
+		return nil
+	}
+	var (
+		start  = time.Now()
+		config = l.api.traceConfig(nil)
+	)
+	cfg, err := config.vandalLoggerConfig()
+	if err != nil {
+		return err
+	}
+	meta := NewVandalTraceMeta(l.backend.ChainConfig(), block.Header(), cfg)
+
+	l.outLock.Lock()
+	out := l.out
+	l.outLock.Unlock()
+
+	file, err := createVandalBlockFile(out, block, meta)
+	if err != nil {
+		return err
+	}
+	var (
+		txs, txErrors int
+		written       time.Duration // Time spent writing rather than tracing
+	)
+	err = l.api.traceBlockTxs(context.Background(), block, config, nil, func(tx *vandalTxTrace) error {
+		res := tx.result()
+		began := time.Now()
+		if err := file.write(res); err != nil {
+			return err
+		}
+		written += time.Since(began)
+
+		txs++
+		if res.Error != "" {
+			txErrors++
+		}
+		l.feed.Send(&vandalTxTraceResult{
+			Block:  hexutil.Uint64(block.NumberU64()),
+			Hash:   block.Hash(),
+			TxHash: res.TxHash,
+			Result: res.Result,
+			Error:  res.Error,
+		})
+		return nil
+	})
+	if err != nil {
+		file.abort()
+		return err
+	}
+	began := time.Now()
+	if err := file.close(); err != nil {
+		return err
+	}
+	written += time.Since(began)
+
+	l.stats.done(block, txs, txErrors, time.Since(start)-written, written)
+	return nil
+}
+
+// vandalBlockFile writes the traces of the transactions of a block into a
+// file one at a time, so they need not all be held in memory. The file only
+// appears under its final name once complete.
+type vandalBlockFile struct {
+	f     *os.File
+	w     *bufio.Writer
+	name  string
+	meta  *VandalTraceMeta
+	count int
+}
+
+// vandalStoredTrace is the trace of a transaction as stored, stamped with the
+// metadata of its capture.
+type vandalStoredTrace struct {
+	*txTraceResult
+	Meta *VandalTraceMeta `json:"meta,omitempty"`
+}
+
+// createVandalBlockFile starts writing the traces of the given block into the
+// given directory, in a file named <number>_<hash>.json, each stamped with the
+// given metadata if any.
+func createVandalBlockFile(dir string, block *types.Block, meta *VandalTraceMeta) (*vandalBlockFile, error) {
+	name := filepath.Join(dir, fmt.Sprintf("%d_%#x.json", block.NumberU64(), block.Hash()))
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteByte('[')
+	return &vandalBlockFile{f: f, w: w, name: name, meta: meta}, nil
+}
+
+// write appends the trace of the next transaction to the file.
+func (f *vandalBlockFile) write(res *txTraceResult) error {
+	blob, err := json.Marshal(&vandalStoredTrace{txTraceResult: res, Meta: f.meta})
+	if err != nil {
+		return err
+	}
+	if f.count > 0 {
+		f.w.WriteByte(',')
+	}
+	f.count++
+	_, err = f.w.Write(blob)
+	return err
+}
+
+// close completes the file and moves it to its final name.
+func (f *vandalBlockFile) close() error {
+	f.w.WriteByte(']')
+	if err := f.w.Flush(); err != nil {
+		f.abort()
+		return err
+	}
+	if err := f.f.Close(); err != nil {
+		os.Remove(f.f.Name())
+		return err
+	}
+	return os.Rename(f.f.Name(), f.name)
+}
+
+// abort discards the incomplete file.
+func (f *vandalBlockFile) abort() {
+	f.f.Close()
+	os.Remove(f.f.Name())
+}
+
+// VandalLiveAPI offers subscriptions to the traces produced by the live
+// Vandal tracer, access to those written out, and its statistics.
+type VandalLiveAPI struct {
+	live *VandalLive
+}
+
+// Traces creates a subscription that is notified of every transaction traced
+// by the live Vandal tracer, in import order. Unless fullTrace is set, the
+// basic blocks are omitted and only the transaction and block identifiers
+// are sent.
+func (api *VandalLiveAPI) Traces(ctx context.Context, fullTrace *bool) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	var (
+		full   = fullTrace != nil && *fullTrace
+		rpcSub = notifier.CreateSubscription()
+		traces = make(chan *vandalTxTraceResult, chainEventChanSize)
+		sub    = api.live.subscribeTraces(traces)
+	)
+	go func() {
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case trace := <-traces:
+				if !full {
+					summary := *trace
+					summary.Result = nil
+					trace = &summary
+				}
+				notifier.Notify(rpcSub.ID, trace)
+			case <-rpcSub.Err():
+				return
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// LiveStats returns the progress of the live Vandal tracer: the number of
+// blocks and transactions traced, the tracing rate, the number of blocks
+// waiting to be traced, tracing and writing latencies and error counts.
+func (api *VandalLiveAPI) LiveStats() *VandalLiveStats {
+	return api.live.stats.snapshot(len(api.live.events))
+}
+
+// RotateLiveDir switches the output of the live tracer to a new subdirectory
+// of its configured directory, named after the current time, so that the
+// traces written so far can be archived or removed. It returns the path of
+// the new directory.
+func (api *VandalLiveAPI) RotateLiveDir() (string, error) {
+	return api.live.rotate()
+}
+
+// StoredTrace returns the trace of a transaction as written out by the live
+// tracer, stamped with the metadata of its capture, without re-executing it.
+func (api *VandalLiveAPI) StoredTrace(ctx context.Context, hash common.Hash) (json.RawMessage, error) {
+	found, _, blockHash, blockNumber, index, err := api.live.backend.GetTransaction(ctx, hash)
+	if err != nil {
+		return nil, ethapi.NewTxIndexingError()
+	}
+	if !found {
+		return nil, errTxNotFound
+	}
+	return api.live.storedTrace(blockNumber, blockHash, index)
+}
+
+// ResetLiveStats resets the statistics of the live tracer, returning their
+// values before the reset.
+func (api *VandalLiveAPI) ResetLiveStats() *VandalLiveStats {
+	return api.live.stats.reset(len(api.live.events))
+}
@@ -0,0 +1,208 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// liveTestBackend extends the test backend with chain event subscriptions.
+type liveTestBackend struct {
+	*testBackend
+}
+
+func (b *liveTestBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription {
+	return b.chain.SubscribeChainEvent(ch)
+}
+
+func TestVandalLive(t *testing.T) {
+	t.Parallel()
+
+	var (
+		genesis, accounts, contract = newVandalTestGenesis()
+		hashes                      []common.Hash
+		backend                     = &liveTestBackend{newTestBackend(t, 0, genesis, nil)}
+		dir                         = t.TempDir()
+	)
+	defer backend.teardown()
+
+	_, blocks, _ := core.GenerateChainWithGenesis(genesis, ethash.NewFaker(), 3, vandalTestGenerator(accounts[0], contract, &hashes))
+
+	live := NewVandalLive(backend, nil, dir)
+	if err := live.Start(); err != nil {
+		t.Fatalf("failed to start live tracer: %v", err)
+	}
+	// Subscribe to the trace summaries over RPC
+	server := rpc.NewServer()
+	defer server.Stop()
+	for _, api := range live.APIs() {
+		if err := server.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatalf("failed to register API: %v", err)
+		}
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	traces := make(chan *vandalTxTraceResult, len(blocks))
+	sub, err := client.Subscribe(context.Background(), "vandal", traces, "traces")
+	if err != nil {
+		t.Fatalf("failed to subscribe to traces: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if _, err := backend.chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	// The last block is written once all previous ones have been traced
+	last := blocks[len(blocks)-1]
+	lastFile := filepath.Join(dir, fmt.Sprintf("%d_%#x.json", last.NumberU64(), last.Hash()))
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(lastFile); err == nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out waiting for live traces")
+		}
+	}
+	for i, block := range blocks {
+		select {
+		case trace := <-traces:
+			if trace.TxHash != hashes[i] || trace.Hash != block.Hash() || trace.Result != nil {
+				t.Fatalf("unexpected trace summary %d: %+v", i, trace)
+			}
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for trace summaries")
+		}
+	}
+	if err := live.Stop(); err != nil {
+		t.Fatalf("failed to stop live tracer: %v", err)
+	}
+	var stats VandalLiveStats
+	if err := client.Call(&stats, "vandal_liveStats"); err != nil {
+		t.Fatalf("failed to retrieve stats: %v", err)
+	}
+	if stats.Blocks != uint64(len(blocks)) || stats.Transactions != uint64(len(blocks)) || stats.Errors != 0 || stats.LastHash != last.Hash() {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	var rotated string
+	if err := client.Call(&rotated, "vandal_rotateLiveDir"); err != nil {
+		t.Fatalf("failed to rotate output: %v", err)
+	}
+	if info, err := os.Stat(rotated); err != nil || !info.IsDir() || filepath.Dir(rotated) != dir {
+		t.Fatalf("unexpected rotated directory %s: %v", rotated, err)
+	}
+	for i, block := range blocks {
+		blob, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d_%#x.json", block.NumberU64(), block.Hash())))
+		if err != nil {
+			t.Fatalf("missing trace of block %d: %v", block.NumberU64(), err)
+		}
+		var results []*txTraceResult
+		if err := json.Unmarshal(blob, &results); err != nil {
+			t.Fatalf("failed to decode trace of block %d: %v", block.NumberU64(), err)
+		}
+		if len(results) != 1 || results[0].TxHash != hashes[i] || results[0].Result == nil {
+			t.Fatalf("unexpected trace of block %d: %s", block.NumberU64(), blob)
+		}
+		var metas []struct{ Meta *VandalTraceMeta }
+		if err := json.Unmarshal(blob, &metas); err != nil {
+			t.Fatalf("failed to decode metadata of block %d: %v", block.NumberU64(), err)
+		}
+		if meta := metas[0].Meta; meta == nil || meta.ConfigHash != NewVandalTraceMeta(backend.ChainConfig(), block.Header(), nil).ConfigHash {
+			t.Fatalf("unexpected metadata of block %d: %s", block.NumberU64(), blob)
+		}
+	}
+	// Stored traces are looked up in the rotated directories too
+	first := fmt.Sprintf("%d_%#x.json", blocks[0].NumberU64(), blocks[0].Hash())
+	if err := os.Rename(filepath.Join(dir, first), filepath.Join(rotated, first)); err != nil {
+		t.Fatalf("failed to move trace into rotated directory: %v", err)
+	}
+	for i, hash := range hashes {
+		var stored struct {
+			TxHash common.Hash      `json:"txHash"`
+			Meta   *VandalTraceMeta `json:"meta"`
+		}
+		if err := client.Call(&stored, "vandal_storedTrace", hash); err != nil {
+			t.Fatalf("failed to retrieve stored trace %d: %v", i, err)
+		}
+		if stored.TxHash != hash || stored.Meta == nil {
+			t.Fatalf("unexpected stored trace %d: %+v", i, stored)
+		}
+	}
+	if err := client.Call(new(json.RawMessage), "vandal_storedTrace", common.Hash{0x01}); err == nil {
+		t.Fatal("expected error for unknown transaction")
+	}
+}
+
+func TestVandalBlockFile(t *testing.T) {
+	t.Parallel()
+
+	var (
+		dir   = t.TempDir()
+		block = types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)})
+	)
+	for _, results := range [][]*txTraceResult{
+		{},
+		{{TxHash: common.Hash{1}, Result: json.RawMessage(`[]`)}},
+		{{TxHash: common.Hash{1}, Result: json.RawMessage(`[{"Entry":0}]`)}, {TxHash: common.Hash{2}, Error: "failed"}},
+	} {
+		f, err := createVandalBlockFile(dir, block, nil)
+		if err != nil {
+			t.Fatalf("failed to create block file: %v", err)
+		}
+		for _, res := range results {
+			if err := f.write(res); err != nil {
+				t.Fatalf("failed to write transaction: %v", err)
+			}
+		}
+		if err := f.close(); err != nil {
+			t.Fatalf("failed to write block: %v", err)
+		}
+		want, _ := json.Marshal(results)
+		have, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d_%#x.json", block.NumberU64(), block.Hash())))
+		if err != nil {
+			t.Fatalf("failed to read block: %v", err)
+		}
+		if string(have) != string(want) {
+			t.Fatalf("block file mismatch: have %s, want %s", have, want)
+		}
+	}
+	// Aborted files leave nothing behind
+	f, err := createVandalBlockFile(dir, block, nil)
+	if err != nil {
+		t.Fatalf("failed to create block file: %v", err)
+	}
+	f.abort()
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("unexpected files left: %v", files)
+	}
+}
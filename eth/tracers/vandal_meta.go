@@ -0,0 +1,118 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/internal/version"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// VandalTraceMeta records how a stored Vandal trace was produced, so that
+// datasets built across client upgrades can be audited, and the traces produced
+// by a given client or configuration selectively re-traced.
+type VandalTraceMeta struct {
+	Client     string       `json:"client"`     // Client name, version and commit
+	ChainID    *hexutil.Big `json:"chainId"`    // Chain the block belongs to
+	Fork       string       `json:"fork"`       // Latest fork active at the block
+	ConfigHash common.Hash  `json:"configHash"` // Hash of the Vandal logger configuration
+	Time       time.Time    `json:"time"`       // When the trace was captured
+}
+
+// NewVandalTraceMeta creates the metadata of traces of the given block, captured
+// now with the given logger configuration.
+func NewVandalTraceMeta(chain *params.ChainConfig, header *types.Header, config *logger.VandalConfig) *VandalTraceMeta {
+	if config == nil {
+		config = new(logger.VandalConfig)
+	}
+	blob, _ := json.Marshal(config)
+	return &VandalTraceMeta{
+		Client:     version.ClientName("geth"),
+		ChainID:    (*hexutil.Big)(chain.ChainID),
+		Fork:       vandalFork(chain, header),
+		ConfigHash: crypto.Keccak256Hash(blob),
+		Time:       time.Now().UTC(),
+	}
+}
+
+// vandalFork returns the name of the latest fork active at the given block.
+func vandalFork(chain *params.ChainConfig, header *types.Header) string {
+	isMerge := header.Difficulty != nil && header.Difficulty.Sign() == 0
+	rules := chain.Rules(header.Number, isMerge, header.Time)
+	switch {
+	case rules.IsPrague:
+		return "prague"
+	case rules.IsCancun:
+		return "cancun"
+	case rules.IsShanghai:
+		return "shanghai"
+	case rules.IsMerge:
+		return "paris"
+	case rules.IsLondon:
+		return "london"
+	case rules.IsBerlin:
+		return "berlin"
+	case rules.IsIstanbul:
+		return "istanbul"
+	case rules.IsPetersburg:
+		return "petersburg"
+	case rules.IsConstantinople:
+		return "constantinople"
+	case rules.IsByzantium:
+		return "byzantium"
+	case rules.IsEIP158:
+		return "spuriousDragon"
+	case rules.IsEIP150:
+		return "tangerineWhistle"
+	case rules.IsHomestead:
+		return "homestead"
+	default:
+		return "frontier"
+	}
+}
+
+// vandalLoggerConfig returns the configuration of the Vandal logger tracing
+// with the given settings. Unless another tracer is requested, the tracer
+// config holds the options of the trace, as accepted by the vandalTracer, its
+// memory and step limits being capped by those of the node.
+func (config *TraceConfig) vandalLoggerConfig() (*logger.VandalConfig, error) {
+	if config == nil {
+		return new(logger.VandalConfig), nil
+	}
+	cfg := new(logger.VandalConfig)
+	if config.Tracer == nil {
+		var err error
+		if cfg, err = logger.ParseVandalConfig(config.TracerConfig); err != nil {
+			return nil, err
+		}
+	}
+	if limit := config.vandalMemoryLimit; limit != 0 && (cfg.MemoryLimit == 0 || cfg.MemoryLimit > limit) {
+		cfg.MemoryLimit = limit
+	}
+	if limit := config.vandalStepLimit; limit > 0 && (cfg.StepLimit == 0 || cfg.StepLimit > limit) {
+		cfg.StepLimit = limit
+	}
+	cfg.EnableReturnData = cfg.EnableReturnData || config.vandalReturnData || (config.Config != nil && config.EnableReturnData)
+	return cfg, nil
+}
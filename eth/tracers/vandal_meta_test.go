@@ -0,0 +1,64 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that the metadata of stored traces identifies the fork of the block
+// and distinguishes logger configurations.
+func TestVandalTraceMeta(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		header *types.Header
+		fork   string
+	}{
+		{&types.Header{Number: big.NewInt(0), Difficulty: big.NewInt(1)}, "frontier"},
+		{&types.Header{Number: big.NewInt(1_150_000), Difficulty: big.NewInt(1)}, "homestead"},
+		{&types.Header{Number: big.NewInt(12_965_000), Difficulty: big.NewInt(1)}, "london"},
+		{&types.Header{Number: big.NewInt(15_537_394), Difficulty: new(big.Int)}, "paris"},
+		{&types.Header{Number: big.NewInt(19_426_587), Difficulty: new(big.Int), Time: 1710338135}, "cancun"},
+	}
+	for _, tt := range tests {
+		meta := NewVandalTraceMeta(params.MainnetChainConfig, tt.header, nil)
+		if meta.Fork != tt.fork {
+			t.Errorf("block %d: fork mismatch: have %s, want %s", tt.header.Number, meta.Fork, tt.fork)
+		}
+		if meta.ChainID.ToInt().Cmp(params.MainnetChainConfig.ChainID) != 0 || meta.Client == "" || meta.Time.IsZero() {
+			t.Errorf("block %d: incomplete metadata: %+v", tt.header.Number, meta)
+		}
+	}
+	var (
+		header = tests[0].header
+		plain  = NewVandalTraceMeta(params.MainnetChainConfig, header, nil)
+		empty  = NewVandalTraceMeta(params.MainnetChainConfig, header, &logger.VandalConfig{})
+		ret    = NewVandalTraceMeta(params.MainnetChainConfig, header, &logger.VandalConfig{EnableReturnData: true})
+	)
+	if plain.ConfigHash != empty.ConfigHash {
+		t.Errorf("default config hash mismatch: %x != %x", plain.ConfigHash, empty.ConfigHash)
+	}
+	if plain.ConfigHash == ret.ConfigHash {
+		t.Errorf("config hash does not cover return data capture")
+	}
+}
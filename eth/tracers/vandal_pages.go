@@ -0,0 +1,153 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// vandalCursorTTL is how long the remainder of a paginated trace is kept
+	// around waiting for the next page to be requested.
+	vandalCursorTTL = 5 * time.Minute
+
+	// vandalMaxCursors is the maximum number of paginated traces kept around,
+	// the oldest one being dropped when exceeded.
+	vandalMaxCursors = 64
+)
+
+var errCursorNotFound = errors.New("trace cursor not found or expired")
+
+// vandalPage is a chunk of the basic blocks of a trace too large to be sent
+// in a single response. The cursor is set if more pages follow.
+type vandalPage struct {
+	Blocks []json.RawMessage `json:"blocks"`
+	Cursor rpc.ID            `json:"cursor,omitempty"`
+}
+
+// vandalPager splits large Vandal traces into pages of a limited size,
+// retaining the pages not yet sent until requested or expired.
+type vandalPager struct {
+	size  int // Maximum size of a page in bytes
+	clock mclock.Clock
+
+	lock    sync.Mutex
+	cursors map[rpc.ID]*vandalCursor
+}
+
+// vandalCursor is the remainder of a paginated trace.
+type vandalCursor struct {
+	blocks  []json.RawMessage
+	created mclock.AbsTime
+}
+
+func newVandalPager(size int, clock mclock.Clock) *vandalPager {
+	return &vandalPager{
+		size:    size,
+		clock:   clock,
+		cursors: make(map[rpc.ID]*vandalCursor),
+	}
+}
+
+// paginate returns the given trace as is if it fits into a single page, or
+// its first page otherwise. Only plain lists of blocks are paginated, traces
+// encoded as objects, e.g. with edges or in the facts format, and protobuf
+// traces being returned whole.
+func (p *vandalPager) paginate(result interface{}) (interface{}, error) {
+	trace, ok := result.(json.RawMessage)
+	if !ok || len(trace) <= p.size || trace[0] != '[' {
+		return result, nil
+	}
+	var blocks []json.RawMessage
+	if err := json.Unmarshal(trace, &blocks); err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.page(blocks), nil
+}
+
+// next returns the page following the given cursor.
+func (p *vandalPager) next(cursor rpc.ID) (*vandalPage, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.expire()
+	rest, ok := p.cursors[cursor]
+	if !ok {
+		return nil, errCursorNotFound
+	}
+	delete(p.cursors, cursor)
+	return p.page(rest.blocks), nil
+}
+
+// page cuts the next page off the given blocks, retaining the rest under a
+// new cursor. Every page holds at least one block, even if larger than the
+// page size. The caller must hold the lock.
+func (p *vandalPager) page(blocks []json.RawMessage) *vandalPage {
+	var (
+		n    int
+		size = 2 // Enclosing brackets
+	)
+	for n < len(blocks) && (n == 0 || size+len(blocks[n])+1 <= p.size) {
+		size += len(blocks[n]) + 1
+		n++
+	}
+	page := &vandalPage{Blocks: blocks[:n]}
+	if n == len(blocks) {
+		return page
+	}
+	p.expire()
+	if len(p.cursors) >= vandalMaxCursors {
+		p.evictOldest()
+	}
+	page.Cursor = rpc.NewID()
+	p.cursors[page.Cursor] = &vandalCursor{blocks: blocks[n:], created: p.clock.Now()}
+	return page
+}
+
+// expire drops the cursors not followed within their time to live.
+func (p *vandalPager) expire() {
+	now := p.clock.Now()
+	for id, cursor := range p.cursors {
+		if time.Duration(now-cursor.created) >= vandalCursorTTL {
+			delete(p.cursors, id)
+		}
+	}
+}
+
+// evictOldest drops the oldest cursor.
+func (p *vandalPager) evictOldest() {
+	var (
+		oldest rpc.ID
+		first  = true
+		at     mclock.AbsTime
+	)
+	for id, cursor := range p.cursors {
+		if first || cursor.created < at {
+			oldest, at, first = id, cursor.created, false
+		}
+	}
+	delete(p.cursors, oldest)
+}
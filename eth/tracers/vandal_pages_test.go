@@ -0,0 +1,84 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+)
+
+func TestVandalPager(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock = new(mclock.Simulated)
+		pager = newVandalPager(12, clock)
+	)
+	// Traces fitting into a page are returned as is
+	small := json.RawMessage(`[{"a":1}]`)
+	if res, err := pager.paginate(small); err != nil || string(res.(json.RawMessage)) != string(small) {
+		t.Fatalf("small trace modified: %v %v", res, err)
+	}
+	// Traces other than lists of blocks are never split
+	object := json.RawMessage(`{"blocks":[{"a":1}],"truncated":true,"steps":1}`)
+	if res, err := pager.paginate(object); err != nil || string(res.(json.RawMessage)) != string(object) {
+		t.Fatalf("object trace modified: %v %v", res, err)
+	}
+	// Larger ones are split, with at least one block per page
+	res, err := pager.paginate(json.RawMessage(`[{"a":1},{"b":2},{"c":"oversized"},{"d":4}]`))
+	if err != nil {
+		t.Fatalf("failed to paginate: %v", err)
+	}
+	var (
+		page = res.(*vandalPage)
+		have []string
+	)
+	for {
+		for _, block := range page.Blocks {
+			have = append(have, string(block))
+		}
+		if page.Cursor == "" {
+			break
+		}
+		cursor := page.Cursor
+		if page, err = pager.next(cursor); err != nil {
+			t.Fatalf("failed to retrieve next page: %v", err)
+		}
+		// Cursors can only be followed once
+		if _, err := pager.next(cursor); !errors.Is(err, errCursorNotFound) {
+			t.Fatalf("want %v, have %v", errCursorNotFound, err)
+		}
+	}
+	want := []string{`{"a":1}`, `{"b":2}`, `{"c":"oversized"}`, `{"d":4}`}
+	if len(have) != len(want) {
+		t.Fatalf("block count mismatch: have %v, want %v", have, want)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("block %d mismatch: have %s, want %s", i, have[i], want[i])
+		}
+	}
+	// Cursors not followed in time expire
+	res, _ = pager.paginate(json.RawMessage(`[{"a":1},{"b":2}]`))
+	clock.Run(vandalCursorTTL)
+	if _, err := pager.next(res.(*vandalPage).Cursor); !errors.Is(err, errCursorNotFound) {
+		t.Fatalf("want %v, have %v", errCursorNotFound, err)
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip1559"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// txChanSize is the size of channel listening to NewTxsEvent.
+	txChanSize = 4096
+
+	// vandalPendingQueue is the maximum number of pending transactions waiting
+	// to be traced, newer ones being dropped when exceeded.
+	vandalPendingQueue = 1024
+
+	// vandalPendingTracked is the maximum number of traced transactions whose
+	// inclusion is awaited, the oldest one being forgotten when exceeded.
+	vandalPendingTracked = 16384
+)
+
+// PendingBackend extends the live tracing backend with the transaction pool
+// subscription needed to follow pending transactions.
+type PendingBackend interface {
+	LiveBackend
+	SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription
+}
+
+// vandalPendingTrace is the content of the file a pending transaction trace is
+// written to.
+type vandalPendingTrace struct {
+	Block  hexutil.Uint64 `json:"block"`            // Head block the transaction was traced on
+	Hash   common.Hash    `json:"hash"`             // Hash of the head block
+	TxHash common.Hash    `json:"txHash"`           // Transaction hash
+	Result interface{}    `json:"result,omitempty"` // Basic blocks produced by the Vandal logger
+	Error  string         `json:"error,omitempty"`  // Trace failure produced by the tracer
+}
+
+// vandalPendingTx identifies a traced transaction awaiting inclusion.
+type vandalPendingTx struct {
+	from  common.Address
+	nonce uint64
+}
+
+// VandalPending is a node service that traces every transaction entering the
+// transaction pool with the Vandal logger against the state of the current
+// head block and writes the results into a directory, one file per
+// transaction named <txhash>.json. Transactions are executed as if included in
+// a block following the head.
+//
+// Once a traced transaction is included in a canonical block, its file is
+// renamed to <txhash>.confirmed.json. If another transaction of the same
+// sender and nonce is included instead, it is renamed to
+// <txhash>.superseded.json. The trace of a transaction may differ from its
+// eventual execution, as the state it's included on top of may differ.
+//
+// Tracing never holds up the transaction pool: when it falls behind, newly
+// announced transactions are dropped.
+type VandalPending struct {
+	backend PendingBackend
+	api     *VandalAPI
+	dir     string
+
+	head    *types.Block   // Block pending transactions are traced on top of
+	state   *state.StateDB // State of the head block, copied for every trace
+	release StateReleaseFunc
+
+	queue   []*types.Transaction            // Transactions waiting to be traced
+	tracked map[common.Hash]vandalPendingTx // Traced transactions awaiting inclusion
+	nonces  map[vandalPendingTx]common.Hash // Traced transactions by sender and nonce
+	order   []common.Hash                   // Traced transactions in tracing order, may contain resolved ones
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewVandalPending creates a pending transaction tracer writing into the given
+// directory.
+func NewVandalPending(backend PendingBackend, config *VandalConfig, dir string) *VandalPending {
+	api := NewVandalAPI(backend)
+	api.defaults = config
+	return &VandalPending{
+		backend: backend,
+		api:     api,
+		dir:     dir,
+		tracked: make(map[common.Hash]vandalPendingTx),
+		nonces:  make(map[vandalPendingTx]common.Hash),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Start implements node.Lifecycle, starting to follow the transaction pool.
+func (p *VandalPending) Start() error {
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	var (
+		txs     = make(chan core.NewTxsEvent, txChanSize)
+		txSub   = p.backend.SubscribeNewTxsEvent(txs)
+		heads   = make(chan core.ChainEvent, chainEventChanSize)
+		headSub = p.backend.SubscribeChainEvent(heads)
+	)
+	p.wg.Add(1)
+	go p.loop(txs, txSub, heads, headSub)
+
+	log.Info("Started pending Vandal tracing", "dir", p.dir)
+	return nil
+}
+
+// Stop implements node.Lifecycle, terminating the tracer after the transaction
+// currently being traced has been written out.
+func (p *VandalPending) Stop() error {
+	close(p.quit)
+	p.wg.Wait()
+
+	log.Info("Stopped pending Vandal tracing")
+	return nil
+}
+
+// loop traces the transactions announced by the pool and tracks their
+// inclusion until the service is stopped.
+func (p *VandalPending) loop(txs chan core.NewTxsEvent, txSub event.Subscription, heads chan core.ChainEvent, headSub event.Subscription) {
+	defer p.wg.Done()
+	defer txSub.Unsubscribe()
+	defer headSub.Unsubscribe()
+	defer p.setHead(nil)
+
+	ready := make(chan struct{})
+	close(ready)
+
+	for {
+		// Trace the queued transactions one by one, handling events in between
+		var idle <-chan struct{}
+		if len(p.queue) > 0 {
+			idle = ready
+		}
+		select {
+		case ev := <-txs:
+			p.enqueue(ev.Txs)
+		case ev := <-heads:
+			p.include(ev.Block)
+		case <-idle:
+			tx := p.queue[0]
+			p.queue = p.queue[1:]
+			if err := p.traceTx(tx); err != nil {
+				log.Debug("Pending Vandal tracing failed", "hash", tx.Hash(), "err", err)
+			}
+		case err := <-txSub.Err():
+			if err != nil {
+				log.Error("Pending Vandal tracing subscription failed", "err", err)
+			}
+			return
+		case err := <-headSub.Err():
+			if err != nil {
+				log.Error("Pending Vandal tracing subscription failed", "err", err)
+			}
+			return
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// enqueue schedules the given transactions for tracing, dropping them if the
+// tracer is too far behind.
+func (p *VandalPending) enqueue(txs []*types.Transaction) {
+	for _, tx := range txs {
+		if len(p.queue) >= vandalPendingQueue {
+			log.Debug("Dropping pending transaction from Vandal tracing", "hash", tx.Hash())
+			continue
+		}
+		p.queue = append(p.queue, tx)
+	}
+}
+
+// setHead switches the state pending transactions are traced on to the given
+// block, releasing the previous one.
+func (p *VandalPending) setHead(block *types.Block) error {
+	if p.release != nil {
+		p.release()
+	}
+	p.head, p.state, p.release = nil, nil, nil
+	if block == nil {
+		return nil
+	}
+	statedb, release, err := p.backend.StateAtBlock(context.Background(), block, 0, nil, true, false)
+	if err != nil {
+		return err
+	}
+	p.head, p.state, p.release = block, statedb, release
+	return nil
+}
+
+// traceTx traces the given transaction on top of the head state and writes the
+// result into the output directory.
+func (p *VandalPending) traceTx(tx *types.Transaction) error {
+	if p.head == nil {
+		head, err := p.backend.BlockByNumber(context.Background(), rpc.LatestBlockNumber)
+		if err != nil {
+			return err
+		}
+		if err := p.setHead(head); err != nil {
+			return err
+		}
+	}
+	var (
+		config  = p.backend.ChainConfig()
+		header  = pendingHeader(config, p.head.Header())
+		signer  = types.MakeSigner(config, header.Number, header.Time)
+		vmctx   = core.NewEVMBlockContext(header, p.api.api.chainContext(context.Background()), nil)
+		txctx   = &Context{TxHash: tx.Hash()}
+		traceTx = &vandalPendingTrace{Block: hexutil.Uint64(p.head.NumberU64()), Hash: p.head.Hash(), TxHash: tx.Hash()}
+	)
+	msg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+	if err != nil {
+		return err
+	}
+	res, err := p.api.api.traceVandalTx(context.Background(), msg, txctx, vmctx, p.state.Copy(), p.api.traceConfig(nil))
+	if err != nil {
+		traceTx.Error = err.Error()
+	} else {
+		traceTx.Result = res
+	}
+	blob, err := json.Marshal(traceTx)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p.path(tx.Hash(), ""), blob, 0644); err != nil {
+		return err
+	}
+	id := vandalPendingTx{from: msg.From, nonce: msg.Nonce}
+	if prev, ok := p.nonces[id]; ok {
+		// A replacement of a traced transaction, which is thus superseded
+		p.resolve(prev, "superseded")
+	}
+	p.track(tx.Hash(), id)
+	return nil
+}
+
+// track awaits the inclusion of the given traced transaction, forgetting the
+// oldest one if too many are tracked already.
+func (p *VandalPending) track(hash common.Hash, id vandalPendingTx) {
+	for len(p.tracked) >= vandalPendingTracked {
+		oldest := p.order[0]
+		p.order = p.order[1:]
+		if id, ok := p.tracked[oldest]; ok {
+			delete(p.tracked, oldest)
+			if p.nonces[id] == oldest {
+				delete(p.nonces, id)
+			}
+		}
+	}
+	// Drop the resolved transactions from the order once they dominate it
+	if len(p.order) >= 2*vandalPendingTracked {
+		order := make([]common.Hash, 0, len(p.tracked)+1)
+		for _, hash := range p.order {
+			if _, ok := p.tracked[hash]; ok {
+				order = append(order, hash)
+			}
+		}
+		p.order = order
+	}
+	p.tracked[hash] = id
+	p.nonces[id] = hash
+	p.order = append(p.order, hash)
+}
+
+// pendingHeader returns the header of a block following the given one, which
+// pending transactions are executed in.
+func pendingHeader(config *params.ChainConfig, parent *types.Header) *types.Header {
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Coinbase:   parent.Coinbase,
+		Difficulty: parent.Difficulty,
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		GasLimit:   parent.GasLimit,
+		Time:       parent.Time + 1,
+		MixDigest:  parent.MixDigest,
+	}
+	if config.IsLondon(header.Number) {
+		header.BaseFee = eip1559.CalcBaseFee(config, parent)
+	}
+	if config.IsCancun(header.Number, header.Time) {
+		var excess, used uint64
+		if parent.ExcessBlobGas != nil {
+			excess, used = *parent.ExcessBlobGas, *parent.BlobGasUsed
+		}
+		excess = eip4844.CalcExcessBlobGas(excess, used)
+		header.ExcessBlobGas = &excess
+	}
+	return header
+}
+
+// include marks the traced transactions included in the given block as
+// confirmed and the ones replaced by them as superseded, then moves the head
+// state to the block.
+func (p *VandalPending) include(block *types.Block) {
+	signer := types.MakeSigner(p.backend.ChainConfig(), block.Number(), block.Time())
+	for _, tx := range block.Transactions() {
+		if _, ok := p.tracked[tx.Hash()]; ok {
+			p.resolve(tx.Hash(), "confirmed")
+			continue
+		}
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			continue
+		}
+		if prev, ok := p.nonces[vandalPendingTx{from: from, nonce: tx.Nonce()}]; ok {
+			p.resolve(prev, "superseded")
+		}
+	}
+	if err := p.setHead(block); err != nil {
+		log.Warn("Failed to retrieve state for pending Vandal tracing", "number", block.NumberU64(), "hash", block.Hash(), "err", err)
+	}
+}
+
+// resolve renames the trace file of the given transaction to mark it with the
+// given status and stops tracking the transaction.
+func (p *VandalPending) resolve(hash common.Hash, status string) {
+	id := p.tracked[hash]
+	delete(p.tracked, hash)
+	if p.nonces[id] == hash {
+		delete(p.nonces, id)
+	}
+	if err := os.Rename(p.path(hash, ""), p.path(hash, status)); err != nil {
+		log.Warn("Failed to mark pending Vandal trace", "hash", hash, "status", status, "err", err)
+	}
+}
+
+// path returns the file path of the trace of the given transaction, with the
+// given status or pending if empty.
+func (p *VandalPending) path(hash common.Hash, status string) string {
+	if status == "" {
+		return filepath.Join(p.dir, fmt.Sprintf("%#x.json", hash))
+	}
+	return filepath.Join(p.dir, fmt.Sprintf("%#x.%s.json", hash, status))
+}
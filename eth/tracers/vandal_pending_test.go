@@ -0,0 +1,110 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// pendingTestBackend extends the live test backend with pool notifications.
+type pendingTestBackend struct {
+	*liveTestBackend
+	txFeed event.Feed
+}
+
+func (b *pendingTestBackend) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	return b.txFeed.Subscribe(ch)
+}
+
+// waitForFile waits until the given file exists.
+func waitForFile(t *testing.T, path string) {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("timed out waiting for %s", path)
+		}
+	}
+}
+
+func TestVandalPending(t *testing.T) {
+	t.Parallel()
+
+	var (
+		genesis, accounts, contract = newVandalTestGenesis()
+		hashes                      []common.Hash
+		backend                     = &pendingTestBackend{liveTestBackend: &liveTestBackend{newTestBackend(t, 0, genesis, nil)}}
+		dir                         = t.TempDir()
+	)
+	defer backend.teardown()
+
+	_, blocks, _ := core.GenerateChainWithGenesis(genesis, ethash.NewFaker(), 2, vandalTestGenerator(accounts[0], contract, &hashes))
+
+	// A transaction to be replaced by the one included in the second block
+	replaced, _ := types.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce:    1,
+		To:       &contract,
+		Value:    big.NewInt(0),
+		Gas:      100000,
+		GasPrice: big.NewInt(params.GWei),
+	}), types.HomesteadSigner{}, accounts[0].key)
+
+	pending := NewVandalPending(backend, nil, dir)
+	if err := pending.Start(); err != nil {
+		t.Fatalf("failed to start pending tracer: %v", err)
+	}
+	defer pending.Stop()
+
+	backend.txFeed.Send(core.NewTxsEvent{Txs: []*types.Transaction{blocks[0].Transactions()[0], replaced}})
+
+	path := func(hash common.Hash, suffix string) string {
+		return filepath.Join(dir, fmt.Sprintf("%#x%s.json", hash, suffix))
+	}
+	waitForFile(t, path(replaced.Hash(), ""))
+
+	blob, err := os.ReadFile(path(hashes[0], ""))
+	if err != nil {
+		t.Fatalf("missing pending trace: %v", err)
+	}
+	var trace vandalPendingTrace
+	if err := json.Unmarshal(blob, &trace); err != nil {
+		t.Fatalf("failed to decode pending trace: %v", err)
+	}
+	if trace.TxHash != hashes[0] || trace.Hash != backend.chain.Genesis().Hash() || trace.Result == nil || trace.Error != "" {
+		t.Fatalf("unexpected pending trace: %s", blob)
+	}
+	// Including the transactions resolves the pending traces
+	if _, err := backend.chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	waitForFile(t, path(hashes[0], ".confirmed"))
+	waitForFile(t, path(replaced.Hash(), ".superseded"))
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// VandalProxyAPI serves the Vandal tracing APIs by forwarding the requests to
+// a trusted upstream node, typically an archive node, and relaying its
+// results. It lets nodes without the historical state, or frontends without
+// direct access to the archive node, serve traces.
+//
+// Traces of transactions and blocks identified by hash are immutable and are
+// optionally cached. Subscriptions are not forwarded.
+type VandalProxyAPI struct {
+	client *rpc.Client
+	cache  *lru.Cache[vandalProxyKey, json.RawMessage] // Cached upstream results, nil if disabled
+}
+
+// vandalProxyKey identifies a cached upstream result.
+type vandalProxyKey struct {
+	method string
+	hash   common.Hash
+}
+
+// NewVandalProxyAPI creates a Vandal API forwarding to the given upstream
+// node, caching up to cache results.
+func NewVandalProxyAPI(client *rpc.Client, cache int) *VandalProxyAPI {
+	api := &VandalProxyAPI{client: client}
+	if cache > 0 {
+		api.cache = lru.NewCache[vandalProxyKey, json.RawMessage](cache)
+	}
+	return api
+}
+
+// VandalProxyAPIs returns the RPC services forwarding Vandal tracing requests
+// to the given upstream node.
+func VandalProxyAPIs(client *rpc.Client, config *VandalConfig) []rpc.API {
+	var (
+		cache         int
+		authenticated bool
+	)
+	if config != nil {
+		cache, authenticated = config.UpstreamCache, config.Authenticated
+	}
+	return []rpc.API{
+		{
+			Namespace:     "vandal",
+			Service:       NewVandalProxyAPI(client, cache),
+			Authenticated: authenticated,
+		},
+	}
+}
+
+// forward relays a request to the upstream node.
+func (api *VandalProxyAPI) forward(ctx context.Context, method string, args ...interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	if err := api.client.CallContext(ctx, &result, method, args...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// cached relays a request for an immutable result to the upstream node,
+// serving it from the cache if available. Only complete traces are cached,
+// not the first pages of paginated ones.
+func (api *VandalProxyAPI) cached(ctx context.Context, method string, hash common.Hash, args ...interface{}) (json.RawMessage, error) {
+	key := vandalProxyKey{method: method, hash: hash}
+	if api.cache != nil {
+		if result, ok := api.cache.Get(key); ok {
+			return result, nil
+		}
+	}
+	result, err := api.forward(ctx, method, args...)
+	if err != nil {
+		return nil, err
+	}
+	if api.cache != nil && len(result) > 0 && result[0] == '[' {
+		api.cache.Add(key, result)
+	}
+	return result, nil
+}
+
+// TraceTransaction forwards vandal_traceTransaction to the upstream node.
+func (api *VandalProxyAPI) TraceTransaction(ctx context.Context, hash common.Hash, config *TraceConfig) (json.RawMessage, error) {
+	return api.cached(ctx, "vandal_traceTransaction", hash, hash, config)
+}
+
+// TraceNextPage forwards vandal_traceNextPage to the upstream node.
+func (api *VandalProxyAPI) TraceNextPage(ctx context.Context, cursor rpc.ID) (json.RawMessage, error) {
+	return api.forward(ctx, "vandal_traceNextPage", cursor)
+}
+
+// TraceTransactions forwards vandal_traceTransactions to the upstream node.
+func (api *VandalProxyAPI) TraceTransactions(ctx context.Context, hashes []common.Hash, config *TraceConfig) (json.RawMessage, error) {
+	return api.forward(ctx, "vandal_traceTransactions", hashes, config)
+}
+
+// TraceCall forwards vandal_traceCall to the upstream node.
+func (api *VandalProxyAPI) TraceCall(ctx context.Context, args ethapi.TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (json.RawMessage, error) {
+	return api.forward(ctx, "vandal_traceCall", args, blockNrOrHash, config)
+}
+
+// TraceBundle forwards vandal_traceBundle to the upstream node.
+func (api *VandalProxyAPI) TraceBundle(ctx context.Context, bundle []ethapi.TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (json.RawMessage, error) {
+	return api.forward(ctx, "vandal_traceBundle", bundle, blockNrOrHash, config)
+}
+
+// TraceBlockByNumber forwards vandal_traceBlockByNumber to the upstream node.
+func (api *VandalProxyAPI) TraceBlockByNumber(ctx context.Context, number rpc.BlockNumber, config *TraceConfig) (json.RawMessage, error) {
+	return api.forward(ctx, "vandal_traceBlockByNumber", number, config)
+}
+
+// TraceBlockByHash forwards vandal_traceBlockByHash to the upstream node.
+func (api *VandalProxyAPI) TraceBlockByHash(ctx context.Context, hash common.Hash, config *TraceConfig) (json.RawMessage, error) {
+	return api.cached(ctx, "vandal_traceBlockByHash", hash, hash, config)
+}
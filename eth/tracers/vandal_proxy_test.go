@@ -0,0 +1,87 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// upstreamVandalAPI is a fake upstream vandal namespace counting its calls.
+type upstreamVandalAPI struct {
+	calls int
+}
+
+func (api *upstreamVandalAPI) TraceTransaction(ctx context.Context, hash common.Hash, config *TraceConfig) (json.RawMessage, error) {
+	api.calls++
+	return json.RawMessage(`[{"hash":"` + hash.Hex() + `"}]`), nil
+}
+
+func (api *upstreamVandalAPI) TraceBlockByNumber(ctx context.Context, number rpc.BlockNumber, config *TraceConfig) (json.RawMessage, error) {
+	api.calls++
+	return json.RawMessage(`[]`), nil
+}
+
+func TestVandalProxy(t *testing.T) {
+	t.Parallel()
+
+	var (
+		upstream = new(upstreamVandalAPI)
+		server   = rpc.NewServer()
+	)
+	if err := server.RegisterName("vandal", upstream); err != nil {
+		t.Fatalf("failed to register upstream: %v", err)
+	}
+	defer server.Stop()
+
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	api := NewVandalProxyAPI(client, 16)
+
+	// Transaction traces are forwarded once, then served from the cache
+	hash := common.HexToHash("0x01")
+	for i := 0; i < 2; i++ {
+		res, err := api.TraceTransaction(context.Background(), hash, nil)
+		if err != nil {
+			t.Fatalf("failed to trace transaction: %v", err)
+		}
+		if want := `[{"hash":"` + hash.Hex() + `"}]`; string(res) != want {
+			t.Fatalf("result mismatch: have %s, want %s", res, want)
+		}
+	}
+	if upstream.calls != 1 {
+		t.Fatalf("upstream calls mismatch: have %d, want %d", upstream.calls, 1)
+	}
+	// Traces by block number are always forwarded
+	for i := 0; i < 2; i++ {
+		if _, err := api.TraceBlockByNumber(context.Background(), rpc.LatestBlockNumber, nil); err != nil {
+			t.Fatalf("failed to trace block: %v", err)
+		}
+	}
+	if upstream.calls != 3 {
+		t.Fatalf("upstream calls mismatch: have %d, want %d", upstream.calls, 3)
+	}
+	// Upstream errors are relayed
+	if _, err := api.TraceBundle(context.Background(), nil, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), nil); err == nil {
+		t.Fatal("expected error for method missing upstream")
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/metrics"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// vandalRateWindow is the period over which the block tracing rate of the
+	// live tracer is measured.
+	vandalRateWindow = time.Minute
+
+	// vandalLatencySamples is the number of most recent blocks the latency
+	// percentiles of the live tracer are computed over.
+	vandalLatencySamples = 1024
+)
+
+var (
+	vandalLiveBlockMeter   = metrics.NewRegisteredMeter("vandal/live/blocks", nil)
+	vandalLiveTxMeter      = metrics.NewRegisteredMeter("vandal/live/transactions", nil)
+	vandalLiveTxErrorMeter = metrics.NewRegisteredMeter("vandal/live/transactions/errors", nil)
+	vandalLiveErrorMeter   = metrics.NewRegisteredMeter("vandal/live/errors", nil)
+	vandalLiveTraceTimer   = metrics.NewRegisteredTimer("vandal/live/trace", nil)
+	vandalLiveSinkTimer    = metrics.NewRegisteredTimer("vandal/live/sink", nil)
+	vandalLiveQueueGauge   = metrics.NewRegisteredGauge("vandal/live/queue", nil)
+	vandalLiveHeadGauge    = metrics.NewRegisteredGauge("vandal/live/head", nil)
+)
+
+// VandalLiveStats is a snapshot of the progress of the live Vandal tracer.
+type VandalLiveStats struct {
+	Started         time.Time      `json:"started"`
+	Blocks          uint64         `json:"blocks"`       // Blocks traced and written out
+	Transactions    uint64         `json:"transactions"` // Transactions traced
+	TxErrors        uint64         `json:"txErrors"`     // Transactions failing to trace
+	Errors          uint64         `json:"errors"`       // Blocks failing to trace or write out
+	LastError       string         `json:"lastError,omitempty"`
+	LastBlock       hexutil.Uint64 `json:"lastBlock"`       // Number of the last block written out
+	LastHash        common.Hash    `json:"lastHash"`        // Hash of the last block written out
+	BlocksPerSecond float64        `json:"blocksPerSecond"` // Rate over the last minute
+	QueueDepth      int            `json:"queueDepth"`      // Blocks imported but not yet traced
+	TraceLatency    VandalLatency  `json:"traceLatency"`    // Time spent tracing a block
+	SinkLatency     VandalLatency  `json:"sinkLatency"`     // Time spent writing out a block
+}
+
+// VandalLatency holds percentiles of a latency over the most recent blocks,
+// in nanoseconds.
+type VandalLatency struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// vandalLiveStats accumulates the statistics of the live Vandal tracer.
+type vandalLiveStats struct {
+	clock   mclock.Clock
+	start   mclock.AbsTime // Start of tracing, for rate measurement
+	started time.Time      // Start of tracing, for reporting
+
+	lock      sync.Mutex
+	blocks    uint64
+	txs       uint64
+	txErrors  uint64
+	errors    uint64
+	lastError string
+	lastBlock uint64
+	lastHash  common.Hash
+	recent    []mclock.AbsTime // Completion times of the blocks within the rate window
+	traced    *vandalLatencies
+	written   *vandalLatencies
+}
+
+func newVandalLiveStats(clock mclock.Clock) *vandalLiveStats {
+	return &vandalLiveStats{
+		clock:   clock,
+		start:   clock.Now(),
+		started: time.Now(),
+		traced:  newVandalLatencies(vandalLatencySamples),
+		written: newVandalLatencies(vandalLatencySamples),
+	}
+}
+
+// done records a block successfully traced and written out, along with its
+// number of transactions and of those failing to trace.
+func (s *vandalLiveStats) done(block *types.Block, txs, txErrors int, traced, written time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.blocks++
+	s.txs += uint64(txs)
+	s.txErrors += uint64(txErrors)
+	s.lastBlock, s.lastHash = block.NumberU64(), block.Hash()
+	s.traced.add(traced)
+	s.written.add(written)
+
+	vandalLiveBlockMeter.Mark(1)
+	vandalLiveTxMeter.Mark(int64(txs))
+	vandalLiveTxErrorMeter.Mark(int64(txErrors))
+	vandalLiveTraceTimer.Update(traced)
+	vandalLiveSinkTimer.Update(written)
+	vandalLiveHeadGauge.Update(int64(block.NumberU64()))
+
+	now := s.clock.Now()
+	s.expire(now)
+	s.recent = append(s.recent, now)
+}
+
+// failed records a block that could not be traced or written out.
+func (s *vandalLiveStats) failed(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.errors++
+	s.lastError = err.Error()
+	vandalLiveErrorMeter.Mark(1)
+}
+
+// expire drops the completion times that fell out of the rate window. The
+// caller must hold the lock.
+func (s *vandalLiveStats) expire(now mclock.AbsTime) {
+	var n int
+	for n < len(s.recent) && time.Duration(now-s.recent[n]) >= vandalRateWindow {
+		n++
+	}
+	s.recent = s.recent[n:]
+}
+
+// snapshot returns the current statistics.
+func (s *vandalLiveStats) snapshot(queued int) *VandalLiveStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.clock.Now()
+	s.expire(now)
+
+	// Measure the rate over the window, or since the start if more recent
+	window := vandalRateWindow
+	if elapsed := time.Duration(now - s.start); elapsed < window {
+		window = elapsed
+	}
+	var rate float64
+	if window > 0 {
+		rate = float64(len(s.recent)) / window.Seconds()
+	}
+	return &VandalLiveStats{
+		Started:         s.started,
+		Blocks:          s.blocks,
+		Transactions:    s.txs,
+		TxErrors:        s.txErrors,
+		Errors:          s.errors,
+		LastError:       s.lastError,
+		LastBlock:       hexutil.Uint64(s.lastBlock),
+		LastHash:        s.lastHash,
+		BlocksPerSecond: rate,
+		QueueDepth:      queued,
+		TraceLatency:    s.traced.percentiles(),
+		SinkLatency:     s.written.percentiles(),
+	}
+}
+
+// reset clears the statistics, returning a snapshot of them beforehand.
+func (s *vandalLiveStats) reset(queued int) *VandalLiveStats {
+	snap := s.snapshot(queued)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.start, s.started = s.clock.Now(), time.Now()
+	s.blocks, s.txs, s.txErrors, s.errors = 0, 0, 0, 0
+	s.lastError = ""
+	s.recent = nil
+	s.traced = newVandalLatencies(vandalLatencySamples)
+	s.written = newVandalLatencies(vandalLatencySamples)
+	return snap
+}
+
+// vandalLatencies is a ring buffer of the most recent latency samples.
+type vandalLatencies struct {
+	samples []time.Duration
+	next    int
+}
+
+func newVandalLatencies(size int) *vandalLatencies {
+	return &vandalLatencies{samples: make([]time.Duration, 0, size)}
+}
+
+// add records a sample, overwriting the oldest one if full.
+func (l *vandalLatencies) add(d time.Duration) {
+	if len(l.samples) < cap(l.samples) {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % len(l.samples)
+}
+
+// percentiles computes the latency percentiles of the recorded samples.
+func (l *vandalLatencies) percentiles() VandalLatency {
+	if len(l.samples) == 0 {
+		return VandalLatency{}
+	}
+	sorted := slices.Clone(l.samples)
+	slices.Sort(sorted)
+
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return VandalLatency{
+		P50: at(0.5),
+		P90: at(0.9),
+		P99: at(0.99),
+		Max: sorted[len(sorted)-1],
+	}
+}
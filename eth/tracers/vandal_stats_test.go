@@ -0,0 +1,68 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestVandalLiveStats(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock = new(mclock.Simulated)
+		stats = newVandalLiveStats(clock)
+	)
+	for i := 1; i <= 10; i++ {
+		clock.Run(time.Second)
+		block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(int64(i))})
+		stats.done(block, 2, 1, time.Duration(i)*time.Millisecond, time.Millisecond)
+	}
+	stats.failed(errors.New("oops"))
+
+	snap := stats.snapshot(3)
+	if snap.Blocks != 10 || snap.Transactions != 20 || snap.TxErrors != 10 || snap.Errors != 1 || snap.LastError != "oops" {
+		t.Fatalf("unexpected counters: %+v", snap)
+	}
+	if snap.LastBlock != 10 || snap.QueueDepth != 3 {
+		t.Fatalf("unexpected progress: %+v", snap)
+	}
+	if snap.BlocksPerSecond != 1 {
+		t.Fatalf("unexpected rate: have %v, want 1", snap.BlocksPerSecond)
+	}
+	if snap.TraceLatency.P50 != 5*time.Millisecond || snap.TraceLatency.Max != 10*time.Millisecond || snap.SinkLatency.P99 != time.Millisecond {
+		t.Fatalf("unexpected latencies: %+v %+v", snap.TraceLatency, snap.SinkLatency)
+	}
+	// Blocks traced outside the rate window no longer count
+	clock.Run(vandalRateWindow)
+	if rate := stats.snapshot(0).BlocksPerSecond; rate != 0 {
+		t.Fatalf("unexpected rate after window: have %v, want 0", rate)
+	}
+	// Resetting returns the previous values and clears the counters
+	if prev := stats.reset(0); prev.Blocks != 10 {
+		t.Fatalf("unexpected stats before reset: %+v", prev)
+	}
+	if snap := stats.snapshot(0); snap.Blocks != 0 || snap.Errors != 0 || snap.LastError != "" || snap.TraceLatency.Max != 0 {
+		t.Fatalf("stats not reset: %+v", snap)
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	// vandalWebhookQueue is the maximum number of notifications waiting to be
+	// delivered to a webhook, newer ones being dropped when exceeded.
+	vandalWebhookQueue = 256
+
+	// vandalWebhookAttempts is the number of times delivering a notification
+	// is attempted before giving up on it.
+	vandalWebhookAttempts = 5
+
+	// vandalWebhookBackoff is the delay before retrying a failed delivery,
+	// doubled on every further attempt.
+	vandalWebhookBackoff = time.Second
+
+	// vandalWebhookTimeout is the timeout of a single delivery attempt.
+	vandalWebhookTimeout = 10 * time.Second
+)
+
+var (
+	vandalWebhookDeliveredMeter = metrics.NewRegisteredMeter("vandal/webhooks/delivered", nil)
+	vandalWebhookFailedMeter    = metrics.NewRegisteredMeter("vandal/webhooks/failed", nil)
+	vandalWebhookDroppedMeter   = metrics.NewRegisteredMeter("vandal/webhooks/dropped", nil)
+)
+
+// VandalWebhook configures a URL notified of the transactions traced by the
+// live tracer that match its filters. Filters of different kinds must all
+// match, an empty filter matching every transaction.
+type VandalWebhook struct {
+	URL string
+
+	// Secret the notifications are signed with, sent as the hex encoded
+	// HMAC-SHA256 of the body in the X-Vandal-Signature header.
+	Secret string `toml:",omitempty"`
+
+	// Addresses matches transactions sent from or to one of the addresses,
+	// creating one of them or emitting a log from one of them.
+	Addresses []common.Address `toml:",omitempty"`
+
+	// Topics matches transactions emitting a log with one of the topics as
+	// its first topic, i.e. one of the given events.
+	Topics []common.Hash `toml:",omitempty"`
+
+	// FailedOnly matches transactions whose execution failed.
+	FailedOnly bool `toml:",omitempty"`
+}
+
+// WebhookBackend extends the live tracing backend with the receipt access
+// needed to filter traced transactions.
+type WebhookBackend interface {
+	LiveBackend
+	GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error)
+}
+
+// vandalWebhookEvent is the notification delivered to webhooks, a summary of
+// a traced transaction.
+type vandalWebhookEvent struct {
+	Block   hexutil.Uint64  `json:"block"`           // Block number containing the transaction
+	Hash    common.Hash     `json:"hash"`            // Block hash containing the transaction
+	TxHash  common.Hash     `json:"txHash"`          // Transaction hash
+	From    common.Address  `json:"from"`            // Sender of the transaction
+	To      *common.Address `json:"to,omitempty"`    // Recipient, nil for contract creations
+	Value   *hexutil.Big    `json:"value"`           // Ether transferred to the recipient
+	Status  hexutil.Uint64  `json:"status"`          // Execution status of the receipt
+	GasUsed hexutil.Uint64  `json:"gasUsed"`         // Gas used by the transaction
+	Logs    []*types.Log    `json:"logs"`            // Logs emitted by the transaction
+	Error   string          `json:"error,omitempty"` // Trace failure produced by the tracer
+	created common.Address  // Address of the contract created, if any
+}
+
+// VandalWebhooks is a node service delivering summaries of the transactions
+// traced by the live tracer to webhooks, with retries. Notifications are
+// delivered in order per webhook; a webhook falling behind loses the newest
+// notifications rather than slowing down tracing.
+type VandalWebhooks struct {
+	backend WebhookBackend
+	live    *VandalLive
+	hooks   []*vandalWebhook
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// vandalWebhook is a configured webhook with its delivery queue.
+type vandalWebhook struct {
+	config    VandalWebhook
+	addresses map[common.Address]struct{}
+	topics    map[common.Hash]struct{}
+	queue     chan []byte
+	client    *http.Client
+	backoff   time.Duration
+}
+
+// NewVandalWebhooks creates a notifier delivering the traces of the given live
+// tracer to the given webhooks.
+func NewVandalWebhooks(backend WebhookBackend, live *VandalLive, hooks []VandalWebhook) *VandalWebhooks {
+	w := &VandalWebhooks{
+		backend: backend,
+		live:    live,
+		quit:    make(chan struct{}),
+	}
+	for _, config := range hooks {
+		hook := &vandalWebhook{
+			config:    config,
+			addresses: make(map[common.Address]struct{}),
+			topics:    make(map[common.Hash]struct{}),
+			queue:     make(chan []byte, vandalWebhookQueue),
+			client:    &http.Client{Timeout: vandalWebhookTimeout},
+			backoff:   vandalWebhookBackoff,
+		}
+		for _, addr := range config.Addresses {
+			hook.addresses[addr] = struct{}{}
+		}
+		for _, topic := range config.Topics {
+			hook.topics[topic] = struct{}{}
+		}
+		w.hooks = append(w.hooks, hook)
+	}
+	return w
+}
+
+// Start implements node.Lifecycle, starting to deliver notifications.
+func (w *VandalWebhooks) Start() error {
+	var (
+		traces = make(chan *vandalTxTraceResult, chainEventChanSize)
+		sub    = w.live.subscribeTraces(traces)
+	)
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer sub.Unsubscribe()
+		w.loop(traces)
+	}()
+	for _, hook := range w.hooks {
+		w.wg.Add(1)
+		go func(hook *vandalWebhook) {
+			defer w.wg.Done()
+			hook.deliver(w.quit)
+		}(hook)
+	}
+	log.Info("Started Vandal webhooks", "hooks", len(w.hooks))
+	return nil
+}
+
+// Stop implements node.Lifecycle, dropping the undelivered notifications.
+func (w *VandalWebhooks) Stop() error {
+	close(w.quit)
+	w.wg.Wait()
+
+	log.Info("Stopped Vandal webhooks")
+	return nil
+}
+
+// loop matches every traced transaction against the webhooks and queues the
+// notifications.
+func (w *VandalWebhooks) loop(traces chan *vandalTxTraceResult) {
+	var (
+		block    *types.Block
+		receipts types.Receipts
+	)
+	for {
+		select {
+		case trace := <-traces:
+			// Retrieve the block and receipts of the trace, once per block
+			if block == nil || block.Hash() != trace.Hash {
+				var err error
+				if block, err = w.backend.BlockByHash(context.Background(), trace.Hash); err == nil && block != nil {
+					receipts, err = w.backend.GetReceipts(context.Background(), trace.Hash)
+				}
+				if err != nil || block == nil || len(receipts) != len(block.Transactions()) {
+					log.Warn("Failed to retrieve traced block for webhooks", "number", trace.Block, "hash", trace.Hash, "err", err)
+					block = nil
+					continue
+				}
+			}
+			event := w.event(block, receipts, trace)
+			if event == nil {
+				continue
+			}
+			var blob []byte
+			for _, hook := range w.hooks {
+				if !hook.matches(event) {
+					continue
+				}
+				if blob == nil {
+					var err error
+					if blob, err = json.Marshal(event); err != nil {
+						log.Warn("Failed to encode webhook notification", "tx", trace.TxHash, "err", err)
+						break
+					}
+				}
+				select {
+				case hook.queue <- blob:
+				default:
+					vandalWebhookDroppedMeter.Mark(1)
+					log.Warn("Dropping webhook notification", "url", hook.config.URL, "tx", trace.TxHash)
+				}
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// event summarizes the given traced transaction of a block.
+func (w *VandalWebhooks) event(block *types.Block, receipts types.Receipts, trace *vandalTxTraceResult) *vandalWebhookEvent {
+	signer := types.MakeSigner(w.backend.ChainConfig(), block.Number(), block.Time())
+	for i, tx := range block.Transactions() {
+		if tx.Hash() != trace.TxHash {
+			continue
+		}
+		from, _ := types.Sender(signer, tx)
+		return &vandalWebhookEvent{
+			Block:   trace.Block,
+			Hash:    trace.Hash,
+			TxHash:  trace.TxHash,
+			From:    from,
+			To:      tx.To(),
+			Value:   (*hexutil.Big)(tx.Value()),
+			Status:  hexutil.Uint64(receipts[i].Status),
+			GasUsed: hexutil.Uint64(receipts[i].GasUsed),
+			Logs:    receipts[i].Logs,
+			Error:   trace.Error,
+			created: receipts[i].ContractAddress,
+		}
+	}
+	return nil
+}
+
+// matches reports whether the given transaction passes the webhook's filters.
+func (h *vandalWebhook) matches(event *vandalWebhookEvent) bool {
+	if h.config.FailedOnly && event.Status != hexutil.Uint64(types.ReceiptStatusFailed) {
+		return false
+	}
+	if len(h.addresses) > 0 {
+		_, match := h.addresses[event.From]
+		if event.To != nil {
+			_, to := h.addresses[*event.To]
+			match = match || to
+		} else {
+			_, created := h.addresses[event.created]
+			match = match || created
+		}
+		for _, l := range event.Logs {
+			_, emitter := h.addresses[l.Address]
+			match = match || emitter
+		}
+		if !match {
+			return false
+		}
+	}
+	if len(h.topics) > 0 {
+		var match bool
+		for _, l := range event.Logs {
+			if len(l.Topics) > 0 {
+				_, topic := h.topics[l.Topics[0]]
+				match = match || topic
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver posts the queued notifications to the webhook until quit is closed.
+func (h *vandalWebhook) deliver(quit chan struct{}) {
+	for {
+		select {
+		case blob := <-h.queue:
+			backoff := h.backoff
+			for attempt := 1; ; attempt++ {
+				err := h.post(blob)
+				if err == nil {
+					vandalWebhookDeliveredMeter.Mark(1)
+					break
+				}
+				if attempt == vandalWebhookAttempts {
+					vandalWebhookFailedMeter.Mark(1)
+					log.Warn("Failed to deliver webhook notification", "url", h.config.URL, "attempts", attempt, "err", err)
+					break
+				}
+				select {
+				case <-time.After(backoff):
+					backoff *= 2
+				case <-quit:
+					return
+				}
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// post sends a single notification to the webhook.
+func (h *vandalWebhook) post(blob []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.config.URL, bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.config.Secret))
+		mac.Write(blob)
+		req.Header.Set("X-Vandal-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
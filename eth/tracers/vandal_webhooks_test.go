@@ -0,0 +1,116 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// webhookTestBackend extends the live test backend with receipt access.
+type webhookTestBackend struct {
+	*liveTestBackend
+}
+
+func (b *webhookTestBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	return b.chain.GetReceiptsByHash(hash), nil
+}
+
+func TestVandalWebhooks(t *testing.T) {
+	t.Parallel()
+
+	var (
+		genesis, accounts, contract = newVandalTestGenesis()
+		hashes                      []common.Hash
+		backend                     = &webhookTestBackend{&liveTestBackend{newTestBackend(t, 0, genesis, nil)}}
+		secret                      = "secret"
+		failures                    atomic.Int32
+		received                    = make(chan *vandalWebhookEvent, 10)
+	)
+	defer backend.teardown()
+
+	// The webhook rejects the first delivery to exercise retries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get("X-Vandal-Signature") != want {
+			t.Errorf("invalid signature: have %s, want %s", r.Header.Get("X-Vandal-Signature"), want)
+		}
+		var event vandalWebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("failed to decode notification: %v", err)
+		}
+		received <- &event
+	}))
+	defer server.Close()
+
+	_, blocks, _ := core.GenerateChainWithGenesis(genesis, ethash.NewFaker(), 2, vandalTestGenerator(accounts[0], contract, &hashes))
+
+	live := NewVandalLive(backend, nil, t.TempDir())
+	hooks := NewVandalWebhooks(backend, live, []VandalWebhook{
+		{URL: server.URL, Secret: secret, Addresses: []common.Address{contract}},
+		{URL: server.URL, FailedOnly: true}, // Nothing fails, never notified
+	})
+	hooks.hooks[0].backoff = time.Millisecond
+
+	if err := live.Start(); err != nil {
+		t.Fatalf("failed to start live tracer: %v", err)
+	}
+	defer live.Stop()
+	if err := hooks.Start(); err != nil {
+		t.Fatalf("failed to start webhooks: %v", err)
+	}
+	defer hooks.Stop()
+
+	if _, err := backend.chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	for i := range blocks {
+		select {
+		case event := <-received:
+			if event.TxHash != hashes[i] || event.From != accounts[0].addr || *event.To != contract || event.Status != 1 {
+				t.Fatalf("unexpected notification %d: %+v", i, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for notification %d", i)
+		}
+	}
+	select {
+	case event := <-received:
+		t.Fatalf("unexpected notification: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -19,6 +19,7 @@ package graphql
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
@@ -35,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/eth/tracers"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
@@ -1381,6 +1383,28 @@ func (r *Resolver) Transaction(ctx context.Context, args struct{ Hash common.Has
 	return tx
 }
 
+func (r *Resolver) VandalTrace(ctx context.Context, args struct{ Hash common.Hash }) (*string, error) {
+	backend, ok := r.backend.(tracers.Backend)
+	if !ok {
+		return nil, errors.New("vandal tracing not supported by the backend")
+	}
+	// Resolve the transaction first; if it doesn't exist, return nil.
+	tx := &Transaction{r: r, hash: args.Hash}
+	if t, _ := tx.resolve(ctx); t == nil {
+		return nil, nil
+	}
+	res, err := tracers.NewVandalAPI(backend).TraceTransaction(ctx, args.Hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	trace := string(blob)
+	return &trace, nil
+}
+
 func (r *Resolver) SendRawTransaction(ctx context.Context, args struct{ Data hexutil.Bytes }) (common.Hash, error) {
 	tx := new(types.Transaction)
 	if err := tx.UnmarshalBinary(args.Data); err != nil {
@@ -485,3 +485,63 @@ func newGQLService(t *testing.T, stack *node.Node, shanghai bool, gspec *core.Ge
 	}
 	return handler, chain
 }
+
+func TestVandalTrace(t *testing.T) {
+	var (
+		key, _   = crypto.GenerateKey()
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0xdeadbeef")
+
+		genesis = &core.Genesis{
+			Config:     params.AllEthashProtocolChanges,
+			GasLimit:   11500000,
+			Difficulty: common.Big1,
+			Alloc: types.GenesisAlloc{
+				addr:     {Balance: big.NewInt(params.Ether)},
+				contract: {Code: common.FromHex("0x600160005500")}, // PUSH1 1 PUSH1 0 SSTORE STOP
+			},
+		}
+		signer = types.LatestSigner(genesis.Config)
+		stack  = createNode(t)
+	)
+	defer stack.Close()
+
+	handler, chain := newGQLService(t, stack, true, genesis, 1, func(i int, gen *core.BlockGen) {
+		tx, _ := types.SignNewTx(key, signer, &types.LegacyTx{To: &contract, Gas: 100000, GasPrice: big.NewInt(params.InitialBaseFee)})
+		gen.AddTx(tx)
+	})
+	// start node
+	if err := stack.Start(); err != nil {
+		t.Fatalf("could not start node: %v", err)
+	}
+	hash := chain[0].Transactions()[0].Hash()
+
+	res := handler.Schema.Exec(context.Background(), fmt.Sprintf(`{vandalTrace(hash: "%#x")}`, hash), "", map[string]interface{}{})
+	if res.Errors != nil {
+		t.Fatalf("failed to execute query: %v", res.Errors)
+	}
+	var have struct {
+		VandalTrace *string `json:"vandalTrace"`
+	}
+	if err := json.Unmarshal(res.Data, &have); err != nil {
+		t.Fatalf("failed to decode graphql response: %v", err)
+	}
+	if have.VandalTrace == nil {
+		t.Fatalf("missing trace for transaction %#x", hash)
+	}
+	var blocks []json.RawMessage
+	if err := json.Unmarshal([]byte(*have.VandalTrace), &blocks); err != nil {
+		t.Fatalf("trace is not a list of basic blocks: %v", err)
+	}
+	if len(blocks) == 0 {
+		t.Fatalf("empty trace for transaction %#x", hash)
+	}
+	// Unknown transactions resolve to null
+	res = handler.Schema.Exec(context.Background(), fmt.Sprintf(`{vandalTrace(hash: "%#x")}`, common.Hash{}), "", map[string]interface{}{})
+	if res.Errors != nil {
+		t.Fatalf("failed to execute query: %v", res.Errors)
+	}
+	if string(res.Data) != `{"vandalTrace":null}` {
+		t.Errorf("unexpected response for unknown transaction: %s", res.Data)
+	}
+}
@@ -373,6 +373,10 @@ const schema string = `
         pending: Pending!
         # Transaction returns a transaction specified by its hash.
         transaction(hash: Bytes32!): Transaction
+        # VandalTrace re-executes the transaction specified by its hash with the
+        # Vandal logger and returns the resulting basic blocks JSON encoded, or
+        # null if the transaction is unknown.
+        vandalTrace(hash: Bytes32!): String
         # Logs returns log entries matching the provided filter.
         logs(filter: FilterCriteria!): [Log!]!
         # GasPrice returns the node's estimate of a gas price sufficient to
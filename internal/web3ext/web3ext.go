@@ -31,6 +31,7 @@ var Modules = map[string]string{
 	"les":      LESJs,
 	"vflux":    VfluxJs,
 	"dev":      DevJs,
+	"vandal":   VandalJs,
 }
 
 const CliqueJs = `
@@ -894,3 +895,93 @@ web3._extend({
 	],
 });
 `
+
+const VandalJs = `
+web3._extend({
+	property: 'vandal',
+	methods:
+	[
+		new web3._extend.Method({
+			name: 'traceTransaction',
+			call: 'vandal_traceTransaction',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
+		new web3._extend.Method({
+			name: 'traceTransactions',
+			call: 'vandal_traceTransactions',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
+		new web3._extend.Method({
+			name: 'traceBlockByNumber',
+			call: 'vandal_traceBlockByNumber',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
+		new web3._extend.Method({
+			name: 'traceBlockByHash',
+			call: 'vandal_traceBlockByHash',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
+		new web3._extend.Method({
+			name: 'traceCall',
+			call: 'vandal_traceCall',
+			params: 3,
+			inputFormatter: [null, null, null]
+		}),
+		new web3._extend.Method({
+			name: 'traceBundle',
+			call: 'vandal_traceBundle',
+			params: 3,
+			inputFormatter: [null, null, null]
+		}),
+		new web3._extend.Method({
+			name: 'traceNextPage',
+			call: 'vandal_traceNextPage',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'submitTraceJob',
+			call: 'vandal_submitTraceJob',
+			params: 3,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
+		new web3._extend.Method({
+			name: 'exportTraceRange',
+			call: 'vandal_exportTraceRange',
+			params: 4,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, web3._extend.formatters.inputBlockNumberFormatter, null, null]
+		}),
+		new web3._extend.Method({
+			name: 'traceJobStatus',
+			call: 'vandal_traceJobStatus',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'rotateLiveDir',
+			call: 'vandal_rotateLiveDir'
+		}),
+		new web3._extend.Method({
+			name: 'resetLiveStats',
+			call: 'vandal_resetLiveStats'
+		}),
+		new web3._extend.Method({
+			name: 'cancelTraceJob',
+			call: 'vandal_cancelTraceJob',
+			params: 1
+		}),
+	],
+	properties: [
+		new web3._extend.Property({
+			name: 'traceJobs',
+			getter: 'vandal_traceJobs'
+		}),
+		new web3._extend.Property({
+			name: 'liveStats',
+			getter: 'vandal_liveStats'
+		}),
+	]
+});
+`
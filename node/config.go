@@ -164,6 +164,10 @@ type Config struct {
 	// cannot verify the validity of the request header.
 	WSOrigins []string `toml:",omitempty"`
 
+	// WSCompression enables per-message compression of WebSocket traffic with
+	// clients supporting it. Useful when serving large responses, such as traces.
+	WSCompression bool `toml:",omitempty"`
+
 	// WSModules is a list of API modules to expose via the websocket RPC interface.
 	// If the module list is empty, all RPC API endpoints designated public will be
 	// exposed.
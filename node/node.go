@@ -441,6 +441,7 @@ func (n *Node) startRPC() error {
 			Modules:           n.config.WSModules,
 			Origins:           n.config.WSOrigins,
 			prefix:            n.config.WSPathPrefix,
+			compression:       n.config.WSCompression,
 			rpcEndpointConfig: rpcConfig,
 		}); err != nil {
 			return err
@@ -47,9 +47,10 @@ type httpConfig struct {
 
 // wsConfig is the JSON-RPC/Websocket configuration
 type wsConfig struct {
-	Origins []string
-	Modules []string
-	prefix  string // path prefix on which to mount ws handler
+	Origins     []string
+	Modules     []string
+	prefix      string // path prefix on which to mount ws handler
+	compression bool   // whether to negotiate per-message compression
 	rpcEndpointConfig
 }
 
@@ -344,6 +345,7 @@ func (h *httpServer) enableWS(apis []rpc.API, config wsConfig) error {
 	if config.httpBodyLimit > 0 {
 		srv.SetHTTPBodyLimit(config.httpBodyLimit)
 	}
+	srv.SetWebsocketCompression(config.compression)
 	if err := RegisterApis(apis, config.Modules, srv); err != nil {
 		return err
 	}
@@ -52,6 +52,7 @@ type Server struct {
 	batchItemLimit     int
 	batchResponseLimit int
 	httpBodyLimit      int
+	wsCompression      bool
 }
 
 // NewServer creates a new server instance with no registered handlers.
@@ -87,6 +88,14 @@ func (s *Server) SetHTTPBodyLimit(limit int) {
 	s.httpBodyLimit = limit
 }
 
+// SetWebsocketCompression enables negotiation of per-message compression with
+// WebSocket clients, which considerably shrinks large responses on the wire.
+//
+// This method should be called before creating handlers via WebsocketHandler.
+func (s *Server) SetWebsocketCompression(enabled bool) {
+	s.wsCompression = enabled
+}
+
 // RegisterName creates a service for the given receiver type under the given name. When no
 // methods on the given receiver match the criteria to be either a RPC method or a
 // subscription an error is returned. Otherwise a new service is created and added to the
@@ -113,6 +113,47 @@ func TestWebsocketLargeCall(t *testing.T) {
 	}
 }
 
+// This test checks that per-message compression is only negotiated when enabled
+// on the server.
+func TestWebsocketCompression(t *testing.T) {
+	t.Parallel()
+
+	for _, enabled := range []bool{false, true} {
+		srv := newTestServer()
+		srv.SetWebsocketCompression(enabled)
+		httpsrv := httptest.NewServer(srv.WebsocketHandler([]string{"*"}))
+		wsURL := "ws:" + strings.TrimPrefix(httpsrv.URL, "http:")
+
+		dialer := websocket.Dialer{EnableCompression: true}
+		conn, resp, err := dialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("can't dial: %v", err)
+		}
+		negotiated := strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+		if negotiated != enabled {
+			t.Errorf("compression negotiated: have %v, want %v", negotiated, enabled)
+		}
+		conn.Close()
+
+		// Compressed connections must still serve calls.
+		client, err := DialOptions(context.Background(), wsURL, WithWebsocketDialer(dialer))
+		if err != nil {
+			t.Fatalf("can't dial: %v", err)
+		}
+		var result echoResult
+		arg := strings.Repeat("x", 100000)
+		if err := client.Call(&result, "test_echo", arg, 1); err != nil {
+			t.Fatalf("call failed: %v", err)
+		}
+		if result.String != arg {
+			t.Fatal("wrong string echoed")
+		}
+		client.Close()
+		httpsrv.Close()
+		srv.Stop()
+	}
+}
+
 // This test checks whether the wsMessageSizeLimit option is obeyed.
 func TestWebsocketLargeRead(t *testing.T) {
 	t.Parallel()